@@ -0,0 +1,57 @@
+package audit
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"mvpmatch/models"
+	"mvpmatch/repository"
+)
+
+// Actions an admin can take, each one leaves an entry in the audit log.
+const (
+	SuspendUser        = "user.suspend"
+	RestoreUser        = "user.restore"
+	EditProduct        = "product.edit"
+	DeleteProduct      = "product.delete"
+	RefundOrder        = "order.refund"
+	AdjustCoins        = "coins.adjust"
+	PayOutDeposit      = "deposit.payout"
+	AddMachine         = "machine.add"
+	AddSlot            = "slot.add"
+	AddDenomination    = "denomination.add"
+	DeleteDenomination = "denomination.delete"
+	EditRole           = "role.edit"
+)
+
+// Kinds of record an action targets.
+const (
+	User    = "user"
+	Product = "product"
+	Order   = "order"
+	Machine = "machine"
+	Slot    = "slot"
+	Role    = "role"
+)
+
+// Record writes an entry to the audit log with details saved as JSON. It should run
+// inside the transaction of the action, so an action is never kept without its entry.
+func Record(tx repository.Store, entry models.AuditLog, details interface{}) error {
+
+	if entry.ActorID == 0 {
+		return errors.New("audit entry actor is required")
+	}
+	if entry.Action == "" {
+		return errors.New("audit entry action is required")
+	}
+
+	if details != nil {
+		data, err := json.Marshal(details)
+		if err != nil {
+			return errors.Wrap(err, "audit entry details")
+		}
+		entry.Details = string(data)
+	}
+
+	entry.ID = 0
+	return tx.AuditLogs().Create(&entry)
+}
@@ -0,0 +1,116 @@
+package change
+
+import (
+	"github.com/pkg/errors"
+	"mvpmatch/models"
+	"sort"
+)
+
+// ErrCannotMakeChange is returned when the coins in the inventory
+// cannot add up to the requested amount.
+var ErrCannotMakeChange = errors.New("cannot make change with the available coins")
+
+// Make returns the fewest-coin combination, keyed by denomination, that adds up
+// to amount using only the coins available in the inventory.
+// Nothing is taken from the inventory when ErrCannotMakeChange is returned.
+func Make(amount int, inventory []models.Coin) (map[int]int, error) {
+
+	breakdown := make(map[int]int)
+	if amount == 0 {
+		return breakdown, nil
+	}
+	if amount < 0 {
+		return nil, errors.New("change amount cannot be negative")
+	}
+
+	//merge duplicate denominations and drop empty ones
+	available := make(map[int]int)
+	for _, coin := range inventory {
+		if coin.Denomination > 0 && coin.Count > 0 {
+			available[coin.Denomination] += coin.Count
+		}
+	}
+
+	var denominations []int
+	for denomination := range available {
+		denominations = append(denominations, denomination)
+	}
+	sort.Ints(denominations)
+
+	const unreachable = -1
+
+	//best[v] holds the fewest coins that add up to v using the denominations seen so far
+	best := make([]int, amount+1)
+	for v := 1; v <= amount; v++ {
+		best[v] = unreachable
+	}
+
+	//used[i][v] is how many coins of denominations[i] the best combination for v takes
+	used := make([][]int, len(denominations))
+
+	for i, denomination := range denominations {
+		limit := available[denomination]
+		if most := amount / denomination; limit > most {
+			limit = most
+		}
+
+		used[i] = make([]int, amount+1)
+		previous := make([]int, amount+1)
+		copy(previous, best)
+
+		for v := denomination; v <= amount; v++ {
+			for n := 1; n <= limit && n*denomination <= v; n++ {
+				rest := previous[v-n*denomination]
+				if rest == unreachable {
+					continue
+				}
+				if best[v] == unreachable || rest+n < best[v] {
+					best[v] = rest + n
+					used[i][v] = n
+				}
+			}
+		}
+	}
+
+	if best[amount] == unreachable {
+		return nil, ErrCannotMakeChange
+	}
+
+	remaining := amount
+	for i := len(denominations) - 1; i >= 0; i-- {
+		n := used[i][remaining]
+		if n > 0 {
+			breakdown[denominations[i]] = n
+			remaining = remaining - n*denominations[i]
+		}
+	}
+
+	return breakdown, nil
+}
+
+// Denominations lists the denominations used in a breakdown, largest first.
+func Denominations(breakdown map[int]int) []int {
+
+	denominations := make([]int, 0)
+	for denomination, count := range breakdown {
+		if count > 0 {
+			denominations = append(denominations, denomination)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(denominations)))
+
+	return denominations
+}
+
+// Coins flattens a breakdown into a list of coins, largest first.
+func Coins(breakdown map[int]int) []int {
+
+	coins := make([]int, 0)
+	for _, denomination := range Denominations(breakdown) {
+		for i := 0; i < breakdown[denomination]; i++ {
+			coins = append(coins, denomination)
+		}
+	}
+
+	return coins
+}
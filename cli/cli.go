@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"io"
+	"log"
+	"mvpmatch/database"
+	"os"
+	"strconv"
+)
+
+type command struct {
+	usage   string
+	summary string
+	run     func(args []string) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"serve":       {"serve", "start the API server", serve},
+		"migrate":     {"migrate up | down [steps] | status", "change or show the schema version", migrate},
+		"seed":        {"seed [--demo=false] [--password=demo]", "add roles, the default machine and demo data", seed},
+		"create-user": {"create-user --username= --password= --role=", "add a user with any role", createUser},
+		"coins":       {"coins load [--machine=id] coin=count ... | rebuild", "restock the coins of a machine or recount them from their history", coins},
+	}
+}
+
+// errUsage marks a command called with the wrong arguments.
+var errUsage = errors.New("usage")
+
+// Run executes the sub-command named by the first argument, serve when there is none,
+// and returns the exit code of the process.
+func Run(args []string) int {
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(os.Stdout)
+		return 0
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		usage(os.Stderr)
+		return 2
+	}
+
+	if err := cmd.run(args[1:]); err != nil {
+		if errors.Is(err, errUsage) || errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "usage: "+cmd.usage)
+			return 2
+		}
+		log.Println(err)
+		return 1
+	}
+	return 0
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "commands:")
+	for _, name := range []string{"serve", "migrate", "seed", "create-user", "coins"} {
+		fmt.Fprintf(w, "  %-46s %s\n", commands[name].usage, commands[name].summary)
+	}
+}
+
+// flags returns a flag set that reports errors to the caller instead of exiting.
+func flags(name string) *flag.FlagSet {
+	set := flag.NewFlagSet(name, flag.ContinueOnError)
+	set.SetOutput(os.Stderr)
+	return set
+}
+
+// open connects to the configured database and refuses to go on while migrations are pending.
+func open() (*gorm.DB, error) {
+	db, err := database.Connect()
+	if err != nil {
+		return nil, err
+	}
+
+	pending, err := database.Pending(db)
+	if err != nil {
+		return nil, err
+	}
+	if pending > 0 {
+		return nil, errors.New("database has " + strconv.Itoa(pending) + " pending migrations, run: migrate up")
+	}
+	return db, nil
+}
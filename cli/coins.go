@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"mvpmatch/inventory"
+	"mvpmatch/models"
+	"mvpmatch/repository"
+	"strconv"
+	"strings"
+)
+
+// coins runs: coins load [--machine=id] coin=count ..., machine defaults to the default machine,
+// or: coins rebuild, which recounts the coins of every machine from the movement history.
+func coins(args []string) error {
+	if len(args) == 1 && args[0] == "rebuild" {
+		return rebuildCoins()
+	}
+	if len(args) == 0 || args[0] != "load" {
+		return errUsage
+	}
+
+	set := flags("coins load")
+	machineID := set.Uint("machine", 0, "id of the machine, the default machine when not set")
+	if err := set.Parse(args[1:]); err != nil {
+		return err
+	}
+	if set.NArg() == 0 {
+		return errUsage
+	}
+
+	deltas, err := parseCoins(set.Args())
+	if err != nil {
+		return err
+	}
+
+	db, err := open()
+	if err != nil {
+		return err
+	}
+	store := repository.NewGorm(db)
+
+	var loaded []models.Coin
+	err = store.Transaction(func(tx repository.Store) error {
+		loaded, err = loadCoins(tx, uint(*machineID), deltas)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, coin := range loaded {
+		fmt.Printf("machine %d: %d x %d\n", coin.MachineID, coin.Count, coin.Denomination)
+	}
+	return nil
+}
+
+// rebuildCoins recounts every coin from the movement history and prints the counts after.
+func rebuildCoins() error {
+	db, err := open()
+	if err != nil {
+		return err
+	}
+	store := repository.NewGorm(db)
+
+	var rebuilt []models.Coin
+	err = store.Transaction(func(tx repository.Store) error {
+		rebuilt, err = inventory.Rebuild(tx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, coin := range rebuilt {
+		fmt.Printf("machine %d: %d x %d\n", coin.MachineID, coin.Count, coin.Denomination)
+	}
+	return nil
+}
+
+// parseCoins reads coin=count pairs such as 5=20 into the counts to add.
+func parseCoins(pairs []string) (map[int]int, error) {
+	deltas := map[int]int{}
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.New(pair + " is not coin=count")
+		}
+		coin, err := strconv.Atoi(parts[0])
+		if err != nil || coin < 1 {
+			return nil, errors.New(pair + ": coin must be a positive number")
+		}
+		count, err := strconv.Atoi(parts[1])
+		if err != nil || count < 1 {
+			return nil, errors.New(pair + ": count must be a positive number")
+		}
+		deltas[coin] += count
+	}
+	return deltas, nil
+}
+
+// loadCoins restocks a machine with coins it accepts and returns its coin counts after.
+func loadCoins(tx repository.Store, machineID uint, deltas map[int]int) ([]models.Coin, error) {
+	var machine models.Machine
+	var err error
+	if machineID == 0 {
+		machine, err = tx.Machines().Default()
+	} else {
+		machine, err = tx.Machines().Find(machineID)
+	}
+	if err != nil {
+		return nil, errors.New("machine not found")
+	}
+
+	accepted, err := tx.Coins().Denominations(machine.ID)
+	if err != nil {
+		return nil, err
+	}
+	for coin := range deltas {
+		if !contains(accepted, coin) {
+			return nil, errors.New("machine " + strconv.Itoa(int(machine.ID)) + " does not accept " + strconv.Itoa(coin))
+		}
+	}
+
+	movement := models.CoinMovement{Kind: inventory.Restock, MachineID: machine.ID}
+	if err := inventory.Apply(tx, movement, deltas); err != nil {
+		return nil, err
+	}
+
+	return tx.Coins().Lock(machine.ID, accepted)
+}
+
+func contains(list []int, value int) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
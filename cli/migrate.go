@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"mvpmatch/database"
+	"strconv"
+)
+
+// migrate runs: migrate up, migrate down [steps] or migrate status.
+func migrate(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	db, err := database.Connect()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		done, err := database.MigrateUp(db)
+		for _, item := range done {
+			fmt.Printf("applied %d %s\n", item.Version, item.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(done) == 0 {
+			fmt.Println("nothing to migrate")
+		}
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil {
+				return errors.New("steps must be a number")
+			}
+		}
+		done, err := database.MigrateDown(db, steps)
+		for _, item := range done {
+			fmt.Printf("rolled back %d %s\n", item.Version, item.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(done) == 0 {
+			fmt.Println("nothing to roll back")
+		}
+
+	case "status":
+		list, err := database.Migrations(db)
+		if err != nil {
+			return err
+		}
+		for _, item := range list {
+			state := "pending"
+			if item.Applied {
+				state = "applied " + item.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%4d %-40s %s\n", item.Version, item.Name, state)
+		}
+
+	default:
+		return errUsage
+	}
+
+	return nil
+}
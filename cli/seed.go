@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"fmt"
+	"mvpmatch/config"
+	"mvpmatch/database"
+	"mvpmatch/models"
+	"mvpmatch/repository"
+)
+
+// demoProducts are stocked in the default machine for the demo seller.
+var demoProducts = []models.Product{
+	{ProductName: "Water", Cost: 25, AmountAvailable: 20},
+	{ProductName: "Cola", Cost: 35, AmountAvailable: 20},
+	{ProductName: "Crisps", Cost: 50, AmountAvailable: 10},
+	{ProductName: "Chocolate", Cost: 65, AmountAvailable: 10},
+}
+
+// demoFloat is the number of each accepted coin a machine without coins starts with.
+const demoFloat = 20
+
+// seed adds the roles and the default machine, then demo users, products and a coin float.
+// Anything that already exists is left alone, so it can run again.
+func seed(args []string) error {
+	set := flags("seed")
+	demo := set.Bool("demo", true, "add demo users, products and a coin float")
+	password := set.String("password", "demo", "password of the demo users")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	if set.NArg() > 0 {
+		return errUsage
+	}
+
+	db, err := open()
+	if err != nil {
+		return err
+	}
+
+	database.Seed(db)
+	fmt.Println("seeded roles, permissions and the default machine")
+
+	if !*demo {
+		return nil
+	}
+
+	return repository.NewGorm(db).Transaction(func(tx repository.Store) error {
+		return seedDemo(tx, *password)
+	})
+}
+
+func seedDemo(tx repository.Store, password string) error {
+	machine, err := tx.Machines().Default()
+	if err != nil {
+		return err
+	}
+
+	users := map[string]string{
+		"demo_seller": config.Role.Seller,
+		"demo_buyer":  config.Role.Buyer,
+	}
+	for _, username := range []string{"demo_seller", "demo_buyer"} {
+		if _, err := tx.Users().FindByUsername(username); err == nil {
+			continue
+		}
+		if _, err := addUser(tx, username, password, users[username]); err != nil {
+			return err
+		}
+		fmt.Println("created " + users[username] + " " + username)
+	}
+
+	seller, err := tx.Users().FindByUsername("demo_seller")
+	if err != nil {
+		return err
+	}
+
+	for _, item := range demoProducts {
+		if _, err := tx.Products().FindByName(item.ProductName); err == nil {
+			continue
+		}
+		product := item
+		product.MachineID = machine.ID
+		product.SellerID = seller.ID
+		if err := tx.Products().Create(&product); err != nil {
+			return err
+		}
+		fmt.Println("created product " + product.ProductName)
+	}
+
+	//only a machine without coins gets the float, coins it holds are real money
+	accepted, err := tx.Coins().Denominations(machine.ID)
+	if err != nil {
+		return err
+	}
+	held, err := tx.Coins().Lock(machine.ID, accepted)
+	if err != nil {
+		return err
+	}
+	for _, coin := range held {
+		if coin.Count > 0 {
+			return nil
+		}
+	}
+
+	float := map[int]int{}
+	for _, value := range accepted {
+		float[value] = demoFloat
+	}
+	if _, err := loadCoins(tx, machine.ID, float); err != nil {
+		return err
+	}
+	fmt.Printf("loaded %d of each coin into machine %d\n", demoFloat, machine.ID)
+
+	return nil
+}
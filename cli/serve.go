@@ -0,0 +1,126 @@
+package cli
+
+import (
+	"context"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"log"
+	"mvpmatch/config"
+	"mvpmatch/database"
+	"mvpmatch/repository"
+	"mvpmatch/routes"
+	"mvpmatch/tokens"
+	"mvpmatch/wallet"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func serve(args []string) error {
+	if err := flags("serve").Parse(args); err != nil {
+		return err
+	}
+
+	//get root directory
+	path, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	resourcesPath := path + "/" + "resources"
+
+	//tokens are signed and verified with the configured key files
+	if err := tokens.LoadKeys(); err != nil {
+		return err
+	}
+
+	//the schema only changes through the migrate command
+	db, err := open()
+	if err != nil {
+		return err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	//the roles and the default machine the routes rely on come from the seed command
+	store := repository.NewGorm(db)
+	if _, err := store.Machines().Default(); err != nil {
+		log.Println("database has no machine yet, run: seed")
+	}
+
+	//flag any deposit that has drifted from the wallet ledger
+	mismatches, err := wallet.Reconcile(store)
+	if err != nil {
+		log.Println(err)
+	}
+	for _, item := range mismatches {
+		log.Printf("wallet: user %d has deposit %d but ledger sums to %d\n", item.UserID, item.Deposit, item.Ledger)
+	}
+
+	//one pooled client holds the revoked tokens, closed after the requests drain
+	client := database.NewRedis()
+	defer client.Close()
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		log.Printf("redis: %v, tokens fail %s until it is back\n", err, config.Redis.Policy)
+	}
+
+	app := fiber.New(fiber.Config{
+		IdleTimeout: config.App.IdleTimeout,
+	})
+
+	routes.Routes(app, store, tokens.NewRedis(client))
+
+	app.Static("/", resourcesPath)
+
+	ln, err := net.Listen("tcp", ":"+config.App.Port)
+	if err != nil {
+		return err
+	}
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(quit)
+
+	//the deferred closes of redis and the pool run once the requests have drained
+	return ServeUntil(app, ln, quit, config.App.ShutdownTimeout)
+}
+
+// ServeUntil serves the app on the listener until a signal arrives on quit, then stops accepting
+// connections and waits up to timeout for the requests in flight to finish. A second signal
+// stops the wait early. It returns an error when the server failed or requests were cut short.
+func ServeUntil(app *fiber.App, ln net.Listener, quit <-chan os.Signal, timeout time.Duration) error {
+
+	failed := make(chan error, 1)
+	go func() {
+		failed <- app.Listener(ln)
+	}()
+
+	select {
+	case err := <-failed:
+		return err
+	case sig := <-quit:
+		log.Printf("%s received, waiting up to %s for requests to finish\n", sig, timeout)
+	}
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- app.Shutdown()
+	}()
+
+	select {
+	case err := <-drained:
+		if err != nil {
+			return err
+		}
+		log.Println("requests drained, shutting down")
+		return nil
+	case <-time.After(timeout):
+		return errors.New("shutdown timed out with requests still running")
+	case sig := <-quit:
+		return errors.New(sig.String() + " received again, shutting down with requests still running")
+	}
+}
@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+	"mvpmatch/models"
+	"mvpmatch/repository"
+)
+
+// createUser adds a user with any role, admins included, which the sign up route refuses.
+func createUser(args []string) error {
+	set := flags("create-user")
+	username := set.String("username", "", "name the user logs in with")
+	password := set.String("password", "", "password the user logs in with")
+	role := set.String("role", "", "name of the user's role")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	if *username == "" || *password == "" || *role == "" || set.NArg() > 0 {
+		return errUsage
+	}
+
+	db, err := open()
+	if err != nil {
+		return err
+	}
+
+	var user models.User
+	err = repository.NewGorm(db).Transaction(func(tx repository.Store) error {
+		user, err = addUser(tx, *username, *password, *role)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("created %s %d %s\n", *role, user.ID, user.Username)
+	return nil
+}
+
+func addUser(tx repository.Store, username string, password string, roleName string) (models.User, error) {
+	role, err := tx.Roles().FindByName(roleName)
+	if err != nil {
+		return models.User{}, errors.New("role " + roleName + " does not exist")
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return models.User{}, errors.Wrap(err, "unable to encrypt password")
+	}
+
+	user := models.User{
+		Username: username,
+		Password: string(passwordHash),
+		RoleID:   role.ID,
+	}
+	if err := tx.Users().Create(&user); err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			return user, errors.New("username " + username + " is taken")
+		}
+		return user, err
+	}
+
+	return user, nil
+}
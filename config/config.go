@@ -2,14 +2,216 @@ package config
 
 import (
 	"github.com/caarlos0/env/v6"
+	"github.com/pkg/errors"
+	"os"
+	"strconv"
+	"time"
 )
 
-var Role struct {
+// Config is every setting the service reads from the environment,
+// Load fills the package level App, Role, Token, Database and Redis from it.
+type Config struct {
+	App      AppConfig
+	Role     RoleConfig
+	Token    TokenConfig
+	Database DatabaseConfig
+	Redis    RedisConfig
+}
+
+type AppConfig struct {
+	Name string `env:"Name" envDefault:"Mvp"`
+	Mode string `env:"Mode" envDefault:"live"`
+	Port string `env:"Port" envDefault:"3000"`
+	ENV  string `env:"ENV" envDefault:"local"`
+	Url  string `env:"Url" envDefault:"http://127.0.0.1:3000/"`
+
+	//IdleTimeout closes idle keep-alive connections, ShutdownTimeout caps how long
+	//a stopping server waits for the requests still running
+	IdleTimeout     time.Duration `env:"IdleTimeout" envDefault:"5s"`
+	ShutdownTimeout time.Duration `env:"ShutdownTimeout" envDefault:"30s"`
+}
+
+type RoleConfig struct {
 	Seller string `env:"Seller" envDefault:"seller"`
 	Buyer  string `env:"Buyer" envDefault:"buyer"`
+	Admin  string `env:"Admin" envDefault:"admin"`
+}
+
+// TokenConfig names the PEM private key, RSA or Ed25519, tokens are signed with and the
+// keys it replaced that tokens are still verified with, and sets how long access tokens
+// stay valid. A role left at 0 gets Lifetime, each environment shortens or lengthens
+// them from its own file.
+type TokenConfig struct {
+	KeyFile         string        `env:"JWTKeyFile"`
+	VerifyKeyFiles  []string      `env:"JWTVerifyKeyFiles" envSeparator:","`
+	Lifetime        time.Duration `env:"TokenLifetime" envDefault:"1h"`
+	SellerLifetime  time.Duration `env:"SellerTokenLifetime" envDefault:"0"`
+	BuyerLifetime   time.Duration `env:"BuyerTokenLifetime" envDefault:"0"`
+	AdminLifetime   time.Duration `env:"AdminTokenLifetime" envDefault:"0"`
+	RefreshLifetime time.Duration `env:"RefreshTokenLifetime" envDefault:"720h"`
+}
+
+// DatabaseConfig picks the driver, mysql or sqlite, the connection settings are only read by mysql
+// and Path is the database file used by sqlite.
+type DatabaseConfig struct {
+	Driver   string `env:"DBDriver" envDefault:"mysql"`
+	Host     string `env:"DBHost" envDefault:"127.0.0.1"`
+	Port     string `env:"DBPort" envDefault:"3306"`
+	Name     string `env:"DBName" envDefault:"mvpmatch"`
+	Username string `env:"DBUsername" envDefault:"root"`
+	Password string `env:"DBPassword"`
+	Path     string `env:"DBPath" envDefault:"mvpmatch.db"`
 }
 
+// RedisConfig sets up the one client the service shares. Policy decides what a token check
+// does while Redis is unreachable, open lets the request through and closed rejects it.
+type RedisConfig struct {
+	Addr         string        `env:"RedisAddr" envDefault:"localhost:6379"`
+	Password     string        `env:"RedisPassword"`
+	DB           int           `env:"RedisDB" envDefault:"0"`
+	PoolSize     int           `env:"RedisPoolSize" envDefault:"10"`
+	DialTimeout  time.Duration `env:"RedisDialTimeout" envDefault:"2s"`
+	ReadTimeout  time.Duration `env:"RedisReadTimeout" envDefault:"1s"`
+	WriteTimeout time.Duration `env:"RedisWriteTimeout" envDefault:"1s"`
+	Policy       string        `env:"RedisPolicy" envDefault:"closed"`
+}
+
+var (
+	App      AppConfig
+	Role     RoleConfig
+	Token    TokenConfig
+	Database DatabaseConfig
+	Redis    RedisConfig
+)
+
 func init() {
-	_ = env.Parse(&App)
-	_ = env.Parse(&Role)
+	//defaults for code that runs without Load, the service itself always loads
+	var defaults Config
+	_ = env.Parse(&defaults)
+	set(defaults)
+}
+
+// Load reads and validates the configuration, then makes it the one the service runs with.
+func Load(file string) error {
+	config, err := Read(file)
+	if err != nil {
+		return err
+	}
+	set(config)
+	return nil
+}
+
+// Read parses the environment on top of the optional .env or YAML file,
+// the environment wins when both set a value.
+func Read(file string) (Config, error) {
+	var config Config
+
+	environment := map[string]string{}
+	if file != "" {
+		values, err := readFile(file)
+		if err != nil {
+			return config, errors.Wrap(err, "config: unable to read "+file)
+		}
+		for key, value := range values {
+			environment[key] = value
+		}
+	}
+	for key, value := range environ() {
+		environment[key] = value
+	}
+
+	if err := env.Parse(&config, env.Options{Environment: environment}); err != nil {
+		return config, errors.Wrap(err, "config")
+	}
+
+	if err := config.Validate(); err != nil {
+		return config, errors.Wrap(err, "config")
+	}
+
+	return config, nil
+}
+
+// File is the configuration file the service starts with, the one named by ConfigFile
+// or .env when it exists in the working directory.
+func File() string {
+	if file := os.Getenv("ConfigFile"); file != "" {
+		return file
+	}
+	if _, err := os.Stat(".env"); err == nil {
+		return ".env"
+	}
+	return ""
+}
+
+func (c Config) Validate() error {
+
+	if c.Token.KeyFile == "" {
+		return errors.New("JWTKeyFile is required")
+	}
+
+	if port, err := strconv.Atoi(c.App.Port); err != nil || port < 1 || port > 65535 {
+		return errors.New("Port must be a number between 1 and 65535")
+	}
+
+	if c.App.IdleTimeout <= 0 || c.App.ShutdownTimeout <= 0 {
+		return errors.New("IdleTimeout and ShutdownTimeout must be positive durations such as 30s")
+	}
+
+	if c.Role.Seller == "" || c.Role.Buyer == "" || c.Role.Admin == "" {
+		return errors.New("Seller, Buyer and Admin role names cannot be empty")
+	}
+
+	if c.Token.Lifetime <= 0 || c.Token.RefreshLifetime <= 0 {
+		return errors.New("TokenLifetime and RefreshTokenLifetime must be positive durations such as 1h")
+	}
+	if c.Token.SellerLifetime < 0 || c.Token.BuyerLifetime < 0 || c.Token.AdminLifetime < 0 {
+		return errors.New("SellerTokenLifetime, BuyerTokenLifetime and AdminTokenLifetime cannot be negative")
+	}
+	for _, lifetime := range []time.Duration{c.Token.Lifetime, c.Token.SellerLifetime, c.Token.BuyerLifetime, c.Token.AdminLifetime} {
+		if lifetime > c.Token.RefreshLifetime {
+			return errors.New("token lifetimes cannot outlast RefreshTokenLifetime")
+		}
+	}
+
+	switch c.Database.Driver {
+	case "mysql":
+		if c.Database.Host == "" || c.Database.Port == "" || c.Database.Name == "" || c.Database.Username == "" {
+			return errors.New("DBHost, DBPort, DBName and DBUsername are required by the mysql driver")
+		}
+		if c.Database.Password == "" {
+			return errors.New("DBPassword is required by the mysql driver")
+		}
+	case "sqlite":
+		if c.Database.Path == "" {
+			return errors.New("DBPath is required by the sqlite driver")
+		}
+	default:
+		return errors.New("DBDriver must be mysql or sqlite")
+	}
+
+	if c.Redis.Addr == "" {
+		return errors.New("RedisAddr is required")
+	}
+	if c.Redis.DB < 0 {
+		return errors.New("RedisDB cannot be negative")
+	}
+	if c.Redis.PoolSize < 1 {
+		return errors.New("RedisPoolSize must be at least 1")
+	}
+	if c.Redis.DialTimeout <= 0 || c.Redis.ReadTimeout <= 0 || c.Redis.WriteTimeout <= 0 {
+		return errors.New("RedisDialTimeout, RedisReadTimeout and RedisWriteTimeout must be positive durations")
+	}
+	if c.Redis.Policy != "open" && c.Redis.Policy != "closed" {
+		return errors.New("RedisPolicy must be open or closed")
+	}
+
+	return nil
+}
+
+func set(config Config) {
+	App = config.App
+	Role = config.Role
+	Token = config.Token
+	Database = config.Database
+	Redis = config.Redis
 }
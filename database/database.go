@@ -1,61 +1,84 @@
 package database
 
 import (
-	"context"
 	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"mvpmatch/config"
 )
 
+// Drivers the database can be opened with.
 const (
-	Port     = "3306"
-	Host     = "127.0.0.1"
-	Database = "mvpmatch"
-	Username = "root"
-	Password = "koreroot"
+	MySQL  = "mysql"
+	SQLite = "sqlite"
 )
 
-var DB *gorm.DB
-
-func Start() {
-	dbName := Database
-	dbUser := Username
-	dbPass := Password
-	dbHost := Host
-	dbPort := Port
-	dsn := dbUser + ":" + dbPass + "@tcp(" + dbHost + ":" + dbPort + ")/" + dbName
-	dsn = dsn + "?charset=utf8&parseTime=True&loc=Local"
-
-	var err error
-
-	DB, err = gorm.Open(mysql.New(mysql.Config{
-		DSN:                       dsn,   // data source name,
-		DefaultStringSize:         256,   // add default size for string fields,
-		DisableDatetimePrecision:  true,  // disable datetime precision support,
-		DontSupportRenameIndex:    true,  // drop & create index when rename index,
-		DontSupportRenameColumn:   true,  // use change when rename column,
-		SkipInitializeWithVersion: false, // smart configure based on used version
-	}), &gorm.Config{
-		DisableForeignKeyConstraintWhenMigrating: true,
-	})
+// Connect opens the database configured in config.Database.
+func Connect() (*gorm.DB, error) {
+	settings := config.Database
 
-	if err != nil {
-		panic(err)
+	dsn := settings.Path
+	if settings.Driver == MySQL {
+		dsn = settings.Username + ":" + settings.Password + "@tcp(" + settings.Host + ":" + settings.Port + ")/" + settings.Name
+		dsn = dsn + "?charset=utf8&parseTime=True&loc=Local"
 	}
+
+	return Open(settings.Driver, dsn)
 }
 
-func ConnectRedis() (*redis.Client, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379", // host:port of the redis server
-		Password: "",               // no password set
-		DB:       0,                // use default DB
-	})
+// Open connects to a database with the given driver, for sqlite the dsn is the database file.
+func Open(driver string, dsn string) (*gorm.DB, error) {
+
+	var dialector gorm.Dialector
+	switch driver {
+	case MySQL:
+		dialector = mysql.New(mysql.Config{
+			DSN:                       dsn,   // data source name,
+			DefaultStringSize:         256,   // add default size for string fields,
+			DisableDatetimePrecision:  true,  // disable datetime precision support,
+			DontSupportRenameIndex:    true,  // drop & create index when rename index,
+			DontSupportRenameColumn:   true,  // use change when rename column,
+			SkipInitializeWithVersion: false, // smart configure based on used version
+		})
+	case SQLite:
+		dialector = sqlite.Open(dsn + "?_busy_timeout=5000&_foreign_keys=off")
+	default:
+		return nil, errors.New("unsupported database driver " + driver)
+	}
 
-	ctx := context.TODO()
-	_, err := client.Ping(ctx).Result()
+	db, err := gorm.Open(dialector, &gorm.Config{
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
 	if err != nil {
-		return client, err
+		return nil, err
+	}
+
+	//sqlite has no row locks and a single writer, so one connection
+	//makes transactions queue up instead of failing with database is locked
+	if driver == SQLite {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(1)
 	}
 
-	return client, nil
+	return db, nil
+}
+
+// NewRedis creates the Redis client the service shares, its pool connects on first use.
+func NewRedis() *redis.Client {
+	settings := config.Redis
+
+	return redis.NewClient(&redis.Options{
+		Addr:         settings.Addr,     // host:port of the redis server
+		Password:     settings.Password, // empty when no password is set
+		DB:           settings.DB,
+		PoolSize:     settings.PoolSize,
+		DialTimeout:  settings.DialTimeout,
+		ReadTimeout:  settings.ReadTimeout,
+		WriteTimeout: settings.WriteTimeout,
+	})
 }
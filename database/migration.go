@@ -1,25 +1,508 @@
 package database
 
 import (
-	"log"
-	"mvpmatch/models"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"mvpmatch/config"
+	"mvpmatch/inventory"
+	"mvpmatch/wallet"
+	"strconv"
+	"time"
 )
 
-func Migrate() {
-	// Migrate the schema
-	db := DB
-	err := db.AutoMigrate(
-		&models.Order{},
-		&models.Product{},
-		&models.User{},
-		&models.Role{},
-		&models.Wallet{},
-		&models.Coin{},
-	)
+// migration is one versioned change to the schema, Down undoes what Up did.
+type migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// migrations run in order of version, a released migration is never edited,
+// changes to the schema go into a new one at the end.
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "create_tables",
+		Up: func(tx *gorm.DB) error {
+			//matches the schema AutoMigrate kept up to date before migrations,
+			//so databases created back then take it as their baseline
+			return tx.AutoMigrate(v1Tables()...)
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(v1Tables()...)
+		},
+	},
+	{
+		Version: 2,
+		Name:    "unique_usernames_and_product_names",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Exec("CREATE UNIQUE INDEX idx_users_username ON users (username)").Error; err != nil {
+				return errors.Wrap(err, "usernames must be unique before migrating")
+			}
+			if err := tx.Exec("CREATE UNIQUE INDEX idx_products_product_name ON products (product_name)").Error; err != nil {
+				return errors.Wrap(err, "product names must be unique before migrating")
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropIndex(&v1Product{}, "idx_products_product_name"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropIndex(&v1User{}, "idx_users_username")
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create_sessions",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v3Session{}, &v3RefreshToken{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&v3RefreshToken{}, &v3Session{})
+		},
+	},
+	{
+		Version: 4,
+		Name:    "suspend_users_and_audit_logs",
+		Up: func(tx *gorm.DB) error {
+			if err := addColumns(tx, &v4User{}, "SuspendedAt", "SuspendReason"); err != nil {
+				return err
+			}
+			return tx.AutoMigrate(&v4AuditLog{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable(&v4AuditLog{}); err != nil {
+				return err
+			}
+			return dropColumns(tx, "users", "suspended_at", "suspend_reason")
+		},
+	},
+	{
+		Version: 5,
+		Name:    "permissions_and_self_assignable_roles",
+		Up: func(tx *gorm.DB) error {
+			if err := addColumns(tx, &v5Role{}, "SelfAssignable"); err != nil {
+				return err
+			}
+			//buyers keep signing themselves up, sellers are vetted from now on
+			err := tx.Model(&v1Role{}).
+				Where("name = ?", config.Role.Buyer).
+				Update("self_assignable", true).Error
+			if err != nil {
+				return err
+			}
+			return tx.AutoMigrate(&v5Permission{}, &v5RolePermission{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable(&v5RolePermission{}, &v5Permission{}); err != nil {
+				return err
+			}
+			return dropColumns(tx, "roles", "self_assignable")
+		},
+	},
+	{
+		Version: 6,
+		Name:    "opening_wallet_balances",
+		Up: func(tx *gorm.DB) error {
+			//deposits moved without a ledger entry before the ledger existed, one opening
+			//entry per user makes the ledger add up to the deposit they hold now
+			var balances []struct {
+				UserID  uint
+				Deposit int
+				Ledger  int
+			}
+			err := tx.Table("users").
+				Select("users.id AS user_id, users.deposit AS deposit, COALESCE(SUM(wallets.credit - wallets.debit), 0) AS ledger").
+				Joins("LEFT JOIN wallets ON wallets.user_id = users.id").
+				Group("users.id, users.deposit").
+				Having("users.deposit <> COALESCE(SUM(wallets.credit - wallets.debit), 0)").
+				Scan(&balances).Error
+			if err != nil {
+				return err
+			}
+
+			for _, item := range balances {
+				entry := v1Wallet{UserID: item.UserID, Balance: item.Deposit, Source: wallet.Opening}
+				if item.Deposit > item.Ledger {
+					entry.Credit = item.Deposit - item.Ledger
+				} else {
+					entry.Debit = item.Ledger - item.Deposit
+				}
+				if err := tx.Create(&entry).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Where("source = ?", wallet.Opening).Delete(&v1Wallet{}).Error
+		},
+	},
+	{
+		Version: 7,
+		Name:    "unique_coins",
+		Up: func(tx *gorm.DB) error {
+			//two first deposits of a coin could each insert its row, the rows of
+			//a repeated coin are folded into the oldest one before the index forbids them
+			var repeated []struct {
+				MachineID    uint
+				Denomination int
+				KeepID       uint
+				Total        int
+			}
+			err := tx.Model(&v1Coin{}).
+				Select("machine_id, denomination, MIN(id) AS keep_id, SUM(count) AS total").
+				Group("machine_id, denomination").
+				Having("COUNT(*) > 1").
+				Scan(&repeated).Error
+			if err != nil {
+				return err
+			}
+
+			for _, item := range repeated {
+				if err := tx.Model(&v1Coin{}).Where("id = ?", item.KeepID).Update("count", item.Total).Error; err != nil {
+					return err
+				}
+				err := tx.Where("machine_id = ? AND denomination = ? AND id <> ?", item.MachineID, item.Denomination, item.KeepID).
+					Delete(&v1Coin{}).Error
+				if err != nil {
+					return err
+				}
+			}
+
+			return tx.Exec("CREATE UNIQUE INDEX idx_coins_machine_denomination ON coins (machine_id, denomination)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropIndex(&v1Coin{}, "idx_coins_machine_denomination")
+		},
+	},
+	{
+		Version: 8,
+		Name:    "machine_indexes",
+		Up: func(tx *gorm.DB) error {
+			//a repeated denomination says nothing the first one does not
+			var repeated []struct {
+				MachineID uint
+				Value     int
+				KeepID    uint
+			}
+			err := tx.Model(&v1Denomination{}).
+				Select("machine_id, value, MIN(id) AS keep_id").
+				Group("machine_id, value").
+				Having("COUNT(*) > 1").
+				Scan(&repeated).Error
+			if err != nil {
+				return err
+			}
+			for _, item := range repeated {
+				err := tx.Where("machine_id = ? AND value = ? AND id <> ?", item.MachineID, item.Value, item.KeepID).
+					Delete(&v1Denomination{}).Error
+				if err != nil {
+					return err
+				}
+			}
 
+			if err := tx.Exec("CREATE UNIQUE INDEX idx_denominations_machine_value ON denominations (machine_id, value)").Error; err != nil {
+				return err
+			}
+			if err := tx.Exec("CREATE UNIQUE INDEX idx_slots_machine_code ON slots (machine_id, code)").Error; err != nil {
+				return errors.Wrap(err, "slot codes must be unique in each machine before migrating")
+			}
+			//locking the coins of one machine reads only its rows
+			return tx.Exec("CREATE INDEX idx_coins_machine_id ON coins (machine_id)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropIndex(&v1Coin{}, "idx_coins_machine_id"); err != nil {
+				return err
+			}
+			if err := tx.Migrator().DropIndex(&v1Slot{}, "idx_slots_machine_code"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropIndex(&v1Denomination{}, "idx_denominations_machine_value")
+		},
+	},
+	{
+		Version: 9,
+		Name:    "default_machine",
+		Up: func(tx *gorm.DB) error {
+			//coins, products, orders and deposits recorded before machines existed belong to
+			//the first machine, which is created when there is none yet
+			var count int64
+			for _, model := range v9MachineTables() {
+				var rows int64
+				if err := tx.Model(model).Where("machine_id = 0").Count(&rows).Error; err != nil {
+					return err
+				}
+				count += rows
+			}
+			var deposits int64
+			err := tx.Model(&v1User{}).Where("deposit > 0 AND deposit_machine_id = 0").Count(&deposits).Error
+			if err != nil {
+				return err
+			}
+			if count+deposits == 0 {
+				return nil
+			}
+
+			var machine v1Machine
+			rows := tx.Order("id asc").Limit(1).Find(&machine)
+			if rows.Error != nil {
+				return rows.Error
+			}
+			if rows.RowsAffected == 0 {
+				machine = v1Machine{Name: "default"}
+				if err := tx.Create(&machine).Error; err != nil {
+					return err
+				}
+			}
+
+			for _, model := range v9MachineTables() {
+				if err := tx.Model(model).Where("machine_id = 0").Update("machine_id", machine.ID).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Model(&v1User{}).
+				Where("deposit > 0 AND deposit_machine_id = 0").
+				Update("deposit_machine_id", machine.ID).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			//the rows stay with the machine, none of them was valid without one
+			return nil
+		},
+	},
+	{
+		Version: 10,
+		Name:    "opening_coin_ledger",
+		Up: func(tx *gorm.DB) error {
+			//coins moved without a ledger entry before the ledger existed, one opening
+			//entry per coin makes the ledger add up to the count the machine holds now
+			var counts []struct {
+				MachineID    uint
+				Denomination int
+				Count        int
+				Ledger       int
+			}
+			err := tx.Table("coins").
+				Select("coins.machine_id AS machine_id, coins.denomination AS denomination, coins.count AS count, " +
+					"COALESCE(SUM(coin_movements.delta), 0) AS ledger").
+				Joins("LEFT JOIN coin_movements ON coin_movements.machine_id = coins.machine_id " +
+					"AND coin_movements.denomination = coins.denomination").
+				Group("coins.machine_id, coins.denomination, coins.count").
+				Having("coins.count <> COALESCE(SUM(coin_movements.delta), 0)").
+				Scan(&counts).Error
+			if err != nil {
+				return err
+			}
+
+			for _, item := range counts {
+				entry := v1CoinMovement{
+					MachineID:    item.MachineID,
+					Denomination: item.Denomination,
+					Delta:        item.Count - item.Ledger,
+					Kind:         inventory.Opening,
+				}
+				if err := tx.Create(&entry).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Where("kind = ?", inventory.Opening).Delete(&v1CoinMovement{}).Error
+		},
+	},
+}
+
+// v9MachineTables are the ones whose rows belong to a machine.
+func v9MachineTables() []interface{} {
+	return []interface{}{
+		&v1Coin{},
+		&v1CoinMovement{},
+		&v1Denomination{},
+		&v1Product{},
+		&v1Order{},
+	}
+}
+
+// v1Tables are the ones the first migration creates, later tables belong to their own migration.
+func v1Tables() []interface{} {
+	return []interface{}{
+		&v1Order{},
+		&v1OrderChange{},
+		&v1Product{},
+		&v1User{},
+		&v1Role{},
+		&v1Wallet{},
+		&v1Coin{},
+		&v1CoinMovement{},
+		&v1Denomination{},
+		&v1Machine{},
+		&v1Slot{},
+	}
+}
+
+// addColumns adds the named fields of a snapshot type to its table.
+func addColumns(tx *gorm.DB, snapshot interface{}, fields ...string) error {
+	for _, field := range fields {
+		if err := tx.Migrator().AddColumn(snapshot, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropColumns drops columns in place. The sqlite migrator of gorm rebuilds the table
+// to drop one and loses its indexes on the way, sqlite 3.35 and later drops them itself.
+func dropColumns(tx *gorm.DB, table string, columns ...string) error {
+	for _, column := range columns {
+		err := tx.Exec("ALTER TABLE ? DROP COLUMN ?", clause.Table{Name: table}, clause.Column{Name: column}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SchemaMigration records a migration applied to the database.
+type SchemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// MigrationStatus is a known migration and whether the database has it.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt *time.Time
+}
+
+// MigrateUp applies every pending migration in order and returns the ones it applied.
+// It stops at the first one that fails, the ones before it stay applied.
+func MigrateUp(db *gorm.DB) ([]MigrationStatus, error) {
+	applied, err := appliedMigrations(db)
 	if err != nil {
-		log.Println(err)
+		return nil, err
+	}
+
+	var done []MigrationStatus
+	for _, item := range migrations {
+		if _, ok := applied[item.Version]; ok {
+			continue
+		}
+
+		record := SchemaMigration{Version: item.Version, Name: item.Name, AppliedAt: time.Now()}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := item.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&record).Error
+		})
+		if err != nil {
+			return done, errors.Wrap(err, "migration "+label(item)+" failed")
+		}
+
+		done = append(done, MigrationStatus{Version: item.Version, Name: item.Name, Applied: true, AppliedAt: &record.AppliedAt})
 	}
 
-	seed(db)
+	return done, nil
+}
+
+// MigrateDown rolls back the given number of the latest applied migrations, newest first,
+// and returns the ones it rolled back.
+func MigrateDown(db *gorm.DB, steps int) ([]MigrationStatus, error) {
+	if steps < 1 {
+		return nil, errors.New("steps must be at least 1")
+	}
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []MigrationStatus
+	for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		item := migrations[i]
+		if _, ok := applied[item.Version]; !ok {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := item.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, item.Version).Error
+		})
+		if err != nil {
+			return done, errors.Wrap(err, "rolling back migration "+label(item)+" failed")
+		}
+
+		done = append(done, MigrationStatus{Version: item.Version, Name: item.Name})
+	}
+
+	return done, nil
+}
+
+// Migrations lists every known migration in order with whether it has been applied.
+func Migrations(db *gorm.DB) ([]MigrationStatus, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []MigrationStatus
+	for _, item := range migrations {
+		status := MigrationStatus{Version: item.Version, Name: item.Name}
+		if record, ok := applied[item.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = &record.AppliedAt
+		}
+		list = append(list, status)
+	}
+
+	return list, nil
+}
+
+// Pending counts the migrations the database is still missing.
+func Pending(db *gorm.DB) (int, error) {
+	list, err := Migrations(db)
+	if err != nil {
+		return 0, err
+	}
+
+	pending := 0
+	for _, item := range list {
+		if !item.Applied {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+func appliedMigrations(db *gorm.DB) (map[int]SchemaMigration, error) {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, errors.Wrap(err, "unable to create schema_migrations")
+	}
+
+	var records []SchemaMigration
+	if err := db.Find(&records).Error; err != nil {
+		return nil, errors.Wrap(err, "unable to read schema_migrations")
+	}
+
+	applied := map[int]SchemaMigration{}
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+func label(item migration) string {
+	return strconv.Itoa(item.Version) + " " + item.Name
 }
@@ -0,0 +1,210 @@
+package database
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// The types below freeze the tables and columns as each migration left them, so a change
+// to the models never reaches a released migration. They only carry columns, foreign keys
+// are not created when migrating. A change to the schema adds new types for its own migration.
+
+// Version 1, the schema AutoMigrate kept up to date before migrations.
+
+type v1Order struct {
+	ID            uint `gorm:"primary_key"`
+	MachineID     uint
+	ProductID     uint
+	UserID        uint
+	Amount        int
+	UnitPrice     int
+	TotalCost     int
+	DepositBefore int
+	DepositAfter  int
+	RefundedUnits int
+	RefundAmount  int
+	RefundReason  string
+	RefundedAt    *time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+type v1OrderChange struct {
+	ID           uint `gorm:"primary_key"`
+	OrderID      uint
+	Denomination int
+	Count        int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+type v1Product struct {
+	ID              uint `gorm:"primary_key"`
+	MachineID       uint
+	AmountAvailable int
+	Cost            int
+	ProductName     string
+	SellerID        uint
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+type v1User struct {
+	ID               uint `gorm:"primary_key"`
+	Username         string
+	Password         string
+	Deposit          int
+	DepositMachineID uint
+	RoleID           uint
+	Deleted          gorm.DeletedAt
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+type v1Role struct {
+	ID        uint `gorm:"primary_key"`
+	Name      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type v1Wallet struct {
+	ID          uint `gorm:"primary_key"`
+	UserID      uint
+	Debit       int
+	Credit      int
+	Balance     int
+	Source      string
+	ReferenceID uint
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+type v1Coin struct {
+	ID           uint `gorm:"primary_key"`
+	MachineID    uint
+	Denomination int
+	Count        int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+type v1CoinMovement struct {
+	ID           uint `gorm:"primary_key"`
+	MachineID    uint
+	Denomination int
+	Delta        int
+	Kind         string
+	UserID       uint
+	OrderID      uint
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+type v1Denomination struct {
+	ID        uint `gorm:"primary_key"`
+	MachineID uint
+	Value     int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type v1Machine struct {
+	ID        uint `gorm:"primary_key"`
+	Name      string
+	Location  string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type v1Slot struct {
+	ID        uint `gorm:"primary_key"`
+	MachineID uint
+	Code      string
+	Capacity  int
+	Fill      int
+	ProductID uint
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (v1Order) TableName() string        { return "orders" }
+func (v1OrderChange) TableName() string  { return "order_changes" }
+func (v1Product) TableName() string      { return "products" }
+func (v1User) TableName() string         { return "users" }
+func (v1Role) TableName() string         { return "roles" }
+func (v1Wallet) TableName() string       { return "wallets" }
+func (v1Coin) TableName() string         { return "coins" }
+func (v1CoinMovement) TableName() string { return "coin_movements" }
+func (v1Denomination) TableName() string { return "denominations" }
+func (v1Machine) TableName() string      { return "machines" }
+func (v1Slot) TableName() string         { return "slots" }
+
+// Version 3, sessions and their refresh tokens.
+
+type v3Session struct {
+	ID         uint `gorm:"primary_key"`
+	UserID     uint
+	Device     string
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+type v3RefreshToken struct {
+	ID        uint `gorm:"primary_key"`
+	SessionID uint
+	TokenHash string `gorm:"size:64;uniqueIndex"`
+	UsedAt    *time.Time
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (v3Session) TableName() string      { return "sessions" }
+func (v3RefreshToken) TableName() string { return "refresh_tokens" }
+
+// Version 4, suspended users and the audit log.
+
+type v4User struct {
+	SuspendedAt   *time.Time
+	SuspendReason string
+}
+
+type v4AuditLog struct {
+	ID         uint   `gorm:"primary_key"`
+	ActorID    uint   `gorm:"index"`
+	Action     string `gorm:"size:64;index"`
+	TargetType string `gorm:"size:32"`
+	TargetID   uint
+	Details    string `gorm:"type:text"`
+	CreatedAt  time.Time
+}
+
+func (v4User) TableName() string     { return "users" }
+func (v4AuditLog) TableName() string { return "audit_logs" }
+
+// Version 5, permissions and the roles users may pick at sign-up.
+
+type v5Role struct {
+	SelfAssignable bool
+}
+
+type v5Permission struct {
+	ID          uint   `gorm:"primary_key"`
+	Name        string `gorm:"size:64;uniqueIndex"`
+	Description string
+	CreatedAt   time.Time
+}
+
+type v5RolePermission struct {
+	RoleID       uint `gorm:"primaryKey;autoIncrement:false"`
+	PermissionID uint `gorm:"primaryKey;autoIncrement:false"`
+	CreatedAt    time.Time
+}
+
+func (v5Role) TableName() string           { return "roles" }
+func (v5Permission) TableName() string     { return "permissions" }
+func (v5RolePermission) TableName() string { return "role_permissions" }
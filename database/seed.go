@@ -2,20 +2,70 @@ package database
 
 import (
 	"gorm.io/gorm"
+	"log"
 	"mvpmatch/config"
+	"mvpmatch/inventory"
 	"mvpmatch/models"
+	"mvpmatch/permissions"
+	"mvpmatch/repository"
 )
 
-func seed(db *gorm.DB) {
+// Seed adds the roles and their permissions, the default machine and its coins
+// when they are missing, anything that exists is left alone so it can run again.
+// Changes to existing data go into a migration instead.
+func Seed(db *gorm.DB) {
 	roleSeeder(db)
+	permissionSeeder(db)
+	machineSeeder(db)
+	denominationSeeder(db)
 }
 
+// roleSeeder adds the roles, only buyers sign themselves up, sellers and admins are created from the command line.
 func roleSeeder(db *gorm.DB) {
 	name := config.Role.Buyer
 	status := models.Role{Name: name}
-	db.Where(status).FirstOrCreate(&status)
+	db.Where(status).Attrs(models.Role{SelfAssignable: true}).FirstOrCreate(&status)
 
 	name = config.Role.Seller
 	status = models.Role{Name: name}
 	db.Where(status).FirstOrCreate(&status)
+
+	name = config.Role.Admin
+	status = models.Role{Name: name}
+	db.Where(status).FirstOrCreate(&status)
+}
+
+// permissionSeeder adds new permissions and grants them to the roles that start with them,
+// the grants of existing permissions are left to admins.
+func permissionSeeder(db *gorm.DB) {
+	if err := permissions.Seed(repository.NewGorm(db)); err != nil {
+		log.Println("seed: unable to add permissions:", err)
+	}
+}
+
+// machineSeeder creates the default machine when there is none.
+func machineSeeder(db *gorm.DB) {
+	var machine models.Machine
+	rows := db.Order("id asc").Limit(1).Find(&machine)
+	if rows.Error == nil && rows.RowsAffected == 0 {
+		db.Create(&models.Machine{Name: "default"})
+	}
+}
+
+// denominationSeeder accepts the original coin set on a machine without any.
+func denominationSeeder(db *gorm.DB) {
+	var machines []models.Machine
+	db.Find(&machines)
+
+	for _, machine := range machines {
+		var count int64
+		db.Model(&models.Denomination{}).Where("machine_id = ?", machine.ID).Count(&count)
+		if count > 0 {
+			continue
+		}
+
+		for _, value := range inventory.DefaultDenominations {
+			db.Create(&models.Denomination{MachineID: machine.ID, Value: value})
+		}
+	}
 }
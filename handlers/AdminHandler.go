@@ -0,0 +1,704 @@
+package handlers
+
+import (
+	"encoding/json"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"mvpmatch/audit"
+	"mvpmatch/change"
+	"mvpmatch/inventory"
+	"mvpmatch/models"
+	"mvpmatch/repository"
+	"mvpmatch/wallet"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type adminUser struct {
+	ID               uint       `json:"id"`
+	Username         string     `json:"username"`
+	Role             string     `json:"role"`
+	Deposit          int        `json:"deposit"`
+	DepositMachineID uint       `json:"deposit_machine_id"`
+	Suspended        bool       `json:"suspended"`
+	SuspendedAt      *time.Time `json:"suspended_at"`
+	SuspendReason    string     `json:"suspend_reason"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+func toAdminUser(user models.User) adminUser {
+	return adminUser{
+		ID:               user.ID,
+		Username:         user.Username,
+		Role:             user.Role.Name,
+		Deposit:          user.Deposit,
+		DepositMachineID: user.DepositMachineID,
+		Suspended:        user.SuspendedAt != nil,
+		SuspendedAt:      user.SuspendedAt,
+		SuspendReason:    user.SuspendReason,
+		CreatedAt:        user.CreatedAt,
+	}
+}
+
+// getTargetID reads the id of the record an admin route acts on.
+func getTargetID(c *fiber.Ctx) (uint, error) {
+	id, err := c.ParamsInt("id")
+	if err != nil || id < 1 {
+		return 0, errors.New("id is invalid")
+	}
+	return uint(id), nil
+}
+
+// GetAdminUsers lists every user with their deposit and whether they are suspended,
+// ?suspended=true or false narrows the list.
+func (h *Handler) GetAdminUsers(c *fiber.Ctx) error {
+
+	suspended := c.Query("suspended")
+	if suspended != "" && suspended != "true" && suspended != "false" {
+		return check(c, "", "suspended must be true or false", false, 400)
+	}
+
+	users, err := h.store.Users().List()
+	if err != nil {
+		return check(c, "", "unable to get users", false, 500)
+	}
+
+	allResult := make([]adminUser, 0)
+	for _, item := range users {
+		if suspended != "" && (item.SuspendedAt != nil) != (suspended == "true") {
+			continue
+		}
+		allResult = append(allResult, toAdminUser(item))
+	}
+
+	return check(c, allResult, "users", true, 200)
+}
+
+type suspendInput struct {
+	Reason string `json:"reason"`
+}
+
+func (s suspendInput) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Reason, validation.Required, validation.Length(1, 255)),
+	)
+}
+
+// SuspendUser stops a user from logging in and ends every session and token they hold.
+func (h *Handler) SuspendUser(c *fiber.Ctx) error {
+
+	var input suspendInput
+
+	adminID, err := getUserID(c)
+	if err != nil {
+		return check(c, err, err.Error(), false, 401)
+	}
+
+	userID, err := getTargetID(c)
+	if err != nil {
+		return check(c, "", err.Error(), false, 400)
+	}
+
+	if err := c.BodyParser(&input); err != nil {
+		return check(c, err, err.Error(), false, 400)
+	}
+
+	if err := input.Validate(); err != nil {
+		return check(c, err, err.Error(), false, 400)
+	}
+
+	if userID == adminID {
+		return check(c, "", "you cannot suspend yourself", false, 400)
+	}
+
+	user, err := h.store.Users().Find(userID)
+	if err != nil {
+		return check(c, "", "user not found", false, 404)
+	}
+	if user.SuspendedAt != nil {
+		return check(c, "", "user is already suspended", false, 400)
+	}
+
+	//tokens already handed out have to stop working too, before anything is written
+	now := time.Now()
+	if err := h.revoked.RevokeBefore(c.UserContext(), userID, now); err != nil {
+		return check(c, "", "unable to suspend user, try again later", false, 503)
+	}
+
+	err = h.store.Transaction(func(tx repository.Store) error {
+		if _, err := tx.Users().Lock(userID); err != nil {
+			return requestFailed(404, "user not found")
+		}
+
+		if err := tx.Users().Suspend(userID, now, input.Reason); err != nil {
+			return err
+		}
+
+		if err := tx.Sessions().RevokeAll(userID, now); err != nil {
+			return err
+		}
+
+		return audit.Record(tx, models.AuditLog{
+			ActorID:    adminID,
+			Action:     audit.SuspendUser,
+			TargetType: audit.User,
+			TargetID:   userID,
+		}, fiber.Map{"username": user.Username, "reason": input.Reason})
+	})
+	if err != nil {
+		return checkError(c, err, "unable to suspend user")
+	}
+
+	user, _ = h.store.Users().Find(userID)
+	return check(c, toAdminUser(user), "user suspended", true, 200)
+}
+
+// RestoreUser lets a suspended user log in again.
+func (h *Handler) RestoreUser(c *fiber.Ctx) error {
+
+	adminID, err := getUserID(c)
+	if err != nil {
+		return check(c, err, err.Error(), false, 401)
+	}
+
+	userID, err := getTargetID(c)
+	if err != nil {
+		return check(c, "", err.Error(), false, 400)
+	}
+
+	err = h.store.Transaction(func(tx repository.Store) error {
+		user, err := tx.Users().Lock(userID)
+		if err != nil {
+			return requestFailed(404, "user not found")
+		}
+		if user.SuspendedAt == nil {
+			return requestFailed(400, "user is not suspended")
+		}
+
+		if err := tx.Users().Restore(userID); err != nil {
+			return err
+		}
+
+		return audit.Record(tx, models.AuditLog{
+			ActorID:    adminID,
+			Action:     audit.RestoreUser,
+			TargetType: audit.User,
+			TargetID:   userID,
+		}, fiber.Map{"username": user.Username, "suspended_at": user.SuspendedAt, "reason": user.SuspendReason})
+	})
+	if err != nil {
+		return checkError(c, err, "unable to restore user")
+	}
+
+	user, _ := h.store.Users().Find(userID)
+	return check(c, toAdminUser(user), "user restored", true, 200)
+}
+
+// AdminEditProduct edits the name, cost and stock of any seller's product.
+func (h *Handler) AdminEditProduct(c *fiber.Ctx) error {
+
+	var input editProductInput
+
+	adminID, err := getUserID(c)
+	if err != nil {
+		return check(c, err, err.Error(), false, 401)
+	}
+
+	productID, err := getTargetID(c)
+	if err != nil {
+		return check(c, "", err.Error(), false, 400)
+	}
+
+	if err := c.BodyParser(&input); err != nil {
+		return check(c, err, err.Error(), false, 400)
+	}
+	input.ProductID = productID
+
+	if _, err := h.store.Products().Find(productID); err != nil {
+		return check(c, "", "product not found", false, 404)
+	}
+
+	if err := input.Validate(h.store); err != nil {
+		return check(c, err, err.Error(), false, 400)
+	}
+
+	err = h.store.Transaction(func(tx repository.Store) error {
+		before, err := tx.Products().Lock(productID)
+		if err != nil {
+			return requestFailed(404, "product not found")
+		}
+
+		if err := checkProductEdit(tx, input, before); err != nil {
+			return requestFailed(400, err.Error())
+		}
+
+		err = tx.Products().Update(models.Product{
+			ID:              productID,
+			AmountAvailable: input.AmountAvailable,
+			Cost:            input.Cost,
+			ProductName:     input.ProductName,
+		})
+		if errors.Is(err, repository.ErrDuplicate) {
+			return requestFailed(400, "product_name exists!")
+		}
+		if err != nil {
+			return err
+		}
+
+		type fields struct {
+			ProductName     string `json:"product_name"`
+			Cost            int    `json:"cost"`
+			AmountAvailable int    `json:"amount_available"`
+		}
+		return audit.Record(tx, models.AuditLog{
+			ActorID:    adminID,
+			Action:     audit.EditProduct,
+			TargetType: audit.Product,
+			TargetID:   productID,
+		}, fiber.Map{
+			"seller_id": before.SellerID,
+			"before":    fields{before.ProductName, before.Cost, before.AmountAvailable},
+			"after":     fields{input.ProductName, input.Cost, input.AmountAvailable},
+		})
+	})
+	if err != nil {
+		return checkError(c, err, "unable to update product")
+	}
+
+	product, _ := h.store.Products().Find(productID)
+
+	output := fiber.Map{
+		"product_id":       product.ID,
+		"seller":           product.Seller.Username,
+		"amount_available": product.AmountAvailable,
+		"name":             product.ProductName,
+		"cost":             product.Cost,
+	}
+	return check(c, output, "product edited successfully", true, 200)
+}
+
+type adminDeleteInput struct {
+	Reason string `json:"reason"`
+}
+
+// AdminDeleteProduct removes any seller's product, the reason is optional.
+func (h *Handler) AdminDeleteProduct(c *fiber.Ctx) error {
+
+	var input adminDeleteInput
+
+	adminID, err := getUserID(c)
+	if err != nil {
+		return check(c, err, err.Error(), false, 401)
+	}
+
+	productID, err := getTargetID(c)
+	if err != nil {
+		return check(c, "", err.Error(), false, 400)
+	}
+
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&input); err != nil {
+			return check(c, err, err.Error(), false, 400)
+		}
+	}
+
+	err = h.store.Transaction(func(tx repository.Store) error {
+		product, err := tx.Products().Lock(productID)
+		if err != nil {
+			return requestFailed(404, "product not found")
+		}
+
+		if err := deleteProduct(tx, productID); err != nil {
+			return err
+		}
+
+		return audit.Record(tx, models.AuditLog{
+			ActorID:    adminID,
+			Action:     audit.DeleteProduct,
+			TargetType: audit.Product,
+			TargetID:   productID,
+		}, fiber.Map{
+			"product_name":     product.ProductName,
+			"seller_id":        product.SellerID,
+			"amount_available": product.AmountAvailable,
+			"reason":           input.Reason,
+		})
+	})
+	if err != nil {
+		return checkError(c, err, "unable to delete product")
+	}
+
+	return check(c, "", "product deleted successfully!", true, 200)
+}
+
+// AdminRefundOrder refunds any order the way its seller would, such as when the seller is gone.
+func (h *Handler) AdminRefundOrder(c *fiber.Ctx) error {
+
+	var input refundInput
+
+	adminID, err := getUserID(c)
+	if err != nil {
+		return check(c, err, err.Error(), false, 401)
+	}
+
+	orderID, err := getTargetID(c)
+	if err != nil {
+		return check(c, "", err.Error(), false, 400)
+	}
+
+	if err := c.BodyParser(&input); err != nil {
+		return check(c, err, err.Error(), false, 400)
+	}
+
+	if err := input.Validate(); err != nil {
+		return check(c, err, err.Error(), false, 400)
+	}
+
+	var output fiber.Map
+
+	err = h.store.Transaction(func(tx repository.Store) error {
+		order, err := tx.Orders().Find(orderID)
+		if err != nil {
+			return requestFailed(404, "order not found")
+		}
+
+		output, err = refundOrder(tx, order, input)
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(tx, models.AuditLog{
+			ActorID:    adminID,
+			Action:     audit.RefundOrder,
+			TargetType: audit.Order,
+			TargetID:   orderID,
+		}, fiber.Map{
+			"seller_id":       order.Product.SellerID,
+			"buyer_id":        order.UserID,
+			"units":           output["units"],
+			"amount_refunded": output["amount_refunded"],
+			"reason":          input.Reason,
+		})
+	})
+	if err != nil {
+		return checkError(c, err, "unable to refund order")
+	}
+
+	return check(c, output, "order refunded successfully", true, 200)
+}
+
+type adjustCoinsInput struct {
+	Coins  map[int]int `json:"coins"`
+	Reason string      `json:"reason"`
+}
+
+func (s adjustCoinsInput) Validate() error {
+	valid := validation.ValidateStruct(&s,
+		validation.Field(&s.Coins, validation.Required),
+		validation.Field(&s.Reason, validation.Required, validation.Length(1, 255)),
+	)
+
+	for coin, delta := range s.Coins {
+		if coin < 1 {
+			return errors.New("coins must be positive denominations")
+		}
+		if delta == 0 {
+			return errors.New("the count of coin " + strconv.Itoa(coin) + " cannot change by 0")
+		}
+	}
+
+	return valid
+}
+
+// AdjustCoins adds coins to a machine or takes them out, such as after a refill or a count.
+// Coins are only added in denominations the machine accepts, coins of any denomination can
+// be taken out. Coins paid out of a deposit go through PayOutDeposit, which clears it too.
+func (h *Handler) AdjustCoins(c *fiber.Ctx) error {
+
+	var input adjustCoinsInput
+
+	adminID, err := getUserID(c)
+	if err != nil {
+		return check(c, err, err.Error(), false, 401)
+	}
+
+	if err := c.BodyParser(&input); err != nil {
+		return check(c, err, err.Error(), false, 400)
+	}
+
+	if err := input.Validate(); err != nil {
+		return check(c, err, err.Error(), false, 400)
+	}
+
+	machineID, err := getMachineID(c, h.store)
+	if err != nil {
+		return check(c, "", err.Error(), false, 404)
+	}
+
+	var coins []models.Coin
+	err = h.store.Transaction(func(tx repository.Store) error {
+		accepted, err := tx.Coins().Denominations(machineID)
+		if err != nil {
+			return err
+		}
+
+		denominations := append([]int{}, accepted...)
+		for coin, delta := range input.Coins {
+			if delta > 0 && !contain(accepted, coin) {
+				return requestFailed(400, "the machine does not accept "+strconv.Itoa(coin))
+			}
+			if !contain(denominations, coin) {
+				denominations = append(denominations, coin)
+			}
+		}
+		sort.Ints(denominations)
+
+		movement := models.CoinMovement{Kind: inventory.Adjustment, MachineID: machineID, UserID: adminID}
+		err = inventory.Apply(tx, movement, input.Coins)
+		if errors.Is(err, inventory.ErrInsufficientCoins) {
+			return requestFailed(400, err.Error())
+		}
+		if err != nil {
+			return err
+		}
+
+		coins, err = tx.Coins().Lock(machineID, denominations)
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(tx, models.AuditLog{
+			ActorID:    adminID,
+			Action:     audit.AdjustCoins,
+			TargetType: audit.Machine,
+			TargetID:   machineID,
+		}, fiber.Map{"coins": input.Coins, "reason": input.Reason})
+	})
+	if err != nil {
+		return checkError(c, err, "unable to adjust coins")
+	}
+
+	type coin struct {
+		Denomination int `json:"denomination"`
+		Count        int `json:"count"`
+	}
+	allResult := make([]coin, 0)
+	for _, item := range coins {
+		allResult = append(allResult, coin{Denomination: item.Denomination, Count: item.Count})
+	}
+
+	output := fiber.Map{
+		"machine_id": machineID,
+		"coins":      allResult,
+	}
+	return check(c, output, "coins adjusted", true, 200)
+}
+
+type payOutDepositInput struct {
+	Coins  map[int]int `json:"coins"`
+	Clear  bool        `json:"clear"`
+	Reason string      `json:"reason"`
+}
+
+func (s payOutDepositInput) Validate() error {
+	valid := validation.ValidateStruct(&s,
+		validation.Field(&s.Reason, validation.Required, validation.Length(1, 255)),
+	)
+
+	if len(s.Coins) == 0 && !s.Clear {
+		return errors.New("pay out coins, clear the deposit or both")
+	}
+	for coin, count := range s.Coins {
+		if coin < 1 {
+			return errors.New("coins must be positive denominations")
+		}
+		if count < 1 {
+			return errors.New("the count of coin " + strconv.Itoa(coin) + " must be at least 1")
+		}
+	}
+
+	return valid
+}
+
+// PayOutDeposit fixes a deposit stuck in a machine that cannot make change for it. The coins
+// given are handed to the user out of the machine holding the deposit and debited from it,
+// clear debits whatever is left, such as an amount paid back outside the machine.
+func (h *Handler) PayOutDeposit(c *fiber.Ctx) error {
+
+	var input payOutDepositInput
+
+	adminID, err := getUserID(c)
+	if err != nil {
+		return check(c, err, err.Error(), false, 401)
+	}
+
+	userID, err := getTargetID(c)
+	if err != nil {
+		return check(c, "", err.Error(), false, 400)
+	}
+
+	if err := c.BodyParser(&input); err != nil {
+		return check(c, err, err.Error(), false, 400)
+	}
+
+	if err := input.Validate(); err != nil {
+		return check(c, err, err.Error(), false, 400)
+	}
+
+	var output fiber.Map
+	err = h.store.Transaction(func(tx repository.Store) error {
+		user, err := tx.Users().Lock(userID)
+		if err != nil {
+			return requestFailed(404, "user not found")
+		}
+		if user.Deposit == 0 {
+			return requestFailed(400, "user has no deposit")
+		}
+
+		paid := 0
+		deltas := make(map[int]int)
+		for coin, count := range input.Coins {
+			paid = paid + coin*count
+			deltas[coin] = -count
+		}
+		if paid > user.Deposit {
+			return requestFailed(400, "coins cannot add up to more than the deposit")
+		}
+
+		//the coins come out of the machine holding the deposit, like a reset
+		if paid > 0 {
+			movement := models.CoinMovement{Kind: inventory.Change, MachineID: user.DepositMachineID, UserID: user.ID}
+			err := inventory.Apply(tx, movement, deltas)
+			if errors.Is(err, inventory.ErrInsufficientCoins) {
+				return requestFailed(400, err.Error())
+			}
+			if err != nil {
+				return err
+			}
+
+			_, err = wallet.Post(tx, models.Wallet{UserID: user.ID, Debit: paid, Source: wallet.Reset})
+			if err != nil {
+				return err
+			}
+		}
+
+		cleared := 0
+		if input.Clear {
+			cleared = user.Deposit - paid
+		}
+		if cleared > 0 {
+			_, err := wallet.Post(tx, models.Wallet{UserID: user.ID, Debit: cleared, Source: wallet.Adjustment})
+			if err != nil {
+				return err
+			}
+		}
+
+		output = fiber.Map{
+			"user_id":    user.ID,
+			"machine_id": user.DepositMachineID,
+			"paid":       paid,
+			"cleared":    cleared,
+			"deposit":    user.Deposit - paid - cleared,
+			"change":     change.Coins(input.Coins),
+		}
+
+		return audit.Record(tx, models.AuditLog{
+			ActorID:    adminID,
+			Action:     audit.PayOutDeposit,
+			TargetType: audit.User,
+			TargetID:   user.ID,
+		}, fiber.Map{
+			"machine_id": user.DepositMachineID,
+			"coins":      input.Coins,
+			"paid":       paid,
+			"cleared":    cleared,
+			"reason":     input.Reason,
+		})
+	})
+	if err != nil {
+		return checkError(c, err, "unable to pay out deposit")
+	}
+
+	return check(c, output, "deposit paid out", true, 200)
+}
+
+// GetAuditLogs pages through the audit log newest first, narrowed by actor_id, action,
+// target_type, target_id and a from and to date.
+func (h *Handler) GetAuditLogs(c *fiber.Ctx) error {
+
+	cursor, limit, err := getPage(c)
+	if err != nil {
+		return check(c, "", err.Error(), false, 400)
+	}
+
+	from, to, err := getDateRange(c)
+	if err != nil {
+		return check(c, "", err.Error(), false, 400)
+	}
+
+	filter := repository.AuditFilter{
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+		Cursor:     cursor,
+		From:       from,
+		To:         to,
+		Limit:      limit,
+	}
+	for name, field := range map[string]*uint{"actor_id": &filter.ActorID, "target_id": &filter.TargetID} {
+		if value := c.Query(name); value != "" {
+			id, err := strconv.ParseUint(value, 10, 64)
+			if err != nil || id == 0 {
+				return check(c, "", name+" is invalid", false, 400)
+			}
+			*field = uint(id)
+		}
+	}
+
+	entries, err := h.store.AuditLogs().List(filter)
+	if err != nil {
+		return check(c, "", "unable to get audit log", false, 500)
+	}
+
+	type list struct {
+		ID         uint        `json:"id"`
+		ActorID    uint        `json:"actor_id"`
+		Actor      string      `json:"actor"`
+		Action     string      `json:"action"`
+		TargetType string      `json:"target_type"`
+		TargetID   uint        `json:"target_id"`
+		Details    interface{} `json:"details"`
+		CreatedAt  time.Time   `json:"created_at"`
+	}
+
+	allResult := make([]list, 0)
+	for _, item := range entries {
+		//details are stored as JSON already
+		var details interface{}
+		if item.Details != "" {
+			details = json.RawMessage(item.Details)
+		}
+		allResult = append(allResult, list{
+			ID:         item.ID,
+			ActorID:    item.ActorID,
+			Actor:      item.Actor.Username,
+			Action:     item.Action,
+			TargetType: item.TargetType,
+			TargetID:   item.TargetID,
+			Details:    details,
+			CreatedAt:  item.CreatedAt,
+		})
+	}
+
+	var nextCursor uint
+	if len(entries) == limit {
+		nextCursor = entries[len(entries)-1].ID
+	}
+
+	output := fiber.Map{
+		"entries":     allResult,
+		"next_cursor": nextCursor,
+	}
+	return check(c, output, "audit log", true, 200)
+}
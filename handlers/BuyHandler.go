@@ -5,19 +5,26 @@ import (
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/gofiber/fiber/v2"
 	"github.com/pkg/errors"
-	"mvpmatch/database"
+	"mvpmatch/change"
+	"mvpmatch/inventory"
 	"mvpmatch/models"
+	"mvpmatch/repository"
+	"mvpmatch/wallet"
 	"strconv"
 	"strings"
 )
 
-func getAllowedCoins() []int {
-	return []int{5, 10, 20, 50, 100}
+// getAllowedCoins reads the denominations a machine accepts, smallest first.
+func getAllowedCoins(store repository.Store, machineID uint) ([]int, error) {
+	return store.Coins().Denominations(machineID)
 }
 
-func getAllowedCoinsString() (string, error) {
+func getAllowedCoinsString(store repository.Store, machineID uint) (string, error) {
 
-	data := getAllowedCoins()
+	data, err := getAllowedCoins(store, machineID)
+	if err != nil {
+		return "", err
+	}
 	s, err := json.Marshal(data)
 	if err != nil {
 		return "", err
@@ -27,16 +34,20 @@ func getAllowedCoinsString() (string, error) {
 }
 
 type depositInput struct {
-	Coin int `json:"coin"`
+	Coin      int `json:"coin"`
+	machineID uint
 }
 
-func (s depositInput) Validate() error {
+func (s depositInput) Validate(store repository.Store) error {
 	valid := validation.ValidateStruct(&s,
 		validation.Field(&s.Coin, validation.Required),
 	)
-	allowedCoins := getAllowedCoins()
+	allowedCoins, err := getAllowedCoins(store, s.machineID)
+	if err != nil {
+		return errors.New("unable to read accepted coins")
+	}
 	if !contain(allowedCoins, s.Coin) {
-		allowedCoinsString, err := getAllowedCoinsString()
+		allowedCoinsString, err := getAllowedCoinsString(store, s.machineID)
 		if err != nil {
 			return errors.New("invalid coin")
 		}
@@ -46,10 +57,9 @@ func (s depositInput) Validate() error {
 	return valid
 }
 
-func Deposit(c *fiber.Ctx) error {
+func (h *Handler) Deposit(c *fiber.Ctx) error {
 
 	var input depositInput
-	db := database.DB
 
 	userID, err := getUserID(c)
 	if err != nil {
@@ -60,78 +70,98 @@ func Deposit(c *fiber.Ctx) error {
 		return check(c, err, err.Error(), false, 400)
 	}
 
-	if err := input.Validate(); err != nil {
-		return check(c, err, err.Error(), false, 400)
+	input.machineID, err = getMachineID(c, h.store)
+	if err != nil {
+		return check(c, "", err.Error(), false, 404)
 	}
 
-	//set buyer deposit balance
-	var buyer models.User
-	db.First(&buyer, userID)
-
-	balance := buyer.Deposit + input.Coin
-
-	updateUser := db.Model(&models.User{})
-	updateUser.Where(&models.User{ID: userID})
-	rows := updateUser.Updates(models.User{Deposit: balance})
-
-	if rows.RowsAffected == 0 {
-		return check(c, "", "unable to save deposit", false, 401)
+	if err := input.Validate(h.store); err != nil {
+		return check(c, err, err.Error(), false, 400)
 	}
 
-	//log transaction in wallet
-	wallet := models.Wallet{
-		UserID:  userID,
-		Credit:  input.Coin,
-		Balance: balance,
-	}
+	err = h.store.Transaction(func(tx repository.Store) error {
+
+		//a deposit stays in the machine it was made in until it is spent or reset
+		buyer, err := tx.Users().Lock(userID)
+		if err != nil {
+			return requestFailed(401, "unable to save deposit")
+		}
+		if err := checkDepositMachine(buyer, input.machineID); err != nil {
+			return err
+		}
+		if err := tx.Users().SetDepositMachine(userID, input.machineID); err != nil {
+			return err
+		}
 
-	//save coin
-	var isCoinExist models.Coin
-	rows = db.Where(&models.Coin{Denomination: input.Coin}).First(&isCoinExist)
-	if rows.RowsAffected == 0 {
-		//insert new coin
-		db.Create(&models.Coin{
-			Denomination: input.Coin,
-			Count:        1,
+		//log transaction in wallet, this also sets the buyer deposit balance
+		_, err = wallet.Post(tx, models.Wallet{
+			UserID: userID,
+			Credit: input.Coin,
+			Source: wallet.Deposit,
 		})
-	} else {
-		//update coin count
-		db.Model(&models.Coin{}).
-			Where("id = ?", isCoinExist.ID).
-			Updates(models.Coin{Count: isCoinExist.Count + 1})
-	}
+		if err != nil {
+			return err
+		}
 
-	db.Create(&wallet)
+		//save coin
+		movement := models.CoinMovement{Kind: inventory.Deposit, MachineID: input.machineID, UserID: userID}
+		return inventory.Apply(tx, movement, map[int]int{input.Coin: 1})
+	})
+	if err != nil {
+		return checkError(c, err, "unable to save deposit")
+	}
 
 	return check(c, "", "deposit saved successfully!", true, 200)
 }
 
 type buyInput struct {
-	ProductID uint `json:"product_id"`
-	Amount    int  `json:"amount"`
+	ProductID uint   `json:"product_id"`
+	Slot      string `json:"slot"`
+	Amount    int    `json:"amount"`
+	machineID uint
 }
 
-func (s buyInput) Validate() error {
+func (s buyInput) Validate(store repository.Store) error {
 	valid := validation.ValidateStruct(&s,
-		validation.Field(&s.ProductID, validation.Required),
+		validation.Field(&s.Amount, validation.Required, validation.Min(1)),
 	)
-	db := database.DB
-	var product models.Product
-	rows := db.Where(&models.Product{ID: s.ProductID}).First(&product)
-	if rows.RowsAffected == 0 {
+	if s.ProductID == 0 && s.Slot == "" {
+		return errors.New("product_id or slot is required")
+	}
+
+	//buying by slot code picks the product in that slot
+	if s.Slot != "" {
+		slot, err := store.Slots().Find(s.machineID, s.Slot)
+		if err != nil {
+			return errors.New("slot is invalid")
+		}
+		if slot.ProductID == 0 || (s.ProductID != 0 && s.ProductID != slot.ProductID) {
+			return errors.New("slot does not hold this product")
+		}
+		if slot.Fill < s.Amount {
+			return errors.New("amount selected exceeds available amount")
+		}
+		s.ProductID = slot.ProductID
+	}
+
+	product, err := store.Products().Find(s.ProductID)
+	if err != nil {
 		return errors.New("product_id is invalid")
 	}
 
+	if product.MachineID != s.machineID {
+		return errors.New("product is not sold in this machine")
+	}
+
 	if product.AmountAvailable < s.Amount {
 		return errors.New("amount selected exceeds available amount")
 	}
 
 	return valid
 }
-func Buy(c *fiber.Ctx) error {
+func (h *Handler) Buy(c *fiber.Ctx) error {
 
 	var input buyInput
-	db := database.DB
 
 	userID, err := getUserID(c)
 	if err != nil {
@@ -141,156 +171,180 @@ func Buy(c *fiber.Ctx) error {
 	if err := c.BodyParser(&input); err != nil {
 		return check(c, err, err.Error(), false, 400)
 	}
-	if err := input.Validate(); err != nil {
-		return check(c, err, err.Error(), false, 400)
-	}
 
-	//get buyer's deposit balance
-	var (
-		buyer   models.User
-		product models.Product
-	)
-	db.First(&buyer, userID)
-	db.First(&product, input.ProductID)
-
-	totalCost := product.Cost * input.Amount
-
-	if totalCost > buyer.Deposit {
-		return check(c, "", "insufficient deposit balance", false, 401)
+	input.machineID, err = getMachineID(c, h.store)
+	if err != nil {
+		return check(c, "", err.Error(), false, 404)
 	}
 
-	change := buyer.Deposit - totalCost
+	input.Slot = strings.ToUpper(input.Slot)
 
-	if change > 0 {
-		if !isMultipleOf5(change) {
-			return check(c, "", "Exact change or a multiple of 5 only", false, 400)
-		}
+	if err := input.Validate(h.store); err != nil {
+		return check(c, err, err.Error(), false, 400)
 	}
 
-	//check if product has enough quantity to match request
-	if product.AmountAvailable < input.Amount {
-		return check(c, "", "Insufficient product quantity, please reduce the amount", false, 400)
+	if input.Slot != "" {
+		slot, _ := h.store.Slots().Find(input.machineID, input.Slot)
+		input.ProductID = slot.ProductID
 	}
 
-	//get available coins
-	var availableCoins []models.Coin
-	rows := db.Order("denomination desc").Find(&availableCoins)
-	if rows.RowsAffected == 0 {
-		return check(c, "", "no available coins", false, 400)
-	}
+	var output fiber.Map
 
-	totalCoinAvailable := 0
-	for _, itemCoin := range availableCoins {
-		totalCoinAvailable = totalCoinAvailable + (itemCoin.Denomination * itemCoin.Count)
-	}
+	//every write below succeeds or fails together
+	err = h.store.Transaction(func(tx repository.Store) error {
 
-	var changeSlice []int
-	changeSliceNew := make(map[int]int)
+		//lock the buyer, the product and then the coins for the rest of the purchase,
+		//always in this order so concurrent purchases cannot deadlock
+		buyer, err := tx.Users().Lock(userID)
+		if err != nil {
+			return requestFailed(400, "account no longer valid")
+		}
+		product, err := tx.Products().Lock(input.ProductID)
+		if err != nil || product.MachineID != input.machineID {
+			return requestFailed(400, "product_id is invalid")
+		}
 
-	if change > 0 {
-		for _, item := range availableCoins {
-			itemCount := item.Count
+		if err := checkDepositMachine(buyer, input.machineID); err != nil {
+			return err
+		}
 
-			if (change == item.Denomination) && (itemCount > 0) {
-				change = change - item.Denomination
-				changeSlice = append(changeSlice, item.Denomination)
-				changeSliceNew[item.Denomination] = itemCount - 1
-			}
+		totalCost := product.Cost * input.Amount
 
-			for i := 1; i < 5; i++ {
-				if (change > item.Denomination) && (itemCount > 0) {
-					change = change - item.Denomination
-					changeSlice = append(changeSlice, item.Denomination)
-					itemCount = itemCount - 1
-					changeSliceNew[item.Denomination] = itemCount
-				}
-			}
+		if totalCost > buyer.Deposit {
+			return requestFailed(401, "insufficient deposit balance")
 		}
 
-		if len(changeSlice) == 0 {
-			return check(c, "", "no coins available for change", false, 400)
+		changeDue := buyer.Deposit - totalCost
+
+		//check if product has enough quantity to match request
+		if product.AmountAvailable < input.Amount {
+			return requestFailed(400, "Insufficient product quantity, please reduce the amount")
 		}
 
-		calculatedChange := 0
+		//snapshot the price so receipts do not depend on later edits
+		order := models.Order{
+			MachineID:     input.machineID,
+			ProductID:     input.ProductID,
+			UserID:        buyer.ID,
+			Amount:        input.Amount,
+			UnitPrice:     product.Cost,
+			TotalCost:     totalCost,
+			DepositBefore: buyer.Deposit,
+			DepositAfter:  0,
+		}
 
-		for _, eachChange := range changeSlice {
-			calculatedChange = calculatedChange + eachChange
+		if err := tx.Orders().Create(&order); err != nil {
+			return err
 		}
 
-		if calculatedChange > (totalCoinAvailable - totalCost) {
-			return check(c, "", "unable to sell product, insufficient change", false, 400)
+		//pay the change out of the coin inventory
+		movement := models.CoinMovement{
+			Kind:      inventory.Change,
+			MachineID: input.machineID,
+			UserID:    buyer.ID,
+			OrderID:   order.ID,
+		}
+		breakdown, err := inventory.PayOut(tx, movement, changeDue)
+		if errors.Is(err, change.ErrCannotMakeChange) {
+			return requestFailed(400, "unable to sell product, "+err.Error())
+		}
+		if err != nil {
+			return err
 		}
 
-		var sqlQueryCase string
-		counter := 0
-		for key, value := range changeSliceNew {
-			if counter == 0 {
-				sqlQueryCase = " UPDATE coins SET count = CASE "
-				sqlQueryCase = sqlQueryCase + " WHEN denomination = " + strconv.Itoa(key) + " THEN '" + strconv.Itoa(value) + "'"
-			} else {
-				sqlQueryCase = sqlQueryCase + " WHEN denomination = " + strconv.Itoa(key) + " THEN '" + strconv.Itoa(value) + "'"
-			}
-			counter++
+		//record the change breakdown on the order
+		for _, denomination := range change.Denominations(breakdown) {
+			order.Change = append(order.Change, models.OrderChange{
+				OrderID:      order.ID,
+				Denomination: denomination,
+				Count:        breakdown[denomination],
+			})
+		}
+		if err := tx.Orders().AddChange(order.Change); err != nil {
+			return err
 		}
-		sqlQueryCase = sqlQueryCase + " END "
-
-		var changeSliceString string
-		for i, cs := range changeSlice {
-			if i == 0 {
-				changeSliceString = changeSliceString + "'" + strconv.Itoa(cs) + "'"
-			} else {
-				changeSliceString = changeSliceString + ",'" + strconv.Itoa(cs) + "'"
-			}
 
+		changeSlice := change.Coins(breakdown)
+		if len(changeSlice) == 0 {
+			changeSlice = append(changeSlice, 0)
 		}
-		sqlQueryCase = sqlQueryCase + " WHERE denomination IN (" + changeSliceString + ")"
-		db.Exec(sqlQueryCase)
-	} else {
-		changeSlice = append(changeSlice, 0)
-	}
 
-	order := models.Order{
-		ProductID: input.ProductID,
-		UserID:    buyer.ID,
-		Amount:    input.Amount,
-	}
+		//update product inventory, taking the units out of its slots when it has any,
+		//the stock check guards against overselling
+		slotted, err := takeFromSlots(tx, product, input.Slot, input.Amount)
+		if err != nil {
+			return err
+		}
+		if !slotted && input.Slot != "" {
+			return requestFailed(400, "slot does not hold this product")
+		}
+		if !slotted {
+			taken, err := tx.Products().TakeStock(input.ProductID, input.Amount)
+			if err != nil {
+				return err
+			}
+			if !taken {
+				return requestFailed(400, "Insufficient product quantity, please reduce the amount")
+			}
+		}
 
-	rows = db.Create(&order)
-	if rows.RowsAffected == 0 {
-		return check(c, "", "unable to process order", false, 400)
-	}
+		//charge the buyer, then pay the rest of the balance out as change
+		_, err = wallet.Post(tx, models.Wallet{
+			UserID:      buyer.ID,
+			Debit:       totalCost,
+			Source:      wallet.Purchase,
+			ReferenceID: order.ID,
+		})
+		if err != nil {
+			return err
+		}
+		if changeDue > 0 {
+			_, err = wallet.Post(tx, models.Wallet{
+				UserID:      buyer.ID,
+				Debit:       changeDue,
+				Source:      wallet.Change,
+				ReferenceID: order.ID,
+			})
+			if err != nil {
+				return err
+			}
+		}
 
-	//update product inventory
-	productAmount := product.AmountAvailable - input.Amount
-	updateProduct := db.Model(&models.Product{})
-	updateProduct.Where(&models.Product{ID: input.ProductID})
-	updateProduct.Update("amount_available", productAmount)
-
-	//update user deposit
-	upDeposit := db.Model(&models.User{})
-	upDeposit.Where(&models.User{ID: buyer.ID})
-	upDeposit.Update("deposit", change)
-
-	amountSpent := product.Cost * input.Amount
-	output := fiber.Map{
-		"change":          changeSlice,
-		"amount_spent":    amountSpent,
-		"product":         product.ProductName,
-		"number_of_units": input.Amount,
+		output = fiber.Map{
+			"change":          changeSlice,
+			"amount_spent":    totalCost,
+			"product":         product.ProductName,
+			"number_of_units": input.Amount,
+		}
+		return nil
+	})
+	if err != nil {
+		return checkError(c, err, "unable to process order")
 	}
 
 	return check(c, output, "success", true, 200)
 }
 
-func isMultipleOf5(n int) bool {
+// checkDepositMachine stops a buyer from using a deposit held by another machine.
+func checkDepositMachine(buyer models.User, machineID uint) error {
+	if buyer.Deposit > 0 && buyer.DepositMachineID != machineID {
+		message := "your deposit is held by machine " + strconv.Itoa(int(buyer.DepositMachineID)) + ", spend or reset it there first"
+		return requestFailed(400, message)
+	}
+	return nil
+}
 
-	for n > 0 {
-		n = n - 5
+// checkCost makes sure a product can be paid for exactly with the smallest coin its machine accepts.
+func checkCost(store repository.Store, machineID uint, cost int) error {
+	allowedCoins, err := getAllowedCoins(store, machineID)
+	if err != nil || len(allowedCoins) == 0 {
+		return errors.New("unable to read accepted coins")
 	}
 
-	if n == 0 {
-		return true
+	smallest := allowedCoins[0]
+	if cost%smallest != 0 {
+		return errors.New("cost must be a multiple of " + strconv.Itoa(smallest))
 	}
 
-	return false
+	return nil
 }
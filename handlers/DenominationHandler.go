@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"mvpmatch/audit"
+	"mvpmatch/models"
+	"mvpmatch/repository"
+)
+
+func (h *Handler) GetDenominations(c *fiber.Ctx) error {
+
+	machineID, err := getMachineID(c, h.store)
+	if err != nil {
+		return check(c, "", err.Error(), false, 404)
+	}
+
+	allowedCoins, err := getAllowedCoins(h.store, machineID)
+	if err != nil {
+		return check(c, "", "unable to read accepted coins", false, 500)
+	}
+
+	return check(c, allowedCoins, "denominations", true, 200)
+}
+
+type denominationInput struct {
+	Value     int `json:"value"`
+	machineID uint
+}
+
+func (s denominationInput) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Value, validation.Required, validation.Min(1)),
+	)
+}
+
+func (s denominationInput) ValidateNew(store repository.Store) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+
+	allowedCoins, err := getAllowedCoins(store, s.machineID)
+	if err != nil {
+		return errors.New("unable to read accepted coins")
+	}
+	if contain(allowedCoins, s.Value) {
+		return errors.New("denomination is already accepted")
+	}
+
+	return nil
+}
+
+func (h *Handler) AddDenomination(c *fiber.Ctx) error {
+
+	var input denominationInput
+
+	adminID, err := getUserID(c)
+	if err != nil {
+		return check(c, err, err.Error(), false, 401)
+	}
+
+	if err := c.BodyParser(&input); err != nil {
+		return check(c, err, err.Error(), false, 400)
+	}
+
+	machineID, err := getMachineID(c, h.store)
+	if err != nil {
+		return check(c, "", err.Error(), false, 404)
+	}
+	input.machineID = machineID
+
+	if err := input.ValidateNew(h.store); err != nil {
+		return check(c, err, err.Error(), false, 400)
+	}
+
+	err = h.store.Transaction(func(tx repository.Store) error {
+		err := tx.Coins().AddDenomination(machineID, input.Value)
+		if errors.Is(err, repository.ErrDuplicate) {
+			return requestFailed(400, "denomination is already accepted")
+		}
+		if err != nil {
+			return requestFailed(400, "unable to add denomination")
+		}
+
+		return audit.Record(tx, models.AuditLog{
+			ActorID:    adminID,
+			Action:     audit.AddDenomination,
+			TargetType: audit.Machine,
+			TargetID:   machineID,
+		}, fiber.Map{"value": input.Value})
+	})
+	if err != nil {
+		return checkError(c, err, "unable to add denomination")
+	}
+
+	allowedCoins, _ := getAllowedCoins(h.store, machineID)
+	return check(c, allowedCoins, "denomination added successfully", true, 201)
+}
+
+// DeleteDenomination stops a machine accepting a coin, as long as it keeps accepting
+// another one and every product it sells can still be paid for exactly.
+func (h *Handler) DeleteDenomination(c *fiber.Ctx) error {
+
+	var input denominationInput
+
+	adminID, err := getUserID(c)
+	if err != nil {
+		return check(c, err, err.Error(), false, 401)
+	}
+
+	if err := c.BodyParser(&input); err != nil {
+		return check(c, err, err.Error(), false, 400)
+	}
+
+	if err := input.Validate(); err != nil {
+		return check(c, err, err.Error(), false, 400)
+	}
+
+	machineID, err := getMachineID(c, h.store)
+	if err != nil {
+		return check(c, "", err.Error(), false, 404)
+	}
+
+	allowedCoins, err := getAllowedCoins(h.store, machineID)
+	if err != nil {
+		return check(c, "", "unable to read accepted coins", false, 500)
+	}
+	if !contain(allowedCoins, input.Value) {
+		return check(c, "", "denomination is not accepted", false, 400)
+	}
+	if len(allowedCoins) == 1 {
+		return check(c, "", "the machine must accept at least one coin", false, 400)
+	}
+
+	err = h.store.Transaction(func(tx repository.Store) error {
+		if err := tx.Coins().RemoveDenomination(machineID, input.Value); err != nil {
+			return requestFailed(400, "unable to delete denomination")
+		}
+
+		//removing the smallest coin must leave every product payable with the next smallest
+		products, err := tx.Products().List(machineID)
+		if err != nil {
+			return err
+		}
+		for _, product := range products {
+			if err := checkCost(tx, machineID, product.Cost); err != nil {
+				return requestFailed(400, "product "+product.ProductName+" would not be payable: "+err.Error())
+			}
+		}
+
+		return audit.Record(tx, models.AuditLog{
+			ActorID:    adminID,
+			Action:     audit.DeleteDenomination,
+			TargetType: audit.Machine,
+			TargetID:   machineID,
+		}, fiber.Map{"value": input.Value})
+	})
+	if err != nil {
+		return checkError(c, err, "unable to delete denomination")
+	}
+
+	allowedCoins, _ = getAllowedCoins(h.store, machineID)
+	return check(c, allowedCoins, "denomination deleted successfully!", true, 200)
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"context"
+	"github.com/gofiber/fiber/v2"
+	"mvpmatch/config"
+	"mvpmatch/middleware"
+	"time"
+)
+
+// Health reports whether the database and Redis, which holds the revoked tokens, can be reached.
+// The service is unhealthy without its database, and without Redis unless tokens fail open meanwhile.
+func (h *Handler) Health(c *fiber.Ctx) error {
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), 2*time.Second)
+	defer cancel()
+
+	healthy := true
+
+	database := "up"
+	if err := h.store.Ping(ctx); err != nil {
+		database = "down"
+		healthy = false
+	}
+
+	redis := "up"
+	if err := h.revoked.Ping(ctx); err != nil {
+		redis = "down"
+		if config.Redis.Policy != middleware.FailOpen {
+			healthy = false
+		}
+	}
+
+	output := fiber.Map{
+		"database":     database,
+		"redis":        redis,
+		"redis_policy": config.Redis.Policy,
+	}
+	if !healthy {
+		return check(c, output, "unhealthy", false, 503)
+	}
+	return check(c, output, "healthy", true, 200)
+}
@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"mvpmatch/tokens"
+)
+
+// JWKS publishes the public keys tokens are verified with as a JSON Web Key Set, so other
+// services can check vending tokens themselves. It answers in the standard format rather
+// than the usual envelope.
+func (h *Handler) JWKS(c *fiber.Ctx) error {
+
+	keys := tokens.Keys()
+	if keys == nil {
+		return check(c, "", "signing keys are not loaded", false, 503)
+	}
+
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(keys.JWKS())
+}
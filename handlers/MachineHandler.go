@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"mvpmatch/audit"
+	"mvpmatch/inventory"
+	"mvpmatch/models"
+	"mvpmatch/repository"
+	"strconv"
+)
+
+// getMachineID reads the machine from the :machine route parameter.
+// Routes without one act on the default machine, the oldest one.
+func getMachineID(c *fiber.Ctx, store repository.Store) (uint, error) {
+	machines := store.Machines()
+
+	if value := c.Params("machine"); value != "" {
+		machineID, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return 0, errors.New("machine id is invalid")
+		}
+		machine, err := machines.Find(uint(machineID))
+		if err != nil {
+			return 0, errors.New("machine not found")
+		}
+		return machine.ID, nil
+	}
+
+	machine, err := machines.Default()
+	if err != nil {
+		return 0, errors.New("no machine has been set up")
+	}
+	return machine.ID, nil
+}
+
+func (h *Handler) GetMachines(c *fiber.Ctx) error {
+
+	machines, err := h.store.Machines().List()
+	if err != nil || len(machines) == 0 {
+		empty := make([]string, 0)
+		return check(c, empty, "no records found", true, 200)
+	}
+
+	type list struct {
+		ID            uint   `json:"id"`
+		Name          string `json:"name"`
+		Location      string `json:"location"`
+		Denominations []int  `json:"denominations"`
+	}
+
+	var allResult []list
+	for _, item := range machines {
+		denominations, _ := h.store.Coins().Denominations(item.ID)
+		result := list{
+			ID:            item.ID,
+			Name:          item.Name,
+			Location:      item.Location,
+			Denominations: denominations,
+		}
+
+		allResult = append(allResult, result)
+	}
+
+	return check(c, allResult, "machines", true, 200)
+}
+
+type addMachineInput struct {
+	Name          string `json:"name"`
+	Location      string `json:"location"`
+	Denominations []int  `json:"denominations"`
+}
+
+func (s addMachineInput) Validate() error {
+	valid := validation.ValidateStruct(&s,
+		validation.Field(&s.Name, validation.Required),
+	)
+
+	for _, value := range s.Denominations {
+		if value < 1 {
+			return errors.New("denominations must be positive")
+		}
+	}
+
+	return valid
+}
+
+func (h *Handler) AddMachine(c *fiber.Ctx) error {
+
+	var input addMachineInput
+
+	adminID, err := getUserID(c)
+	if err != nil {
+		return check(c, err, err.Error(), false, 401)
+	}
+
+	if err := c.BodyParser(&input); err != nil {
+		return check(c, err, err.Error(), false, 400)
+	}
+
+	if err := input.Validate(); err != nil {
+		return check(c, err, err.Error(), false, 400)
+	}
+
+	denominations := input.Denominations
+	if len(denominations) == 0 {
+		denominations = inventory.DefaultDenominations
+	}
+
+	machine := models.Machine{
+		Name:     input.Name,
+		Location: input.Location,
+	}
+
+	err = h.store.Transaction(func(tx repository.Store) error {
+		if err := tx.Machines().Create(&machine); err != nil {
+			return err
+		}
+
+		var seen []int
+		for _, value := range denominations {
+			if contain(seen, value) {
+				continue
+			}
+			seen = append(seen, value)
+			if err := tx.Coins().AddDenomination(machine.ID, value); err != nil {
+				return err
+			}
+		}
+
+		return audit.Record(tx, models.AuditLog{
+			ActorID:    adminID,
+			Action:     audit.AddMachine,
+			TargetType: audit.Machine,
+			TargetID:   machine.ID,
+		}, fiber.Map{"name": machine.Name, "location": machine.Location, "denominations": seen})
+	})
+	if err != nil {
+		return check(c, "", "unable to add machine", false, 400)
+	}
+
+	accepted, _ := h.store.Coins().Denominations(machine.ID)
+	output := fiber.Map{
+		"machine_id":    machine.ID,
+		"name":          machine.Name,
+		"location":      machine.Location,
+		"denominations": accepted,
+	}
+	return check(c, output, "machine created successfully", true, 201)
+}
@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"mvpmatch/change"
+	"mvpmatch/inventory"
+	"mvpmatch/models"
+	"mvpmatch/repository"
+	"mvpmatch/wallet"
+	"strconv"
+	"time"
+)
+
+type orderSummary struct {
+	ID         uint      `json:"id"`
+	ProductID  uint      `json:"product_id"`
+	Product    string    `json:"product"`
+	Buyer      string    `json:"buyer"`
+	Quantity   int       `json:"quantity"`
+	TotalSpent int       `json:"total_spent"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type orderReceipt struct {
+	OrderID       uint       `json:"order_id"`
+	MachineID     uint       `json:"machine_id"`
+	ProductID     uint       `json:"product_id"`
+	Product       string     `json:"product"`
+	Buyer         string     `json:"buyer"`
+	UnitCost      int        `json:"unit_cost"`
+	Quantity      int        `json:"quantity"`
+	TotalSpent    int        `json:"total_spent"`
+	DepositBefore int        `json:"deposit_before"`
+	DepositAfter  int        `json:"deposit_after"`
+	Change        []int      `json:"change"`
+	RefundedUnits int        `json:"refunded_units"`
+	RefundAmount  int        `json:"refund_amount"`
+	RefundReason  string     `json:"refund_reason"`
+	RefundedAt    *time.Time `json:"refunded_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// filterOrders reads the product, date and cursor query parameters shared by the order lists.
+func filterOrders(c *fiber.Ctx, filter repository.OrderFilter) (repository.OrderFilter, error) {
+	cursor, limit, err := getPage(c)
+	if err != nil {
+		return filter, err
+	}
+
+	from, to, err := getDateRange(c)
+	if err != nil {
+		return filter, err
+	}
+
+	if value := c.Query("product_id"); value != "" {
+		productID, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return filter, errors.New("product_id is invalid")
+		}
+		filter.ProductID = uint(productID)
+	}
+
+	filter.Cursor = cursor
+	filter.From = from
+	filter.To = to
+	filter.Limit = limit
+	return filter, nil
+}
+
+func (h *Handler) listOrders(c *fiber.Ctx, filter repository.OrderFilter) error {
+	filter, err := filterOrders(c, filter)
+	if err != nil {
+		return check(c, "", err.Error(), false, 400)
+	}
+
+	orders, err := h.store.Orders().List(filter)
+	if err != nil {
+		return check(c, "", "unable to get orders", false, 500)
+	}
+
+	allResult := make([]orderSummary, 0)
+	for _, item := range orders {
+		allResult = append(allResult, orderSummary{
+			ID:         item.ID,
+			ProductID:  item.ProductID,
+			Product:    item.Product.ProductName,
+			Buyer:      item.User.Username,
+			Quantity:   item.Amount,
+			TotalSpent: item.TotalCost,
+			CreatedAt:  item.CreatedAt,
+		})
+	}
+
+	var nextCursor uint
+	if len(orders) == filter.Limit {
+		nextCursor = orders[len(orders)-1].ID
+	}
+
+	output := fiber.Map{
+		"orders":      allResult,
+		"next_cursor": nextCursor,
+	}
+	return check(c, output, "orders", true, 200)
+}
+
+// GetOrders lists the purchases of the authenticated buyer.
+func (h *Handler) GetOrders(c *fiber.Ctx) error {
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return check(c, err, err.Error(), false, 401)
+	}
+
+	return h.listOrders(c, repository.OrderFilter{UserID: userID})
+}
+
+// GetSellerOrders lists the sales of every product the authenticated seller owns.
+func (h *Handler) GetSellerOrders(c *fiber.Ctx) error {
+
+	sellerID, err := getUserID(c)
+	if err != nil {
+		return check(c, err, err.Error(), false, 401)
+	}
+
+	return h.listOrders(c, repository.OrderFilter{SellerID: sellerID})
+}
+
+// buildReceipt reads what was paid and the coins returned from the order snapshot.
+func buildReceipt(store repository.Store, order models.Order) orderReceipt {
+	receipt := orderReceipt{
+		OrderID:       order.ID,
+		MachineID:     order.MachineID,
+		ProductID:     order.ProductID,
+		Product:       order.Product.ProductName,
+		Buyer:         order.User.Username,
+		UnitCost:      order.UnitPrice,
+		Quantity:      order.Amount,
+		TotalSpent:    order.TotalCost,
+		DepositBefore: order.DepositBefore,
+		DepositAfter:  order.DepositAfter,
+		RefundedUnits: order.RefundedUnits,
+		RefundAmount:  order.RefundAmount,
+		RefundReason:  order.RefundReason,
+		RefundedAt:    order.RefundedAt,
+		CreatedAt:     order.CreatedAt,
+	}
+
+	breakdown := make(map[int]int)
+	for _, item := range order.Change {
+		breakdown[item.Denomination] += item.Count
+	}
+
+	//orders placed before the snapshot existed are rebuilt from the ledgers
+	if order.UnitPrice == 0 {
+		debit, err := store.Wallets().FindByReference(wallet.Purchase, order.ID)
+		if err == nil {
+			receipt.TotalSpent = debit.Debit
+			receipt.DepositBefore = debit.Balance + debit.Debit
+		}
+		if order.Amount > 0 {
+			receipt.UnitCost = receipt.TotalSpent / order.Amount
+		}
+
+		movements, _ := store.Coins().Movements(order.ID, inventory.Change)
+		for _, item := range movements {
+			breakdown[item.Denomination] += -item.Delta
+		}
+	}
+
+	receipt.Change = change.Coins(breakdown)
+
+	return receipt
+}
+
+// GetOrderReceipt shows the receipt of one of the authenticated buyer's orders.
+func (h *Handler) GetOrderReceipt(c *fiber.Ctx) error {
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return check(c, err, err.Error(), false, 401)
+	}
+
+	orderID, err := c.ParamsInt("id")
+	if err != nil || orderID < 1 {
+		return check(c, "", "id is invalid", false, 400)
+	}
+
+	order, err := h.store.Orders().Find(uint(orderID))
+	if err != nil || order.UserID != userID {
+		return check(c, "", "order not found", false, 404)
+	}
+
+	return check(c, buildReceipt(h.store, order), "receipt", true, 200)
+}
+
+// GetSellerOrderReceipt shows the receipt of a sale of one of the authenticated seller's products.
+func (h *Handler) GetSellerOrderReceipt(c *fiber.Ctx) error {
+
+	sellerID, err := getUserID(c)
+	if err != nil {
+		return check(c, err, err.Error(), false, 401)
+	}
+
+	orderID, err := c.ParamsInt("id")
+	if err != nil || orderID < 1 {
+		return check(c, "", "id is invalid", false, 400)
+	}
+
+	order, err := h.store.Orders().Find(uint(orderID))
+	if err != nil || order.Product.SellerID != sellerID {
+		return check(c, "", "order not found", false, 404)
+	}
+
+	return check(c, buildReceipt(h.store, order), "receipt", true, 200)
+}
+
+type refundInput struct {
+	Units  int    `json:"units"`
+	Reason string `json:"reason"`
+}
+
+func (s refundInput) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Units, validation.Min(0)),
+		validation.Field(&s.Reason, validation.Required),
+	)
+}
+
+// RefundOrder lets a seller refund the sales of their own products. Leaving units out
+// refunds every unit not refunded yet, an order can be refunded in parts until none are left.
+func (h *Handler) RefundOrder(c *fiber.Ctx) error {
+
+	var input refundInput
+
+	sellerID, err := getUserID(c)
+	if err != nil {
+		return check(c, err, err.Error(), false, 401)
+	}
+
+	orderID, err := c.ParamsInt("id")
+	if err != nil || orderID < 1 {
+		return check(c, "", "id is invalid", false, 400)
+	}
+
+	if err := c.BodyParser(&input); err != nil {
+		return check(c, err, err.Error(), false, 400)
+	}
+
+	if err := input.Validate(); err != nil {
+		return check(c, err, err.Error(), false, 400)
+	}
+
+	var output fiber.Map
+
+	err = h.store.Transaction(func(tx repository.Store) error {
+
+		//only the seller of the product can refund its sales
+		order, err := tx.Orders().Find(uint(orderID))
+		if err != nil || order.Product.SellerID != sellerID {
+			return requestFailed(404, "order not found")
+		}
+
+		output, err = refundOrder(tx, order, input)
+		return err
+	})
+	if err != nil {
+		return checkError(c, err, "unable to refund order")
+	}
+
+	return check(c, output, "order refunded successfully", true, 200)
+}
+
+// refundOrder puts the refunded units back on sale and credits the buyer's deposit.
+func refundOrder(tx repository.Store, order models.Order, input refundInput) (fiber.Map, error) {
+
+	//lock the buyer before the order and its product, the same order a purchase uses
+	buyer, err := tx.Users().Lock(order.UserID)
+	if errors.Is(err, repository.ErrNotFound) {
+		//a deleted buyer has no deposit left to credit
+		return nil, requestFailed(400, "the buyer's account has been deleted, the order cannot be refunded")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Products().Lock(order.ProductID); err != nil {
+		return nil, requestFailed(404, "order not found")
+	}
+	order, err = tx.Orders().Lock(order.ID)
+	if err != nil {
+		return nil, requestFailed(404, "order not found")
+	}
+
+	remaining := order.Amount - order.RefundedUnits
+	if remaining <= 0 {
+		return nil, requestFailed(400, "order has already been refunded")
+	}
+
+	units := input.Units
+	if units == 0 {
+		units = remaining
+	}
+	if units > remaining {
+		return nil, requestFailed(400, "units cannot be more than the units not refunded yet")
+	}
+
+	amount := buildReceipt(tx, order).UnitCost * units
+
+	//the refund is held by the machine that sold the order, a deposit is only ever held by one machine
+	if buyer.Deposit > 0 && buyer.DepositMachineID != order.MachineID {
+		return nil, requestFailed(400, "the buyer holds a deposit in another machine, it has to be spent or reset first")
+	}
+	if buyer.Deposit == 0 {
+		if err := tx.Users().SetDepositMachine(buyer.ID, order.MachineID); err != nil {
+			return nil, err
+		}
+	}
+
+	//put the units back on sale, into the product's slots when it has any
+	slotted, returned, err := returnToSlots(tx, order.ProductID, units)
+	if err != nil {
+		return nil, err
+	}
+	if slotted && returned < units {
+		return nil, requestFailed(400, "the product's slots have no room for the refunded units")
+	}
+	if !slotted {
+		if err := tx.Products().AddStock(order.ProductID, units); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = wallet.Post(tx, models.Wallet{
+		UserID:      order.UserID,
+		Credit:      amount,
+		Source:      wallet.Refund,
+		ReferenceID: order.ID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Orders().Refund(order.ID, units, amount, input.Reason, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return fiber.Map{
+		"order_id":        order.ID,
+		"units":           units,
+		"amount_refunded": amount,
+		"units_left":      remaining - units,
+		"reason":          input.Reason,
+	}, nil
+}
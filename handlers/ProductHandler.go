@@ -4,33 +4,36 @@ import (
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/gofiber/fiber/v2"
 	"github.com/pkg/errors"
-	"gorm.io/gorm/clause"
-	"mvpmatch/database"
 	"mvpmatch/models"
+	"mvpmatch/repository"
+	"strconv"
 )
 
 type addProductInput struct {
+	MachineID       uint   `json:"machine_id"`
 	AmountAvailable int    `json:"amount_available"`
 	Cost            int    `json:"cost"`
 	ProductName     string `json:"product_name"`
 }
 
-func (s addProductInput) Validate() error {
+func (s addProductInput) Validate(store repository.Store) error {
 	valid := validation.ValidateStruct(&s,
 		validation.Field(&s.AmountAvailable, validation.Required),
 		validation.Field(&s.Cost, validation.Required),
 		validation.Field(&s.ProductName, validation.Required),
 	)
 
-	db := database.DB
-	rows := db.Where(&models.Product{ProductName: s.ProductName}).First(&models.Product{})
-	if rows.RowsAffected == 1 {
+	if _, err := store.Products().FindByName(s.ProductName); err == nil {
 		return errors.New("product_name already exists!")
 	}
 
-	//cost must be a multiple of 5
-	if !isMultipleOf5(s.Cost) {
-		return errors.New("cost must be a multiple of 5")
+	if _, err := store.Machines().Find(s.MachineID); err != nil {
+		return errors.New("machine_id is invalid")
+	}
+
+	//cost must be a multiple of the smallest coin
+	if err := checkCost(store, s.MachineID, s.Cost); err != nil {
+		return err
 	}
 
 	if s.AmountAvailable == 0 {
@@ -39,10 +42,9 @@ func (s addProductInput) Validate() error {
 
 	return valid
 }
-func AddProduct(c *fiber.Ctx) error {
+func (h *Handler) AddProduct(c *fiber.Ctx) error {
 
 	var input addProductInput
-	db := database.DB
 
 	userID, err := getUserID(c)
 	if err != nil {
@@ -53,30 +55,40 @@ func AddProduct(c *fiber.Ctx) error {
 		return check(c, err, err.Error(), false, 400)
 	}
 
-	if err := input.Validate(); err != nil {
+	//products go into the default machine unless one is given
+	if input.MachineID == 0 {
+		input.MachineID, err = getMachineID(c, h.store)
+		if err != nil {
+			return check(c, "", err.Error(), false, 400)
+		}
+	}
+
+	if err := input.Validate(h.store); err != nil {
 		return check(c, err, err.Error(), false, 400)
 	}
 
-	var user models.User
-	row := db.First(&user, userID)
-	if row.RowsAffected == 0 {
+	if _, err := h.store.Users().Find(userID); err != nil {
 		return check(c, "", "account no longer valid", false, 400)
 	}
 
 	product := models.Product{
+		MachineID:       input.MachineID,
 		AmountAvailable: input.AmountAvailable,
 		Cost:            input.Cost,
 		ProductName:     input.ProductName,
 		SellerID:        userID,
 	}
 
-	row = db.Create(&product)
-	if row.RowsAffected == 0 {
+	if err := h.store.Products().Create(&product); err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			return check(c, "", "product_name already exists!", false, 400)
+		}
 		return check(c, "", "unable to add product", false, 400)
 	}
 
 	output := fiber.Map{
 		"product_id":       product.ID,
+		"machine_id":       product.MachineID,
 		"name":             input.ProductName,
 		"amount_available": input.AmountAvailable,
 		"cost":             input.Cost,
@@ -85,19 +97,34 @@ func AddProduct(c *fiber.Ctx) error {
 	return check(c, output, "product created successfully", true, 201)
 }
 
-func GetProducts(c *fiber.Ctx) error {
+func (h *Handler) GetProducts(c *fiber.Ctx) error {
+
+	var machineID uint
 
-	db := database.DB
+	//list a single machine when one is asked for
+	if c.Params("machine") != "" {
+		var err error
+		machineID, err = getMachineID(c, h.store)
+		if err != nil {
+			return check(c, "", err.Error(), false, 404)
+		}
+	} else if value := c.Query("machine_id"); value != "" {
+		queryID, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || queryID == 0 {
+			return check(c, "", "machine_id is invalid", false, 400)
+		}
+		machineID = uint(queryID)
+	}
 
-	var products []models.Product
-	rows := db.Preload(clause.Associations).Find(&products)
-	if rows.RowsAffected == 0 {
+	products, err := h.store.Products().List(machineID)
+	if err != nil || len(products) == 0 {
 		empty := make([]string, 0)
 		return check(c, empty, "no records found", true, 200)
 	}
 
 	type list struct {
 		ID              uint   `json:"id"`
+		MachineID       uint   `json:"machine_id"`
 		ProductName     string `json:"product_name"`
 		AmountAvailable int    `json:"amount_available"`
 		Seller          string `json:"seller"`
@@ -108,6 +135,7 @@ func GetProducts(c *fiber.Ctx) error {
 	for _, item := range products {
 		result := list{
 			ID:              item.ID,
+			MachineID:       item.MachineID,
 			ProductName:     item.ProductName,
 			AmountAvailable: item.AmountAvailable,
 			Seller:          item.Seller.Username,
@@ -127,7 +155,7 @@ type editProductInput struct {
 	ProductName     string `json:"product_name"`
 }
 
-func (s editProductInput) Validate() error {
+func (s editProductInput) Validate(store repository.Store) error {
 	valid := validation.ValidateStruct(&s,
 		validation.Field(&s.ProductID, validation.Required),
 		validation.Field(&s.AmountAvailable, validation.Required),
@@ -135,29 +163,48 @@ func (s editProductInput) Validate() error {
 		validation.Field(&s.ProductName, validation.Required),
 	)
 
-	db := database.DB
-	rows := db.Where(&models.Product{ID: s.ProductID}).First(&models.Product{})
-	if rows.RowsAffected == 0 {
+	product, err := store.Products().Find(s.ProductID)
+	if err != nil {
 		return errors.New("product_id is invalid")
 	}
 
-	//cost must be a multiple of 5
-	if !isMultipleOf5(s.Cost) {
-		return errors.New("cost must be a multiple of 5")
+	//cost must be a multiple of the smallest coin
+	if err := checkCost(store, product.MachineID, s.Cost); err != nil {
+		return err
 	}
 
 	return valid
 }
-func EditProduct(c *fiber.Ctx) error {
+
+// checkProductEdit rejects an edit that changes the stock of a product kept in slots
+// or gives the product the name of another one.
+func checkProductEdit(store repository.Store, input editProductInput, product models.Product) error {
+
+	//stock of a product in slots only changes through its slots
+	slots, _ := store.Slots().CountForProduct(product.ID)
+	if slots > 0 && input.AmountAvailable != product.AmountAvailable {
+		return errors.New("amount_available is set by the product's slots, restock them instead")
+	}
+
+	//check name validity
+	nameExist, err := store.Products().FindByName(input.ProductName)
+	if err == nil && nameExist.ID != product.ID {
+		return errors.New("product_name exists!")
+	}
+
+	return nil
+}
+
+func (h *Handler) EditProduct(c *fiber.Ctx) error {
 
 	var input editProductInput
-	db := database.DB
+	products := h.store.Products()
 
 	if err := c.BodyParser(&input); err != nil {
 		return check(c, err, err.Error(), false, 400)
 	}
 
-	if err := input.Validate(); err != nil {
+	if err := input.Validate(h.store); err != nil {
 		return check(c, err, err.Error(), false, 400)
 	}
 
@@ -166,35 +213,34 @@ func EditProduct(c *fiber.Ctx) error {
 		return check(c, err, err.Error(), false, 401)
 	}
 
-	//check if user owns product
-	var isSellerProduct models.Product
-	rows := db.Where(&models.Product{ID: input.ProductID, SellerID: sellerID}).First(&isSellerProduct)
-	if rows.RowsAffected == 0 {
-		return check(c, "", "permission denied!", false, 401)
-	}
+	//lock the product so a sale or restock cannot change it between the checks and the update
+	err = h.store.Transaction(func(tx repository.Store) error {
+		//check if user owns product
+		isSellerProduct, err := tx.Products().Lock(input.ProductID)
+		if err != nil || isSellerProduct.SellerID != sellerID {
+			return requestFailed(401, "permission denied!")
+		}
 
-	//check name validity
-	var nameExist models.Product
-	rows = db.Where("product_name = ?", input.ProductName).First(&nameExist)
-	if rows.RowsAffected == 1 {
-		if nameExist.ID != input.ProductID {
-			return check(c, "", "product_name exists!", false, 400)
+		if err := checkProductEdit(tx, input, isSellerProduct); err != nil {
+			return requestFailed(400, err.Error())
 		}
-	}
 
-	updateProduct := db.Model(&models.Product{})
-	updateProduct.Where(&models.Product{ID: input.ProductID})
-	rows = updateProduct.Updates(models.Product{
-		AmountAvailable: input.AmountAvailable,
-		Cost:            input.Cost,
-		ProductName:     input.ProductName,
+		err = tx.Products().Update(models.Product{
+			ID:              input.ProductID,
+			AmountAvailable: input.AmountAvailable,
+			Cost:            input.Cost,
+			ProductName:     input.ProductName,
+		})
+		if errors.Is(err, repository.ErrDuplicate) {
+			return requestFailed(400, "product_name exists!")
+		}
+		return err
 	})
-	if rows.RowsAffected == 0 {
-		return check(c, "", "unable to update product", false, 400)
+	if err != nil {
+		return checkError(c, err, "unable to update product")
 	}
 
-	var product models.Product
-	db.Where("id = ?", input.ProductID).Preload(clause.Associations).First(&product)
+	product, _ := products.Find(input.ProductID)
 
 	output := fiber.Map{
 		"amount_available": product.AmountAvailable,
@@ -208,29 +254,39 @@ type delProductInput struct {
 	ProductID uint `json:"product_id"`
 }
 
-func (s delProductInput) Validate() error {
+func (s delProductInput) Validate(store repository.Store) error {
 	valid := validation.ValidateStruct(&s,
 		validation.Field(&s.ProductID, validation.Required),
 	)
 
-	db := database.DB
-	rows := db.Where(&models.Product{ID: s.ProductID}).First(&models.Product{})
-	if rows.RowsAffected == 0 {
+	if _, err := store.Products().Find(s.ProductID); err != nil {
 		return errors.New("product_id is invalid")
 	}
 
 	return valid
 }
-func DeleteProduct(c *fiber.Ctx) error {
+
+// deleteProduct removes a product and empties the slots that carried it, inside tx.
+func deleteProduct(tx repository.Store, productID uint) error {
+	if err := tx.Slots().Clear(productID); err != nil {
+		return err
+	}
+
+	if err := tx.Products().Delete(productID); err != nil {
+		return requestFailed(400, "unable to delete product")
+	}
+	return nil
+}
+
+func (h *Handler) DeleteProduct(c *fiber.Ctx) error {
 
 	var input delProductInput
-	db := database.DB
 
 	if err := c.BodyParser(&input); err != nil {
 		return check(c, err, err.Error(), false, 400)
 	}
 
-	if err := input.Validate(); err != nil {
+	if err := input.Validate(h.store); err != nil {
 		return check(c, err, err.Error(), false, 400)
 	}
 
@@ -240,15 +296,16 @@ func DeleteProduct(c *fiber.Ctx) error {
 	}
 
 	//check if user owns product
-	var isSellerProduct models.Product
-	rows := db.Where(&models.Product{ID: input.ProductID, SellerID: sellerID}).First(&isSellerProduct)
-	if rows.RowsAffected == 0 {
+	isSellerProduct, err := h.store.Products().Find(input.ProductID)
+	if err != nil || isSellerProduct.SellerID != sellerID {
 		return check(c, "", "permission denied!", false, 401)
 	}
 
-	row := db.Delete(&models.Product{ID: input.ProductID})
-	if row.RowsAffected == 0 {
-		return check(c, "", "unable to delete product", false, 400)
+	err = h.store.Transaction(func(tx repository.Store) error {
+		return deleteProduct(tx, input.ProductID)
+	})
+	if err != nil {
+		return checkError(c, err, "unable to delete product")
 	}
 
 	return check(c, "", "product deleted successfully!", true, 200)
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"mvpmatch/audit"
+	"mvpmatch/models"
+	"mvpmatch/permissions"
+	"mvpmatch/repository"
+)
+
+type adminRole struct {
+	ID             uint     `json:"id"`
+	Name           string   `json:"name"`
+	SelfAssignable bool     `json:"self_assignable"`
+	Permissions    []string `json:"permissions"`
+}
+
+func toAdminRole(store repository.Store, role models.Role) (adminRole, error) {
+	granted, err := store.Permissions().ForRole(role.ID)
+	if err != nil {
+		return adminRole{}, err
+	}
+
+	names := make([]string, 0)
+	for _, permission := range granted {
+		names = append(names, permission.Name)
+	}
+
+	return adminRole{
+		ID:             role.ID,
+		Name:           role.Name,
+		SelfAssignable: role.SelfAssignable,
+		Permissions:    names,
+	}, nil
+}
+
+// GetPermissions lists every permission a role can be granted.
+func (h *Handler) GetPermissions(c *fiber.Ctx) error {
+
+	list, err := h.store.Permissions().List()
+	if err != nil {
+		return check(c, "", "unable to get permissions", false, 500)
+	}
+
+	type permission struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+
+	allResult := make([]permission, 0)
+	for _, item := range list {
+		allResult = append(allResult, permission{Name: item.Name, Description: item.Description})
+	}
+
+	return check(c, allResult, "permissions", true, 200)
+}
+
+// GetAdminRoles lists every role with its permissions and whether users can pick it at sign-up.
+func (h *Handler) GetAdminRoles(c *fiber.Ctx) error {
+
+	roles, err := h.store.Roles().List()
+	if err != nil {
+		return check(c, "", "unable to get roles", false, 500)
+	}
+
+	allResult := make([]adminRole, 0)
+	for _, item := range roles {
+		result, err := toAdminRole(h.store, item)
+		if err != nil {
+			return check(c, "", "unable to get roles", false, 500)
+		}
+		allResult = append(allResult, result)
+	}
+
+	return check(c, allResult, "roles", true, 200)
+}
+
+type editRoleInput struct {
+	Permissions    []string `json:"permissions"`
+	SelfAssignable bool     `json:"self_assignable"`
+}
+
+// EditRole replaces the permissions of a role and whether users can pick it at sign-up,
+// it applies at once to every token of the role.
+func (h *Handler) EditRole(c *fiber.Ctx) error {
+
+	var input editRoleInput
+
+	adminID, err := getUserID(c)
+	if err != nil {
+		return check(c, err, err.Error(), false, 401)
+	}
+
+	roleID, err := getTargetID(c)
+	if err != nil {
+		return check(c, "", err.Error(), false, 400)
+	}
+
+	if err := c.BodyParser(&input); err != nil {
+		return check(c, err, err.Error(), false, 400)
+	}
+
+	role, err := h.store.Roles().Find(roleID)
+	if err != nil {
+		return check(c, "", "role not found", false, 404)
+	}
+
+	//an admin keeps the right to undo their own change
+	if role.Name == getRole(c) && !contains(input.Permissions, permissions.RoleWrite) {
+		return check(c, "", "you cannot take "+permissions.RoleWrite+" from your own role", false, 400)
+	}
+
+	err = h.store.Transaction(func(tx repository.Store) error {
+		before, err := toAdminRole(tx, role)
+		if err != nil {
+			return err
+		}
+
+		var ids []uint
+		seen := map[string]bool{}
+		for _, name := range input.Permissions {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+
+			permission, err := tx.Permissions().FindByName(name)
+			if err != nil {
+				return requestFailed(400, "permission "+name+" does not exist")
+			}
+			ids = append(ids, permission.ID)
+		}
+
+		if err := tx.Permissions().SetForRole(roleID, ids); err != nil {
+			return err
+		}
+		if err := tx.Roles().SetSelfAssignable(roleID, input.SelfAssignable); err != nil {
+			return err
+		}
+
+		role.SelfAssignable = input.SelfAssignable
+		after, err := toAdminRole(tx, role)
+		if err != nil {
+			return err
+		}
+
+		return audit.Record(tx, models.AuditLog{
+			ActorID:    adminID,
+			Action:     audit.EditRole,
+			TargetType: audit.Role,
+			TargetID:   roleID,
+		}, fiber.Map{"before": before, "after": after})
+	})
+	if err != nil {
+		return checkError(c, err, "unable to edit role")
+	}
+
+	role, _ = h.store.Roles().Find(roleID)
+	output, err := toAdminRole(h.store, role)
+	if err != nil {
+		return check(c, "", "unable to get role", false, 500)
+	}
+	return check(c, output, "role edited successfully", true, 200)
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
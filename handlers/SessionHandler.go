@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/gofiber/fiber/v2"
+	"mvpmatch/models"
+	"mvpmatch/repository"
+	"mvpmatch/tokens"
+	"time"
+)
+
+// maxDeviceLength keeps the device name of a session within its column.
+const maxDeviceLength = 255
+
+// startSession opens a session for a device and returns it with its first refresh token.
+func startSession(tx repository.Store, userID uint, device string) (models.Session, string, error) {
+	if len(device) > maxDeviceLength {
+		device = device[:maxDeviceLength]
+	}
+
+	now := time.Now()
+	session := models.Session{
+		UserID:     userID,
+		Device:     device,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(tokens.RefreshLifetime()),
+	}
+	if err := tx.Sessions().Create(&session); err != nil {
+		return session, "", err
+	}
+
+	refreshToken, err := addRefreshToken(tx, session)
+	return session, refreshToken, err
+}
+
+func addRefreshToken(tx repository.Store, session models.Session) (string, error) {
+	refreshToken, hash := tokens.NewRefreshToken()
+	err := tx.Sessions().CreateToken(&models.RefreshToken{
+		SessionID: session.ID,
+		TokenHash: hash,
+		ExpiresAt: session.ExpiresAt,
+	})
+	return refreshToken, err
+}
+
+type refreshInput struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (s refreshInput) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.RefreshToken, validation.Required),
+	)
+}
+
+// RefreshToken swaps a refresh token for a new access token and the next refresh token of
+// its session. A refresh token works once, using one again means it was copied, so the
+// session it belongs to is ended for whoever holds it.
+func (h *Handler) RefreshToken(c *fiber.Ctx) error {
+
+	var input refreshInput
+	if err := c.BodyParser(&input); err != nil {
+		return check(c, err, err.Error(), false, 400)
+	}
+
+	if err := input.Validate(); err != nil {
+		return check(c, err, err.Error(), false, 400)
+	}
+
+	var (
+		user         models.User
+		session      models.Session
+		refreshToken string
+		reused       bool
+	)
+	now := time.Now()
+	err := h.store.Transaction(func(tx repository.Store) error {
+		sessions := tx.Sessions()
+
+		token, err := sessions.FindToken(tokens.HashRefreshToken(input.RefreshToken))
+		if err != nil {
+			return requestFailed(401, "refresh token is invalid")
+		}
+		session = token.Session
+
+		if session.RevokedAt != nil || !now.Before(session.ExpiresAt) || !now.Before(token.ExpiresAt) {
+			return requestFailed(401, "session has ended, login again")
+		}
+
+		used, err := sessions.UseToken(token.ID, now)
+		if err != nil {
+			return err
+		}
+		if !used {
+			//end the session but keep the transaction, the revocation has to stick
+			reused = true
+			return sessions.Revoke(session.ID, now)
+		}
+
+		user, err = tx.Users().Find(session.UserID)
+		if err != nil {
+			return requestFailed(401, "account no longer valid")
+		}
+		if user.SuspendedAt != nil {
+			return requestFailed(403, "account suspended, contact support")
+		}
+
+		session.LastUsedAt = now
+		session.ExpiresAt = now.Add(tokens.RefreshLifetime())
+		if err := sessions.Touch(session.ID, session.LastUsedAt, session.ExpiresAt); err != nil {
+			return err
+		}
+
+		refreshToken, err = addRefreshToken(tx, session)
+		return err
+	})
+	if err != nil {
+		return checkError(c, err, "unable to refresh token")
+	}
+
+	if reused {
+		//the access tokens of the session go too, as far as the list can be reached
+		_ = h.revoked.RevokeSession(c.UserContext(), session.ID)
+		return check(c, "", "refresh token was already used, session ended", false, 401)
+	}
+
+	accessToken, err := tokens.Sign(user, session.ID)
+	if err != nil {
+		return check(c, "", "Unable to generate token", false, 500)
+	}
+
+	result := fiber.Map{
+		"username":      user.Username,
+		"token":         accessToken,
+		"refresh_token": refreshToken,
+		"session_id":    session.ID,
+	}
+	return check(c, result, "success", true, 200)
+}
+
+func (h *Handler) GetSessions(c *fiber.Ctx) error {
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return check(c, err, err.Error(), false, 401)
+	}
+
+	sessions, err := h.store.Sessions().List(userID, time.Now())
+	if err != nil {
+		return check(c, "", "unable to get sessions", false, 500)
+	}
+
+	type list struct {
+		ID         uint      `json:"id"`
+		Device     string    `json:"device"`
+		Current    bool      `json:"current"`
+		LastUsedAt time.Time `json:"last_used_at"`
+		ExpiresAt  time.Time `json:"expires_at"`
+		CreatedAt  time.Time `json:"created_at"`
+	}
+
+	current := getSessionID(c)
+	allResult := make([]list, 0)
+	for _, item := range sessions {
+		allResult = append(allResult, list{
+			ID:         item.ID,
+			Device:     item.Device,
+			Current:    item.ID == current,
+			LastUsedAt: item.LastUsedAt,
+			ExpiresAt:  item.ExpiresAt,
+			CreatedAt:  item.CreatedAt,
+		})
+	}
+
+	return check(c, allResult, "sessions", true, 200)
+}
+
+// DeleteSession ends one of the user's sessions, its refresh token and access tokens stop working.
+func (h *Handler) DeleteSession(c *fiber.Ctx) error {
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return check(c, err, err.Error(), false, 401)
+	}
+
+	sessionID, err := c.ParamsInt("id")
+	if err != nil || sessionID < 1 {
+		return check(c, "", "id is invalid", false, 400)
+	}
+
+	session, err := h.store.Sessions().Find(uint(sessionID))
+	if err != nil || session.UserID != userID || session.RevokedAt != nil {
+		return check(c, "", "session not found", false, 404)
+	}
+
+	if err := h.revoked.RevokeSession(c.UserContext(), session.ID); err != nil {
+		return check(c, "", "unable to end session, try again later", false, 503)
+	}
+
+	if err := h.store.Sessions().Revoke(session.ID, time.Now()); err != nil {
+		return check(c, "", "unable to end session", false, 500)
+	}
+
+	return check(c, "", "session ended", true, 200)
+}
@@ -0,0 +1,406 @@
+package handlers
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"mvpmatch/audit"
+	"mvpmatch/models"
+	"mvpmatch/repository"
+	"sort"
+	"strings"
+)
+
+// syncProductStock sets amount_available to the fill of every slot carrying the product.
+// Products that are not in any slot keep the stock they were given directly.
+func syncProductStock(tx repository.Store, productID uint) error {
+	slots, err := tx.Slots().LockForProduct(productID)
+	if err != nil || len(slots) == 0 {
+		return err
+	}
+
+	fill := 0
+	for _, slot := range slots {
+		fill = fill + slot.Fill
+	}
+
+	return tx.Products().SetStock(productID, fill)
+}
+
+// takeFromSlots removes units of a product from its slots, from the given slot code only
+// when one is set, otherwise from the fullest slots first.
+// It reports false when the product is not in any slot.
+func takeFromSlots(tx repository.Store, product models.Product, code string, units int) (bool, error) {
+	carrying, err := tx.Slots().LockForProduct(product.ID)
+	if err != nil {
+		return false, err
+	}
+
+	var slots []models.Slot
+	for _, slot := range carrying {
+		if slot.MachineID == product.MachineID && (code == "" || slot.Code == code) {
+			slots = append(slots, slot)
+		}
+	}
+	if len(slots) == 0 {
+		return false, nil
+	}
+	sort.SliceStable(slots, func(i, j int) bool { return slots[i].Fill > slots[j].Fill })
+
+	for _, slot := range slots {
+		if units == 0 {
+			break
+		}
+		take := slot.Fill
+		if take > units {
+			take = units
+		}
+		if take == 0 {
+			continue
+		}
+
+		if err := tx.Slots().SetFill(slot.ID, slot.Fill-take); err != nil {
+			return true, err
+		}
+		units = units - take
+	}
+
+	if units > 0 {
+		return true, requestFailed(400, "Insufficient product quantity, please reduce the amount")
+	}
+
+	return true, syncProductStock(tx, product.ID)
+}
+
+// returnToSlots puts units of a product back into the room left in its slots
+// and reports how many fitted. It reports false when the product is not in any slot.
+func returnToSlots(tx repository.Store, productID uint, units int) (bool, int, error) {
+	slots, err := tx.Slots().LockForProduct(productID)
+	if err != nil {
+		return false, 0, err
+	}
+	if len(slots) == 0 {
+		return false, 0, nil
+	}
+	sort.SliceStable(slots, func(i, j int) bool { return slots[i].Fill < slots[j].Fill })
+
+	returned := 0
+	for _, slot := range slots {
+		room := slot.Capacity - slot.Fill
+		if room > units-returned {
+			room = units - returned
+		}
+		if room <= 0 {
+			continue
+		}
+
+		if err := tx.Slots().SetFill(slot.ID, slot.Fill+room); err != nil {
+			return true, returned, err
+		}
+		returned = returned + room
+	}
+
+	return true, returned, syncProductStock(tx, productID)
+}
+
+type slotOutput struct {
+	Code      string `json:"code"`
+	Capacity  int    `json:"capacity"`
+	Fill      int    `json:"fill"`
+	ProductID uint   `json:"product_id"`
+	Product   string `json:"product"`
+	Cost      int    `json:"cost"`
+}
+
+func toSlotOutput(slot models.Slot) slotOutput {
+	return slotOutput{
+		Code:      slot.Code,
+		Capacity:  slot.Capacity,
+		Fill:      slot.Fill,
+		ProductID: slot.ProductID,
+		Product:   slot.Product.ProductName,
+		Cost:      slot.Product.Cost,
+	}
+}
+
+// getSlot locks a slot of the machine in the route by its :code parameter. The product it
+// carries and any others given are locked first, lowest id first, the order buying and
+// refunding take a product and then its slots in. A slot that changed product before it
+// was locked fails with 409.
+func getSlot(c *fiber.Ctx, tx repository.Store, others ...uint) (models.Slot, error) {
+	machineID, err := getMachineID(c, tx)
+	if err != nil {
+		return models.Slot{}, requestFailed(404, err.Error())
+	}
+
+	code := strings.ToUpper(c.Params("code"))
+	current, err := tx.Slots().Find(machineID, code)
+	if err != nil {
+		return current, requestFailed(404, "slot not found")
+	}
+
+	productIDs := append([]uint{current.ProductID}, others...)
+	sort.Slice(productIDs, func(i, j int) bool { return productIDs[i] < productIDs[j] })
+	var locked uint
+	for _, productID := range productIDs {
+		if productID == 0 || productID == locked {
+			continue
+		}
+		if _, err := tx.Products().Lock(productID); err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return models.Slot{}, err
+		}
+		locked = productID
+	}
+
+	slot, err := tx.Slots().Lock(machineID, code)
+	if err != nil {
+		return slot, requestFailed(404, "slot not found")
+	}
+	if slot.ProductID != current.ProductID {
+		return slot, requestFailed(409, "slot changed while it was being updated, try again")
+	}
+
+	return slot, nil
+}
+
+func (h *Handler) GetSlots(c *fiber.Ctx) error {
+
+	machineID, err := getMachineID(c, h.store)
+	if err != nil {
+		return check(c, "", err.Error(), false, 404)
+	}
+
+	slots, _ := h.store.Slots().List(machineID)
+
+	allResult := make([]slotOutput, 0)
+	for _, item := range slots {
+		allResult = append(allResult, toSlotOutput(item))
+	}
+
+	return check(c, allResult, "slots", true, 200)
+}
+
+type addSlotInput struct {
+	Code     string `json:"code"`
+	Capacity int    `json:"capacity"`
+}
+
+func (s addSlotInput) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Code, validation.Required, validation.Length(1, 8)),
+		validation.Field(&s.Capacity, validation.Required, validation.Min(1)),
+	)
+}
+
+func (h *Handler) AddSlot(c *fiber.Ctx) error {
+
+	var input addSlotInput
+
+	adminID, err := getUserID(c)
+	if err != nil {
+		return check(c, err, err.Error(), false, 401)
+	}
+
+	if err := c.BodyParser(&input); err != nil {
+		return check(c, err, err.Error(), false, 400)
+	}
+
+	if err := input.Validate(); err != nil {
+		return check(c, err, err.Error(), false, 400)
+	}
+
+	machineID, err := getMachineID(c, h.store)
+	if err != nil {
+		return check(c, "", err.Error(), false, 404)
+	}
+
+	slot := models.Slot{
+		MachineID: machineID,
+		Code:      strings.ToUpper(input.Code),
+		Capacity:  input.Capacity,
+	}
+
+	if _, err := h.store.Slots().Find(machineID, slot.Code); err == nil {
+		return check(c, "", "slot code already exists in this machine", false, 400)
+	}
+
+	err = h.store.Transaction(func(tx repository.Store) error {
+		//a code taken since the check above
+		err := tx.Slots().Create(&slot)
+		if errors.Is(err, repository.ErrDuplicate) {
+			return requestFailed(400, "slot code already exists in this machine")
+		}
+		if err != nil {
+			return requestFailed(400, "unable to add slot")
+		}
+
+		return audit.Record(tx, models.AuditLog{
+			ActorID:    adminID,
+			Action:     audit.AddSlot,
+			TargetType: audit.Slot,
+			TargetID:   slot.ID,
+		}, fiber.Map{"machine_id": machineID, "code": slot.Code, "capacity": slot.Capacity})
+	})
+	if err != nil {
+		return checkError(c, err, "unable to add slot")
+	}
+
+	return check(c, toSlotOutput(slot), "slot created successfully", true, 201)
+}
+
+type restockSlotInput struct {
+	Amount int `json:"amount"`
+}
+
+func (s restockSlotInput) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Amount, validation.Required, validation.Min(1)),
+	)
+}
+
+// RestockSlot adds units of the slot's product, up to the slot capacity.
+func (h *Handler) RestockSlot(c *fiber.Ctx) error {
+
+	var input restockSlotInput
+
+	sellerID, err := getUserID(c)
+	if err != nil {
+		return check(c, err, err.Error(), false, 401)
+	}
+
+	if err := c.BodyParser(&input); err != nil {
+		return check(c, err, err.Error(), false, 400)
+	}
+
+	if err := input.Validate(); err != nil {
+		return check(c, err, err.Error(), false, 400)
+	}
+
+	var slot models.Slot
+	err = h.store.Transaction(func(tx repository.Store) error {
+		var err error
+		slot, err = getSlot(c, tx)
+		if err != nil {
+			return err
+		}
+
+		if slot.ProductID == 0 {
+			return requestFailed(400, "assign a product to the slot first")
+		}
+
+		product, err := tx.Products().Find(slot.ProductID)
+		if err != nil || product.SellerID != sellerID {
+			return requestFailed(401, "permission denied!")
+		}
+
+		if slot.Fill+input.Amount > slot.Capacity {
+			return requestFailed(400, "slot cannot hold more than its capacity")
+		}
+
+		if err := tx.Slots().SetFill(slot.ID, slot.Fill+input.Amount); err != nil {
+			return err
+		}
+
+		return syncProductStock(tx, product.ID)
+	})
+	if err != nil {
+		return checkError(c, err, "unable to restock slot")
+	}
+
+	slot, _ = h.store.Slots().Find(slot.MachineID, slot.Code)
+	return check(c, toSlotOutput(slot), "slot restocked successfully", true, 200)
+}
+
+type assignSlotInput struct {
+	ProductID uint `json:"product_id"`
+}
+
+// AssignSlot puts one of the seller's products in an empty slot, along with the stock of a
+// product that was in no slot before. A product id of 0 takes the product out of the slot.
+func (h *Handler) AssignSlot(c *fiber.Ctx) error {
+
+	var input assignSlotInput
+
+	sellerID, err := getUserID(c)
+	if err != nil {
+		return check(c, err, err.Error(), false, 401)
+	}
+
+	if err := c.BodyParser(&input); err != nil {
+		return check(c, err, err.Error(), false, 400)
+	}
+
+	var slot models.Slot
+	err = h.store.Transaction(func(tx repository.Store) error {
+		var err error
+		slot, err = getSlot(c, tx, input.ProductID)
+		if err != nil {
+			return err
+		}
+
+		if slot.ProductID == input.ProductID {
+			return nil
+		}
+
+		//a slot holding another seller's product is not theirs to change
+		if slot.ProductID != 0 {
+			current, err := tx.Products().Find(slot.ProductID)
+			if err != nil || current.SellerID != sellerID {
+				return requestFailed(401, "permission denied!")
+			}
+		}
+
+		if slot.Fill > 0 {
+			return requestFailed(400, "slot must be empty before it is re-assigned")
+		}
+
+		moved := 0
+		if input.ProductID != 0 {
+			product, err := tx.Products().Find(input.ProductID)
+			if err != nil || product.SellerID != sellerID {
+				return requestFailed(400, "product_id is invalid")
+			}
+			if product.MachineID != slot.MachineID {
+				return requestFailed(400, "product is not sold in this machine")
+			}
+
+			//stock kept outside slots moves into the first slot the product gets
+			slotted, err := tx.Slots().CountForProduct(product.ID)
+			if err != nil {
+				return err
+			}
+			if slotted == 0 {
+				if product.AmountAvailable > slot.Capacity {
+					return requestFailed(400, "slot cannot hold the stock the product has")
+				}
+				moved = product.AmountAvailable
+			}
+		}
+
+		previous := slot.ProductID
+		if err := tx.Slots().Assign(slot.ID, input.ProductID); err != nil {
+			return err
+		}
+		if moved > 0 {
+			if err := tx.Slots().SetFill(slot.ID, moved); err != nil {
+				return err
+			}
+		}
+
+		for _, productID := range []uint{input.ProductID, previous} {
+			if productID == 0 {
+				continue
+			}
+			if err := syncProductStock(tx, productID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return checkError(c, err, "unable to assign slot")
+	}
+
+	slot, _ = h.store.Slots().Find(slot.MachineID, slot.Code)
+	return check(c, toSlotOutput(slot), "slot assigned successfully", true, 200)
+}
@@ -1,18 +1,16 @@
 package handlers
 
 import (
-	"context"
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/bcrypt"
-	"gorm.io/gorm/clause"
-	"mvpmatch/config"
-	"mvpmatch/database"
+	"mvpmatch/change"
+	"mvpmatch/inventory"
 	"mvpmatch/models"
-	"strconv"
-	"strings"
+	"mvpmatch/repository"
+	"mvpmatch/tokens"
+	"mvpmatch/wallet"
 	"time"
 )
 
@@ -22,36 +20,39 @@ type addUserInput struct {
 	RoleID   uint   `json:"role_id"`
 }
 
-func (s addUserInput) Validate() error {
+func (s addUserInput) Validate(store repository.Store) error {
 	valid := validation.ValidateStruct(&s,
 		validation.Field(&s.Username, validation.Required),
 		validation.Field(&s.Password, validation.Required),
 		validation.Field(&s.RoleID, validation.Required),
 	)
 
-	db := database.DB
-	rows := db.Where(&models.Role{ID: s.RoleID}).First(&models.Role{})
-	if rows.RowsAffected == 0 {
+	role, err := store.Roles().Find(s.RoleID)
+	if err != nil {
 		return errors.New("role_id is invalid")
 	}
 
-	rows = db.Where(&models.User{Username: s.Username}).First(&models.User{})
-	if rows.RowsAffected == 1 {
+	//the other roles are handed out from the command line
+	if !role.SelfAssignable {
+		return errors.New("role_id cannot be chosen at sign-up")
+	}
+
+	if _, err := store.Users().FindByUsername(s.Username); err == nil {
 		return errors.New("username is not available, use another!")
 	}
 
 	return valid
 }
-func AddUser(c *fiber.Ctx) error {
+func (h *Handler) AddUser(c *fiber.Ctx) error {
 
 	var input addUserInput
-	db := database.DB
+	users := h.store.Users()
 
 	if err := c.BodyParser(&input); err != nil {
 		return check(c, err, err.Error(), false, 400)
 	}
 
-	if err := input.Validate(); err != nil {
+	if err := input.Validate(h.store); err != nil {
 		return check(c, err, err.Error(), false, 400)
 	}
 
@@ -67,12 +68,15 @@ func AddUser(c *fiber.Ctx) error {
 		RoleID:   input.RoleID,
 	}
 
-	rows := db.Create(&user)
-	if rows.RowsAffected == 0 {
+	if err := users.Create(&user); err != nil {
+		//a name taken since validation, or held by a deleted account
+		if errors.Is(err, repository.ErrDuplicate) {
+			return check(c, "", "username is not available, use another!", false, 400)
+		}
 		return check(c, "", "Unable to create user", false, 400)
 	}
 
-	db.Where("id = ?", user.ID).Preload(clause.Associations).First(&user)
+	user, _ = users.Find(user.ID)
 
 	output := fiber.Map{
 		"user_id":  user.ID,
@@ -81,13 +85,10 @@ func AddUser(c *fiber.Ctx) error {
 	}
 	return check(c, output, "user created successfully", true, 201)
 }
-func GetUsers(c *fiber.Ctx) error {
+func (h *Handler) GetUsers(c *fiber.Ctx) error {
 
-	db := database.DB
-
-	var users []models.User
-	rows := db.Preload(clause.Associations).Find(&users)
-	if rows.RowsAffected == 0 {
+	users, err := h.store.Users().List()
+	if err != nil || len(users) == 0 {
 		empty := make([]string, 0)
 		return check(c, empty, "no records found", true, 200)
 	}
@@ -122,10 +123,10 @@ func (s editUserInput) Validate() error {
 		validation.Field(&s.Username, validation.Required),
 	)
 }
-func EditUser(c *fiber.Ctx) error {
+func (h *Handler) EditUser(c *fiber.Ctx) error {
 
 	var input editUserInput
-	db := database.DB
+	users := h.store.Users()
 
 	userID, err := getUserID(c)
 	if err != nil {
@@ -140,34 +141,31 @@ func EditUser(c *fiber.Ctx) error {
 		return check(c, err, err.Error(), false, 400)
 	}
 
-	var user models.User
-	rows := db.Where(&models.User{Username: input.Username}).First(&user)
-	if rows.RowsAffected == 1 {
+	user, err := users.FindByUsername(input.Username)
+	if err == nil {
 		if user.ID != userID {
 			return check(c, "", "username is not available, use another!", false, 400)
 		}
 	}
 
-	updateUser := db.Model(&models.User{})
-	updateUser.Where(&models.User{ID: userID})
-
+	password := ""
 	if input.Password != "" {
 		//create password hash
 		passwordHash, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
 		if err != nil {
 			return check(c, "", "Unable to encrypt password", false, 500)
 		}
-
-		rows = updateUser.Updates(models.User{Password: string(passwordHash), Username: input.Username})
-	} else {
-		rows = updateUser.Update("username", input.Username)
+		password = string(passwordHash)
 	}
 
-	if rows.RowsAffected == 0 {
+	if err := users.UpdateProfile(userID, input.Username, password); err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			return check(c, "", "username is not available, use another!", false, 400)
+		}
 		return check(c, "", "unable to update user", false, 400)
 	}
 
-	db.Where("id = ?", userID).Preload(clause.Associations).First(&user)
+	user, _ = users.Find(userID)
 
 	output := fiber.Map{
 		"user_id":  user.ID,
@@ -177,73 +175,106 @@ func EditUser(c *fiber.Ctx) error {
 	return check(c, output, "user edited successfully", true, 200)
 }
 
-func ResetDeposit(c *fiber.Ctx) error {
+func (h *Handler) ResetDeposit(c *fiber.Ctx) error {
 
 	//var input editUserInput
-	db := database.DB
 
 	userID, err := getUserID(c)
 	if err != nil {
 		return check(c, err, err.Error(), false, 401)
 	}
 
-	//update user deposit
-	updateUser := db.Model(&models.User{})
-	updateUser.Where(&models.User{ID: userID})
-	rows := updateUser.Update("deposit", 0)
-	if rows.RowsAffected == 0 {
-		return check(c, "", "unable to reset deposit", false, 400)
+	//pay the deposit back in coins and clear it through the wallet ledger
+	dispensed := make([]int, 0)
+	err = h.store.Transaction(func(tx repository.Store) error {
+		user, err := tx.Users().Lock(userID)
+		if err != nil {
+			return requestFailed(400, "unable to reset deposit")
+		}
+		if user.Deposit == 0 {
+			return nil
+		}
+
+		//coins can only come back out of the machine holding the deposit
+		if c.Params("machine") != "" {
+			machineID, err := getMachineID(c, tx)
+			if err != nil {
+				return requestFailed(404, err.Error())
+			}
+			if err := checkDepositMachine(user, machineID); err != nil {
+				return err
+			}
+		}
+
+		movement := models.CoinMovement{Kind: inventory.Change, MachineID: user.DepositMachineID, UserID: userID}
+		breakdown, err := inventory.PayOut(tx, movement, user.Deposit)
+		if errors.Is(err, change.ErrCannotMakeChange) {
+			return requestFailed(400, "unable to reset deposit, "+err.Error())
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = wallet.Post(tx, models.Wallet{
+			UserID: userID,
+			Debit:  user.Deposit,
+			Source: wallet.Reset,
+		})
+		if err != nil {
+			return err
+		}
+
+		dispensed = change.Coins(breakdown)
+		return nil
+	})
+	if err != nil {
+		return checkError(c, err, "unable to reset deposit")
 	}
 
-	var user models.User
-	db.Where("id = ?", userID).Preload(clause.Associations).First(&user)
+	user, _ := h.store.Users().Find(userID)
 
 	output := fiber.Map{
 		"username": user.Username,
 		"role":     user.Role.Name,
 		"deposit":  user.Deposit,
+		"change":   dispensed,
 	}
 	return check(c, output, "user deposit reset successful", true, 200)
 }
-func DeleteUser(c *fiber.Ctx) error {
-
-	db := database.DB
+func (h *Handler) DeleteUser(c *fiber.Ctx) error {
 
 	userID, err := getUserID(c)
 	if err != nil {
 		return check(c, err, err.Error(), false, 400)
 	}
 
-	row := db.Delete(&models.User{ID: userID})
-	if row.RowsAffected == 0 {
+	if err := h.store.Users().Delete(userID); err != nil {
 		return check(c, "", "unable to delete user", false, 400)
 	}
 
 	return check(c, "", "user deleted successfully!", true, 200)
 }
 
-func GetRole(c *fiber.Ctx) error {
-
-	db := database.DB
+func (h *Handler) GetRole(c *fiber.Ctx) error {
 
-	var roles []models.Role
-
-	rows := db.Find(&roles)
-	if rows.RowsAffected == 0 {
+	roles, err := h.store.Roles().List()
+	if err != nil || len(roles) == 0 {
 		empty := make([]string, 0)
 		return check(c, empty, "no record found", false, 400)
 	}
 
 	type list struct {
-		ID   uint   `json:"id"`
-		Name string `json:"name"`
+		ID             uint   `json:"id"`
+		Name           string `json:"name"`
+		SelfAssignable bool   `json:"self_assignable"`
 	}
 
 	var allResult []list
 	for _, item := range roles {
 		result := list{
-			ID:   item.ID,
-			Name: item.Name,
+			ID:             item.ID,
+			Name:           item.Name,
+			SelfAssignable: item.SelfAssignable,
 		}
 
 		allResult = append(allResult, result)
@@ -255,37 +286,33 @@ func GetRole(c *fiber.Ctx) error {
 type loginBody struct {
 	Username string `json:"username" validate:"required"`
 	Password string `json:"password" validate:"required"`
+	Device   string `json:"device"`
 }
 
-func (s loginBody) Validate() error {
+func (s loginBody) Validate(store repository.Store) error {
 	var data error
 	data = validation.ValidateStruct(&s,
 		validation.Field(&s.Username, validation.Required),
 		validation.Field(&s.Password, validation.Required),
 	)
-	db := database.DB
-	rows := db.Where("username = ?", s.Username).First(&models.User{})
-	if rows.RowsAffected == 0 {
+	if _, err := store.Users().FindByUsername(s.Username); err != nil {
 		return errors.New("Username is invalid")
 	}
 	return data
 }
 
-func Login(c *fiber.Ctx) error {
+func (h *Handler) Login(c *fiber.Ctx) error {
 
 	var input loginBody
 	if err := c.BodyParser(&input); err != nil {
 		return check(c, err, err.Error(), false, 400)
 	}
 
-	if err := input.Validate(); err != nil {
+	if err := input.Validate(h.store); err != nil {
 		return check(c, err, err.Error(), false, 400)
 	}
 
-	db := database.DB
-	user := models.User{}
-
-	db.Where("username = ?", input.Username).Preload(clause.Associations).First(&user)
+	user, _ := h.store.Users().FindByUsername(input.Username)
 
 	//user has been verified
 	hash := []byte(user.Password)
@@ -294,100 +321,85 @@ func Login(c *fiber.Ctx) error {
 		return check(c, "", "Unable to login, credentials wrong", false, 401)
 	}
 
-	// Create token
-	token := jwt.New(jwt.SigningMethodHS256)
+	if user.SuspendedAt != nil {
+		return check(c, "", "account suspended, contact support", false, 403)
+	}
 
-	// Set claims
-	claims := token.Claims.(jwt.MapClaims)
-	claims["uid"] = user.ID
-	claims["rid"] = user.RoleID
-	claims["name"] = user.Username
-	claims["role"] = user.Role.Name
-	claims["exp"] = time.Now().Add(time.Hour * 1).Unix()
+	//every login is a session of its own, refreshed without the password
+	device := input.Device
+	if device == "" {
+		device = c.Get(fiber.HeaderUserAgent)
+	}
+	session, refreshToken, err := startSession(h.store, user.ID, device)
+	if err != nil {
+		return check(c, "", "Unable to start session", false, 500)
+	}
 
 	// Generate encoded token and send it as response.
-	tokenString, err := token.SignedString([]byte(config.App.JWTKey))
+	tokenString, err := tokens.Sign(user, session.ID)
 	if err != nil {
 		return check(c, "", "Unable to generate token", false, 500)
 	}
 
 	//compose return message struct
 	result := fiber.Map{
-		"username": user.Username,
-		"token":    tokenString,
+		"username":      user.Username,
+		"token":         tokenString,
+		"refresh_token": refreshToken,
+		"session_id":    session.ID,
 	}
 	return check(c, result, "success", true, 200)
 }
-func Logintest(c *fiber.Ctx) error {
 
-	var input loginBody
-	if err := c.BodyParser(&input); err != nil {
-		return check(c, err, err.Error(), false, 400)
-	}
+// Logout revokes the token it is called with until it expires and ends its session.
+// With ?everywhere=true it revokes every token and session of the user, on any device.
+func (h *Handler) Logout(c *fiber.Ctx) error {
 
-	if err := input.Validate(); err != nil {
-		return check(c, err, err.Error(), false, 400)
+	userID, err := getUserID(c)
+	if err != nil {
+		return check(c, err, err.Error(), false, 401)
 	}
 
-	db := database.DB
-	user := models.User{}
-
-	db.Where("username = ?", input.Username).Preload(clause.Associations).First(&user)
-
-	//user has been verified
-	hash := []byte(user.Password)
-	if err := bcrypt.CompareHashAndPassword(hash, []byte(input.Password)); err != nil {
-		return check(c, "", "Unable to login, credentials wrong", false, 401)
+	tokenID := getTokenID(c)
+	if tokenID == "" {
+		return check(c, "", "action invalid", false, 400)
 	}
 
-	// Create token
-	token := jwt.New(jwt.SigningMethodHS256)
-
-	// Set claims
-	claims := token.Claims.(jwt.MapClaims)
-	claims["uid"] = user.ID
-	claims["rid"] = user.RoleID
-	claims["name"] = user.Username
-	claims["role"] = user.Role.Name
-	claims["exp"] = time.Now().Add(time.Hour * 100).Unix()
-
-	// Generate encoded token and send it as response.
-	tokenString, err := token.SignedString([]byte(config.App.JWTKey))
+	exp, err := getExpKey(c)
 	if err != nil {
-		return check(c, "", "Unable to generate token", false, 500)
-	}
-
-	//compose return message struct
-	result := fiber.Map{
-		"username": user.Username,
-		"token":    tokenString,
+		return check(c, "", "action invalid", false, 400)
 	}
-	return check(c, result, "success", true, 200)
-}
 
-func Logout(c *fiber.Ctx) error {
+	everywhere := c.Query("everywhere") == "true"
+	now := time.Now()
 
-	tokenHeader := strings.Split(c.Get("Authorization"), " ")
-	if len(tokenHeader) != 2 {
-		return check(c, "", "action invalid", false, 400)
+	//a token that cannot be revoked stays valid, so the logout has to fail
+	ctx := c.UserContext()
+	if everywhere {
+		err = h.revoked.RevokeBefore(ctx, userID, now)
+	} else {
+		err = h.revoked.Revoke(ctx, tokenID, time.Unix(exp, 0))
+		//the other access tokens of the session, such as ones refreshed earlier, go with it
+		if sessionID := getSessionID(c); err == nil && sessionID > 0 {
+			err = h.revoked.RevokeSession(ctx, sessionID)
+		}
 	}
-	token := tokenHeader[1]
-
-	userID, err := getUserID(c)
 	if err != nil {
-		return check(c, err, err.Error(), false, 401)
+		return check(c, "", "unable to log out, try again later", false, 503)
 	}
 
-	client, err := database.ConnectRedis()
+	//the refresh tokens go with the sessions
+	if everywhere {
+		err = h.store.Sessions().RevokeAll(userID, now)
+	} else if sessionID := getSessionID(c); sessionID > 0 {
+		err = h.store.Sessions().Revoke(sessionID, now)
+		if errors.Is(err, repository.ErrNotFound) {
+			err = nil
+		}
+	}
 	if err != nil {
-		return check(c, err, err.Error(), false, 400)
+		return check(c, "", "unable to end session", false, 500)
 	}
-	defer client.Close()
-
-	ctx := context.TODO()
-	userIDKey := strconv.Itoa(int(userID))
-
-	client.Set(ctx, userIDKey, token, 0)
 
 	return check(c, "", "success", true, 200)
 }
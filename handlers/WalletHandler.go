@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"mvpmatch/models"
+	"mvpmatch/repository"
+	"time"
+)
+
+type walletEntry struct {
+	ID          uint      `json:"id"`
+	Type        string    `json:"type"`
+	Amount      int       `json:"amount"`
+	Balance     int       `json:"balance"`
+	Source      string    `json:"source"`
+	ReferenceID uint      `json:"reference_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func toWalletEntry(item models.Wallet) walletEntry {
+	entry := walletEntry{
+		ID:          item.ID,
+		Type:        "credit",
+		Amount:      item.Credit,
+		Balance:     item.Balance,
+		Source:      item.Source,
+		ReferenceID: item.ReferenceID,
+		CreatedAt:   item.CreatedAt,
+	}
+	if item.Debit > 0 {
+		entry.Type = "debit"
+		entry.Amount = item.Debit
+	}
+	return entry
+}
+
+func (h *Handler) GetWallet(c *fiber.Ctx) error {
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return check(c, err, err.Error(), false, 401)
+	}
+
+	cursor, limit, err := getPage(c)
+	if err != nil {
+		return check(c, "", err.Error(), false, 400)
+	}
+
+	from, to, err := getDateRange(c)
+	if err != nil {
+		return check(c, "", err.Error(), false, 400)
+	}
+
+	filter := repository.WalletFilter{
+		UserID: userID,
+		Type:   c.Query("type"),
+		Cursor: cursor,
+		From:   from,
+		To:     to,
+		Limit:  limit,
+	}
+	if filter.Type != "" && filter.Type != "credit" && filter.Type != "debit" {
+		return check(c, "", "type must be credit or debit", false, 400)
+	}
+
+	entries, err := h.store.Wallets().List(filter)
+	if err != nil {
+		return check(c, "", "unable to get wallet", false, 500)
+	}
+
+	allResult := make([]walletEntry, 0)
+	for _, item := range entries {
+		allResult = append(allResult, toWalletEntry(item))
+	}
+
+	var nextCursor uint
+	if len(entries) == limit {
+		nextCursor = entries[len(entries)-1].ID
+	}
+
+	output := fiber.Map{
+		"entries":     allResult,
+		"next_cursor": nextCursor,
+	}
+	return check(c, output, "wallet", true, 200)
+}
+
+func (h *Handler) GetWalletEntry(c *fiber.Ctx) error {
+
+	userID, err := getUserID(c)
+	if err != nil {
+		return check(c, err, err.Error(), false, 401)
+	}
+
+	entryID, err := c.ParamsInt("id")
+	if err != nil || entryID < 1 {
+		return check(c, "", "id is invalid", false, 400)
+	}
+
+	entry, err := h.store.Wallets().Find(uint(entryID))
+	if err != nil || entry.UserID != userID {
+		return check(c, "", "wallet entry not found", false, 404)
+	}
+
+	return check(c, toWalletEntry(entry), "wallet entry", true, 200)
+}
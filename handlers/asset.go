@@ -5,7 +5,9 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/pkg/errors"
+	"mvpmatch/tokens"
 	"strconv"
+	"time"
 )
 
 func getUserID(c *fiber.Ctx) (uint, error) {
@@ -42,6 +44,26 @@ func getExpKey(c *fiber.Ctx) (int64, error) {
 	return int64(intData), nil
 }
 
+func getTokenID(c *fiber.Ctx) string {
+	if c.Locals("user") == nil {
+		return ""
+	}
+	user := c.Locals("user").(*jwt.Token)
+	claims := user.Claims.(jwt.MapClaims)
+
+	return tokens.ID(claims)
+}
+
+func getSessionID(c *fiber.Ctx) uint {
+	if c.Locals("user") == nil {
+		return 0
+	}
+	user := c.Locals("user").(*jwt.Token)
+	claims := user.Claims.(jwt.MapClaims)
+
+	return tokens.SessionID(claims)
+}
+
 func getRole(c *fiber.Ctx) string {
 	user := c.Locals("user").(*jwt.Token)
 	claims := user.Claims.(jwt.MapClaims)
@@ -94,6 +116,78 @@ func check(c *fiber.Ctx, data interface{}, message string, status bool, code int
 	return c.Status(code).JSON(response)
 }
 
+// requestError is returned from inside a transaction to roll it back
+// and report the message to the client with the given status code.
+type requestError struct {
+	code    int
+	message string
+}
+
+func (e requestError) Error() string {
+	return e.message
+}
+
+func requestFailed(code int, message string) error {
+	return requestError{code: code, message: message}
+}
+
+// checkError reports a requestError as is and hides any other error behind message.
+func checkError(c *fiber.Ctx, err error, message string) error {
+	var failed requestError
+	if errors.As(err, &failed) {
+		return check(c, "", failed.message, false, failed.code)
+	}
+	return check(c, "", message, false, 500)
+}
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// getPage reads the cursor and limit query parameters used by list endpoints.
+// The cursor is the id of the last record on the previous page, 0 starts from the newest record.
+func getPage(c *fiber.Ctx) (uint, int, error) {
+	cursor, err := strconv.ParseUint(c.Query("cursor", "0"), 10, 64)
+	if err != nil {
+		return 0, 0, errors.New("cursor is invalid")
+	}
+
+	limit, err := strconv.Atoi(c.Query("limit", strconv.Itoa(defaultPageSize)))
+	if err != nil || limit < 1 {
+		return 0, 0, errors.New("limit is invalid")
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	return uint(cursor), limit, nil
+}
+
+// getDateRange reads the from and to query parameters as YYYY-MM-DD dates,
+// to is inclusive so the range covers the whole of that day.
+func getDateRange(c *fiber.Ctx) (time.Time, time.Time, error) {
+	var from, to time.Time
+	var err error
+
+	if value := c.Query("from"); value != "" {
+		from, err = time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			return from, to, errors.New("from must be a date like 2006-01-02")
+		}
+	}
+
+	if value := c.Query("to"); value != "" {
+		to, err = time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			return from, to, errors.New("to must be a date like 2006-01-02")
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+
+	return from, to, nil
+}
+
 func contain(s []int, e int) bool {
 	for _, a := range s {
 		if a == e {
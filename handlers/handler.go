@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"mvpmatch/repository"
+	"mvpmatch/tokens"
+)
+
+// Handler serves every route against the repositories of its store,
+// logged out tokens go to its revocation list.
+type Handler struct {
+	store   repository.Store
+	revoked tokens.Revocations
+}
+
+func New(store repository.Store, revoked tokens.Revocations) *Handler {
+	return &Handler{store: store, revoked: revoked}
+}
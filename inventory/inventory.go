@@ -0,0 +1,158 @@
+package inventory
+
+import (
+	"github.com/pkg/errors"
+	"mvpmatch/change"
+	"mvpmatch/models"
+	"mvpmatch/repository"
+	"sort"
+)
+
+// Kinds of coin movement recorded in the ledger.
+const (
+	Deposit    = "deposit"
+	Change     = "change"
+	Restock    = "restock"
+	Withdrawal = "withdrawal"
+	Adjustment = "adjustment"
+	//Opening entries make the ledger add up to the coins counted before it existed
+	Opening = "opening"
+)
+
+// DefaultDenominations are the coins a new machine accepts.
+var DefaultDenominations = []int{5, 10, 20, 50, 100}
+
+// ErrInsufficientCoins is returned when a delta would take a denomination below zero.
+var ErrInsufficientCoins = errors.New("not enough coins in the machine")
+
+// Apply adds the signed per-denomination deltas to the coin inventory of the
+// template's machine and records each one as a movement copied from the template.
+// It should run inside the caller's transaction so a failure leaves no partial update.
+func Apply(tx repository.Store, movement models.CoinMovement, deltas map[int]int) error {
+
+	if movement.Kind == "" {
+		return errors.New("coin movement kind is required")
+	}
+	if movement.MachineID == 0 {
+		return errors.New("coin movement machine is required")
+	}
+
+	//largest first like Coins().Lock, so every transaction locks coin rows in one order
+	var denominations []int
+	for denomination := range deltas {
+		denominations = append(denominations, denomination)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(denominations)))
+
+	coins := tx.Coins()
+	for _, denomination := range denominations {
+		delta := deltas[denomination]
+		if delta == 0 {
+			continue
+		}
+		if denomination <= 0 {
+			return errors.New("invalid coin denomination")
+		}
+
+		if delta > 0 {
+			//creates the row for the first coin of a denomination
+			if err := coins.Add(movement.MachineID, denomination, delta); err != nil {
+				return err
+			}
+		} else {
+			applied, err := coins.Increment(movement.MachineID, denomination, delta)
+			if err != nil {
+				return err
+			}
+			if !applied {
+				return ErrInsufficientCoins
+			}
+		}
+
+		entry := movement
+		entry.ID = 0
+		entry.Denomination = denomination
+		entry.Delta = delta
+		if err := coins.Record(&entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Rebuild recomputes every coin count from the movement history and returns the coins
+// of every machine after. It should run inside the caller's transaction.
+func Rebuild(tx repository.Store) ([]models.Coin, error) {
+
+	totals, err := tx.Coins().Totals()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, total := range totals {
+		if total.Count < 0 {
+			return nil, errors.Errorf("machine %d paid out more %d coins than it took in", total.MachineID, total.Denomination)
+		}
+	}
+
+	held, err := tx.Coins().LockAll()
+	if err != nil {
+		return nil, err
+	}
+
+	//denominations without history hold no coins
+	for _, coin := range held {
+		if _, err := tx.Coins().SetCount(coin.MachineID, coin.Denomination, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, total := range totals {
+		applied, err := tx.Coins().SetCount(total.MachineID, total.Denomination, total.Count)
+		if err != nil {
+			return nil, err
+		}
+		if !applied {
+			coin := models.Coin{MachineID: total.MachineID, Denomination: total.Denomination, Count: total.Count}
+			if err := tx.Coins().Create(&coin); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return tx.Coins().LockAll()
+}
+
+// PayOut takes the fewest accepted coins that add up to amount out of the inventory of
+// the template's machine and returns them keyed by denomination. The coin rows stay locked
+// until the caller's transaction ends, and change.ErrCannotMakeChange is returned when no
+// exact combination exists.
+func PayOut(tx repository.Store, movement models.CoinMovement, amount int) (map[int]int, error) {
+
+	accepted, err := tx.Coins().Denominations(movement.MachineID)
+	if err != nil {
+		return nil, err
+	}
+
+	//coins of a retired denomination are kept but never paid out
+	availableCoins, err := tx.Coins().Lock(movement.MachineID, accepted)
+	if err != nil {
+		return nil, err
+	}
+
+	breakdown, err := change.Make(amount, availableCoins)
+	if err != nil {
+		return nil, err
+	}
+
+	deltas := make(map[int]int)
+	for denomination, count := range breakdown {
+		deltas[denomination] = -count
+	}
+	if err := Apply(tx, movement, deltas); err != nil {
+		return nil, err
+	}
+
+	return breakdown, nil
+}
@@ -1,35 +1,18 @@
 package main
 
 import (
-	"github.com/gofiber/fiber/v2"
 	"log"
-	"mvpmatch/database"
-	"mvpmatch/routes"
+	"mvpmatch/cli"
+	"mvpmatch/config"
 	"os"
 )
 
 func main() {
 
-	//get root directory
-	path, err := os.Getwd()
-	if err != nil {
+	//stop before anything starts when the configuration is incomplete
+	if err := config.Load(config.File()); err != nil {
 		log.Fatalln(err)
 	}
-	resourcesPath := path + "/" + "resources"
 
-	app := fiber.New(fiber.Config{})
-
-	//start database
-	database.Start()
-	database.Migrate()
-
-	routes.Routes(app)
-
-	app.Static("/", resourcesPath)
-
-	port := "3000"
-
-	if err := app.Listen(":" + port); err != nil {
-		panic(err)
-	}
+	os.Exit(cli.Run(os.Args[1:]))
 }
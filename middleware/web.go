@@ -1,47 +1,55 @@
 package middleware
 
 import (
-	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
+	jwtware "github.com/gofiber/jwt/v2"
 	"github.com/golang-jwt/jwt/v4"
-	"github.com/pkg/errors"
-	"mvpmatch/config"
-	"mvpmatch/database"
-	"strconv"
-	"strings"
+	"log"
+	"mvpmatch/repository"
+	"mvpmatch/tokens"
 )
 
-func Seller(c *fiber.Ctx) error {
-
-	if c.Locals("user") == nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"message": "Token access required", "status": false})
-	}
-	user := c.Locals("user").(*jwt.Token)
-	claims := user.Claims.(jwt.MapClaims)
+// Policies for a token check that cannot reach the revocation list.
+const (
+	FailOpen   = "open"
+	FailClosed = "closed"
+)
 
-	blacklist, err := checkBlacklist(c, claims)
-	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"message": "Token logged out, Please login afresh", "status": false})
-	}
+// JWT accepts tokens signed by any key of the set, the keys it replaced included,
+// and hands them to success when it is set.
+func JWT(keys *tokens.KeySet, success fiber.Handler) fiber.Handler {
+	return jwtware.New(jwtware.Config{
+		SigningKeys:    keys.VerifyKeys(),
+		SigningMethod:  keys.Algorithm(),
+		SuccessHandler: success,
+		ErrorHandler: func(c *fiber.Ctx, err error) error {
+			if err.Error() == "Missing or malformed JWT" {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"message": "Missing or malformed JWT", "status": false})
+			} else {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"message": "Invalid or expired JWT", "status": false})
+			}
+		},
+	})
+}
 
-	if blacklist {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"message": "Token logged out, Please login afresh", "status": false})
-	}
+// Auth checks that tokens have not been logged out and that their role holds
+// the permission a route needs.
+type Auth struct {
+	store    repository.Store
+	revoked  tokens.Revocations
+	failOpen bool
+}
 
-	slug := fmt.Sprintf("%v", claims["role"])
-	if slug == config.Role.Seller {
-		return c.Next()
-	} else {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"message": config.Role.Seller + " access required", "status": false})
-	}
+func NewAuth(store repository.Store, revoked tokens.Revocations, policy string) *Auth {
+	return &Auth{store: store, revoked: revoked, failOpen: policy == FailOpen}
 }
-func Buyer(c *fiber.Ctx) error {
+
+// Active lets the request through when its token has not been logged out,
+// it runs after the JWT middleware accepted the token.
+func (a *Auth) Active(c *fiber.Ctx) error {
 
 	if c.Locals("user") == nil {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
@@ -50,78 +58,48 @@ func Buyer(c *fiber.Ctx) error {
 	user := c.Locals("user").(*jwt.Token)
 	claims := user.Claims.(jwt.MapClaims)
 
-	blacklist, err := checkBlacklist(c, claims)
+	revoked, err := tokens.Revoked(c.UserContext(), a.revoked, claims)
 	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"message": "Token logged out, Please login afresh", "status": false})
+		//the list is unreachable, the policy decides whether the token is trusted meanwhile
+		if !a.failOpen {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"message": "Unable to verify token, try again later", "status": false})
+		}
+		log.Println("auth: revocation list unavailable, letting token through:", err)
 	}
 
-	if blacklist {
+	if revoked {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"message": "Token logged out, Please login afresh", "status": false})
 	}
 
-	slug := fmt.Sprintf("%v", claims["role"])
-	if slug == config.Role.Buyer {
-		return c.Next()
-	} else {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"message": config.Role.Buyer + " access required", "status": false})
-	}
+	return c.Next()
 }
 
-func checkBlacklist(c *fiber.Ctx, claims jwt.MapClaims) (bool, error) {
-
-	client, err := database.ConnectRedis()
-	if err != nil {
-		return false, errors.New("unable to verify token")
-	}
-	defer client.Close()
-
-	stringData := TransToString(claims["uid"])
-	token := client.Get(context.TODO(), stringData)
+// Require lets the request through when the role of the token holds the permission,
+// it is looked up on every request so changes to a role apply to tokens already issued.
+func (a *Auth) Require(permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+
+		if c.Locals("user") == nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"message": "Token access required", "status": false})
+		}
+		user := c.Locals("user").(*jwt.Token)
+		claims := user.Claims.(jwt.MapClaims)
+
+		granted, err := a.store.Permissions().Granted(fmt.Sprintf("%v", claims["role"]), permission)
+		if err != nil {
+			log.Println("auth: unable to look up permissions:", err)
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"message": "Unable to verify permissions, try again later", "status": false})
+		}
+
+		if !granted {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"message": permission + " permission required", "status": false})
+		}
 
-	if token.Val() == "" {
-		return false, nil
-	}
-
-	tokenHeader := strings.Split(c.Get("Authorization"), " ")
-	if len(tokenHeader) != 2 {
-		return true, errors.New("unable to get token")
-	}
-	jwtToken := tokenHeader[1]
-
-	if jwtToken == token.Val() {
-		return true, nil
-	}
-
-	return false, nil
-}
-
-func TransToString(data interface{}) (res string) {
-	switch v := data.(type) {
-	case float64:
-		res = strconv.FormatFloat(data.(float64), 'f', 0, 64)
-	case float32:
-		res = strconv.FormatFloat(float64(data.(float32)), 'f', 6, 32)
-	case int:
-		res = strconv.FormatInt(int64(data.(int)), 10)
-	case int64:
-		res = strconv.FormatInt(data.(int64), 10)
-	case uint:
-		res = strconv.FormatUint(uint64(data.(uint)), 10)
-	case uint64:
-		res = strconv.FormatUint(data.(uint64), 10)
-	case uint32:
-		res = strconv.FormatUint(uint64(data.(uint32)), 10)
-	case json.Number:
-		res = data.(json.Number).String()
-	case string:
-		res = data.(string)
-	case []byte:
-		res = string(v)
-	default:
-		res = ""
+		return c.Next()
 	}
-	return
 }
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// AuditLog records an action an admin took, Details holds what changed as JSON.
+type AuditLog struct {
+	ID         uint `gorm:"primary_key"`
+	ActorID    uint `gorm:"index"`
+	Actor      User
+	Action     string `gorm:"size:64;index"`
+	TargetType string `gorm:"size:32"`
+	TargetID   uint
+	Details    string `gorm:"type:text"`
+	CreatedAt  time.Time
+}
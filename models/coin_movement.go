@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+type CoinMovement struct {
+	ID           uint `gorm:"primary_key"`
+	MachineID    uint
+	Denomination int
+	Delta        int
+	Kind         string
+	UserID       uint
+	OrderID      uint
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
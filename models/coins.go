@@ -6,6 +6,7 @@ import (
 
 type Coin struct {
 	ID           uint `gorm:"primary_key"`
+	MachineID    uint
 	Denomination int
 	Count        int
 	CreatedAt    time.Time
@@ -0,0 +1,13 @@
+package models
+
+import (
+	"time"
+)
+
+type Denomination struct {
+	ID        uint `gorm:"primary_key"`
+	MachineID uint
+	Value     int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
@@ -0,0 +1,13 @@
+package models
+
+import (
+	"time"
+)
+
+type Machine struct {
+	ID        uint `gorm:"primary_key"`
+	Name      string
+	Location  string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
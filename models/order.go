@@ -5,12 +5,22 @@ import (
 )
 
 type Order struct {
-	ID        uint `gorm:"primary_key"`
-	ProductID uint
-	Product   Product
-	UserID    uint
-	User      User
-	Amount    int
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID            uint `gorm:"primary_key"`
+	MachineID     uint
+	ProductID     uint
+	Product       Product
+	UserID        uint
+	User          User
+	Amount        int
+	UnitPrice     int
+	TotalCost     int
+	DepositBefore int
+	DepositAfter  int
+	Change        []OrderChange
+	RefundedUnits int
+	RefundAmount  int
+	RefundReason  string
+	RefundedAt    *time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
 }
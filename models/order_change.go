@@ -0,0 +1,14 @@
+package models
+
+import (
+	"time"
+)
+
+type OrderChange struct {
+	ID           uint `gorm:"primary_key"`
+	OrderID      uint
+	Denomination int
+	Count        int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
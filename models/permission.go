@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+)
+
+// Permission is something a role can be allowed to do, named like product:write.
+type Permission struct {
+	ID          uint   `gorm:"primary_key"`
+	Name        string `gorm:"size:64;uniqueIndex"`
+	Description string
+	CreatedAt   time.Time
+}
+
+// RolePermission grants a permission to every user of a role.
+type RolePermission struct {
+	RoleID       uint `gorm:"primaryKey;autoIncrement:false"`
+	PermissionID uint `gorm:"primaryKey;autoIncrement:false"`
+	CreatedAt    time.Time
+}
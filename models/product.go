@@ -6,6 +6,8 @@ import (
 
 type Product struct {
 	ID              uint `gorm:"primary_key"`
+	MachineID       uint
+	Machine         Machine
 	AmountAvailable int
 	Cost            int
 	ProductName     string
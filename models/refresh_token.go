@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// RefreshToken is one token of a session's rotation, only the hash of the token is kept.
+// UsedAt is set once it is swapped for the next one.
+type RefreshToken struct {
+	ID        uint `gorm:"primary_key"`
+	SessionID uint
+	Session   Session
+	TokenHash string `gorm:"size:64;uniqueIndex"`
+	UsedAt    *time.Time
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
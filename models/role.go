@@ -4,9 +4,11 @@ import (
 	"time"
 )
 
+// Role groups the permissions of its users, only self assignable roles can be picked at sign-up.
 type Role struct {
-	ID        uint `gorm:"primary_key"`
-	Name      string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID             uint `gorm:"primary_key"`
+	Name           string
+	SelfAssignable bool
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
 }
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// Session is one device a user logged in on, it lives as long as its refresh tokens keep rotating.
+type Session struct {
+	ID         uint `gorm:"primary_key"`
+	UserID     uint
+	Device     string
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
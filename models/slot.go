@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+type Slot struct {
+	ID        uint `gorm:"primary_key"`
+	MachineID uint
+	Code      string
+	Capacity  int
+	Fill      int
+	ProductID uint
+	Product   Product
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
@@ -6,13 +6,16 @@ import (
 )
 
 type User struct {
-	ID        uint `gorm:"primary_key"`
-	Username  string
-	Password  string
-	Deposit   int
-	RoleID    uint
-	Role      Role
-	Deleted   gorm.DeletedAt
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID               uint `gorm:"primary_key"`
+	Username         string
+	Password         string
+	Deposit          int
+	DepositMachineID uint
+	RoleID           uint
+	Role             Role
+	SuspendedAt      *time.Time
+	SuspendReason    string
+	Deleted          gorm.DeletedAt
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
 }
@@ -5,12 +5,14 @@ import (
 )
 
 type Wallet struct {
-	ID        uint `gorm:"primary_key"`
-	UserID    uint
-	User      User
-	Debit     int
-	Credit    int
-	Balance   int
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID          uint `gorm:"primary_key"`
+	UserID      uint
+	User        User
+	Debit       int
+	Credit      int
+	Balance     int
+	Source      string
+	ReferenceID uint
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
 }
@@ -0,0 +1,101 @@
+package permissions
+
+import (
+	"mvpmatch/config"
+	"mvpmatch/models"
+	"mvpmatch/repository"
+)
+
+// Permissions a role can be granted, every route behind a token requires one of them
+// or none at all.
+const (
+	ProductWrite      = "product:write"
+	SlotStock         = "slot:stock"
+	SaleRead          = "sale:read"
+	OrderRefund       = "order:refund"
+	CoinDeposit       = "coin:deposit"
+	OrderCreate       = "order:create"
+	OrderRead         = "order:read"
+	WalletRead        = "wallet:read"
+	MachineWrite      = "machine:write"
+	DenominationWrite = "denomination:write"
+	UserModerate      = "user:moderate"
+	ProductModerate   = "product:moderate"
+	OrderModerate     = "order:moderate"
+	CoinAdjust        = "coin:adjust"
+	DepositModerate   = "deposit:moderate"
+	AuditRead         = "audit:read"
+	RoleWrite         = "role:write"
+)
+
+// All lists every permission with what it allows.
+var All = []models.Permission{
+	{Name: ProductWrite, Description: "add, edit and delete own products"},
+	{Name: SlotStock, Description: "assign own products to slots and restock them"},
+	{Name: SaleRead, Description: "read the orders of own products"},
+	{Name: OrderRefund, Description: "refund orders of own products"},
+	{Name: CoinDeposit, Description: "deposit coins into a machine and take the deposit back"},
+	{Name: OrderCreate, Description: "buy products"},
+	{Name: OrderRead, Description: "read own orders and their receipts"},
+	{Name: WalletRead, Description: "read own wallet"},
+	{Name: MachineWrite, Description: "add machines and their slots"},
+	{Name: DenominationWrite, Description: "change the coins a machine accepts"},
+	{Name: UserModerate, Description: "list, suspend and restore users"},
+	{Name: ProductModerate, Description: "edit and remove any product"},
+	{Name: OrderModerate, Description: "refund any order"},
+	{Name: CoinAdjust, Description: "adjust the coins held by a machine"},
+	{Name: DepositModerate, Description: "pay out or clear any deposit"},
+	{Name: AuditRead, Description: "read the audit log"},
+	{Name: RoleWrite, Description: "change the permissions of roles"},
+}
+
+// Defaults maps the name of each seeded role to the permissions it starts with.
+func Defaults() map[string][]string {
+	return map[string][]string{
+		config.Role.Seller: {ProductWrite, SlotStock, SaleRead, OrderRefund},
+		config.Role.Buyer:  {CoinDeposit, OrderCreate, OrderRead, WalletRead},
+		config.Role.Admin: {
+			MachineWrite, DenominationWrite, UserModerate, ProductModerate,
+			OrderModerate, CoinAdjust, DepositModerate, AuditRead, RoleWrite,
+		},
+	}
+}
+
+// Seed adds the permissions the store is missing and grants each one it adds to the
+// roles that start with it. Permissions it already had keep the grants admins gave them.
+func Seed(store repository.Store) error {
+	defaults := Defaults()
+
+	return store.Transaction(func(tx repository.Store) error {
+		for _, item := range All {
+			if _, err := tx.Permissions().FindByName(item.Name); err == nil {
+				continue
+			}
+
+			permission := item
+			if err := tx.Permissions().Create(&permission); err != nil {
+				return err
+			}
+
+			for name, granted := range defaults {
+				role, err := tx.Roles().FindByName(name)
+				if err != nil || !contains(granted, permission.Name) {
+					continue
+				}
+				if err := tx.Permissions().Grant(role.ID, permission.ID); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,665 @@
+package repository
+
+import (
+	"context"
+	"github.com/go-sql-driver/mysql"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"mvpmatch/models"
+	"time"
+)
+
+// Gorm is the Store backed by the database.
+type Gorm struct {
+	db *gorm.DB
+}
+
+func NewGorm(db *gorm.DB) *Gorm {
+	return &Gorm{db: db}
+}
+
+func (g *Gorm) Users() Users             { return gormUsers{g.db} }
+func (g *Gorm) Roles() Roles             { return gormRoles{g.db} }
+func (g *Gorm) Permissions() Permissions { return gormPermissions{g.db} }
+func (g *Gorm) Products() Products       { return gormProducts{g.db} }
+func (g *Gorm) Orders() Orders           { return gormOrders{g.db} }
+func (g *Gorm) Coins() Coins             { return gormCoins{g.db} }
+func (g *Gorm) Wallets() Wallets         { return gormWallets{g.db} }
+func (g *Gorm) Machines() Machines       { return gormMachines{g.db} }
+func (g *Gorm) Slots() Slots             { return gormSlots{g.db} }
+func (g *Gorm) Sessions() Sessions       { return gormSessions{g.db} }
+func (g *Gorm) AuditLogs() AuditLogs     { return gormAuditLogs{g.db} }
+
+func (g *Gorm) Transaction(fn func(tx Store) error) error {
+	return g.db.Transaction(func(tx *gorm.DB) error {
+		return fn(NewGorm(tx))
+	})
+}
+
+func (g *Gorm) Ping(ctx context.Context) error {
+	sqlDB, err := g.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+func locking(db *gorm.DB) *gorm.DB {
+	return db.Clauses(clause.Locking{Strength: "UPDATE"})
+}
+
+// found turns a lookup that matched nothing into ErrNotFound.
+func found(rows *gorm.DB) error {
+	if rows.Error != nil && !errors.Is(rows.Error, gorm.ErrRecordNotFound) {
+		return rows.Error
+	}
+	if rows.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// unique turns a write that broke a unique index into ErrDuplicate.
+func unique(err error) error {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+		return ErrDuplicate
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return ErrDuplicate
+	}
+	return err
+}
+
+type gormUsers struct {
+	db *gorm.DB
+}
+
+func (r gormUsers) Find(id uint) (models.User, error) {
+	var user models.User
+	return user, found(r.db.Preload("Role").Where("id = ?", id).First(&user))
+}
+
+func (r gormUsers) FindByUsername(username string) (models.User, error) {
+	var user models.User
+	return user, found(r.db.Preload("Role").Where("username = ?", username).First(&user))
+}
+
+func (r gormUsers) List() ([]models.User, error) {
+	var users []models.User
+	return users, r.db.Preload("Role").Order("id asc").Find(&users).Error
+}
+
+func (r gormUsers) Lock(id uint) (models.User, error) {
+	var user models.User
+	return user, found(locking(r.db).Where("id = ?", id).First(&user))
+}
+
+func (r gormUsers) Create(user *models.User) error {
+	return unique(r.db.Omit(clause.Associations).Create(user).Error)
+}
+
+func (r gormUsers) UpdateProfile(id uint, username string, password string) error {
+	fields := map[string]interface{}{"username": username}
+	if password != "" {
+		fields["password"] = password
+	}
+	return unique(found(r.db.Model(&models.User{}).Where("id = ?", id).Updates(fields)))
+}
+
+func (r gormUsers) SetDeposit(id uint, deposit int) error {
+	return r.db.Model(&models.User{}).Where("id = ?", id).Update("deposit", deposit).Error
+}
+
+func (r gormUsers) SetDepositMachine(id uint, machineID uint) error {
+	return r.db.Model(&models.User{}).Where("id = ?", id).Update("deposit_machine_id", machineID).Error
+}
+
+func (r gormUsers) Suspend(id uint, at time.Time, reason string) error {
+	fields := map[string]interface{}{"suspended_at": at, "suspend_reason": reason}
+	return found(r.db.Model(&models.User{}).Where("id = ?", id).Updates(fields))
+}
+
+func (r gormUsers) Restore(id uint) error {
+	fields := map[string]interface{}{"suspended_at": nil, "suspend_reason": ""}
+	return found(r.db.Model(&models.User{}).Where("id = ?", id).Updates(fields))
+}
+
+func (r gormUsers) Delete(id uint) error {
+	return found(r.db.Delete(&models.User{ID: id}))
+}
+
+type gormRoles struct {
+	db *gorm.DB
+}
+
+func (r gormRoles) Find(id uint) (models.Role, error) {
+	var role models.Role
+	return role, found(r.db.Where("id = ?", id).First(&role))
+}
+
+func (r gormRoles) FindByName(name string) (models.Role, error) {
+	var role models.Role
+	return role, found(r.db.Where("name = ?", name).First(&role))
+}
+
+func (r gormRoles) List() ([]models.Role, error) {
+	var roles []models.Role
+	return roles, r.db.Order("id asc").Find(&roles).Error
+}
+
+func (r gormRoles) Create(role *models.Role) error {
+	return r.db.Create(role).Error
+}
+
+func (r gormRoles) SetSelfAssignable(id uint, selfAssignable bool) error {
+	return found(r.db.Model(&models.Role{}).Where("id = ?", id).Update("self_assignable", selfAssignable))
+}
+
+type gormPermissions struct {
+	db *gorm.DB
+}
+
+func (r gormPermissions) FindByName(name string) (models.Permission, error) {
+	var permission models.Permission
+	return permission, found(r.db.Where("name = ?", name).First(&permission))
+}
+
+func (r gormPermissions) List() ([]models.Permission, error) {
+	var permissions []models.Permission
+	return permissions, r.db.Order("name asc").Find(&permissions).Error
+}
+
+func (r gormPermissions) Create(permission *models.Permission) error {
+	return unique(r.db.Create(permission).Error)
+}
+
+func (r gormPermissions) ForRole(roleID uint) ([]models.Permission, error) {
+	var permissions []models.Permission
+	return permissions, r.db.
+		Joins("JOIN role_permissions ON role_permissions.permission_id = permissions.id").
+		Where("role_permissions.role_id = ?", roleID).
+		Order("permissions.name asc").
+		Find(&permissions).Error
+}
+
+func (r gormPermissions) Granted(role string, permission string) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.RolePermission{}).
+		Joins("JOIN roles ON roles.id = role_permissions.role_id").
+		Joins("JOIN permissions ON permissions.id = role_permissions.permission_id").
+		Where("roles.name = ? AND permissions.name = ?", role, permission).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (r gormPermissions) Grant(roleID uint, permissionID uint) error {
+	grant := models.RolePermission{RoleID: roleID, PermissionID: permissionID}
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&grant).Error
+}
+
+func (r gormPermissions) SetForRole(roleID uint, permissionIDs []uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("role_id = ?", roleID).Delete(&models.RolePermission{}).Error; err != nil {
+			return err
+		}
+		if len(permissionIDs) == 0 {
+			return nil
+		}
+
+		var grants []models.RolePermission
+		for _, permissionID := range permissionIDs {
+			grants = append(grants, models.RolePermission{RoleID: roleID, PermissionID: permissionID})
+		}
+		return tx.Create(&grants).Error
+	})
+}
+
+type gormProducts struct {
+	db *gorm.DB
+}
+
+func (r gormProducts) Find(id uint) (models.Product, error) {
+	var product models.Product
+	return product, found(r.db.Preload(clause.Associations).Where("id = ?", id).First(&product))
+}
+
+func (r gormProducts) FindByName(name string) (models.Product, error) {
+	var product models.Product
+	return product, found(r.db.Preload(clause.Associations).Where("product_name = ?", name).First(&product))
+}
+
+func (r gormProducts) List(machineID uint) ([]models.Product, error) {
+	query := r.db.Preload(clause.Associations)
+	if machineID != 0 {
+		query = query.Where("machine_id = ?", machineID)
+	}
+
+	var products []models.Product
+	return products, query.Order("id asc").Find(&products).Error
+}
+
+func (r gormProducts) Lock(id uint) (models.Product, error) {
+	var product models.Product
+	return product, found(locking(r.db).Where("id = ?", id).First(&product))
+}
+
+func (r gormProducts) Create(product *models.Product) error {
+	return unique(r.db.Omit(clause.Associations).Create(product).Error)
+}
+
+func (r gormProducts) Update(product models.Product) error {
+	return unique(found(r.db.Model(&models.Product{}).
+		Where("id = ?", product.ID).
+		Updates(map[string]interface{}{
+			"product_name":     product.ProductName,
+			"cost":             product.Cost,
+			"amount_available": product.AmountAvailable,
+		})))
+}
+
+func (r gormProducts) Delete(id uint) error {
+	return found(r.db.Delete(&models.Product{ID: id}))
+}
+
+func (r gormProducts) TakeStock(id uint, units int) (bool, error) {
+	rows := r.db.Model(&models.Product{}).
+		Where("id = ? AND amount_available >= ?", id, units).
+		Update("amount_available", gorm.Expr("amount_available - ?", units))
+	return rows.RowsAffected == 1, rows.Error
+}
+
+func (r gormProducts) AddStock(id uint, units int) error {
+	return r.db.Model(&models.Product{}).
+		Where("id = ?", id).
+		Update("amount_available", gorm.Expr("amount_available + ?", units)).Error
+}
+
+func (r gormProducts) SetStock(id uint, amount int) error {
+	return r.db.Model(&models.Product{}).Where("id = ?", id).Update("amount_available", amount).Error
+}
+
+type gormOrders struct {
+	db *gorm.DB
+}
+
+func (r gormOrders) Find(id uint) (models.Order, error) {
+	var order models.Order
+	rows := r.db.Preload("Product").Preload("User").Preload("Change").
+		Where("id = ?", id).
+		First(&order)
+	return order, found(rows)
+}
+
+func (r gormOrders) List(filter OrderFilter) ([]models.Order, error) {
+	query := r.db.Model(&models.Order{})
+
+	if filter.SellerID != 0 {
+		query = query.Joins("JOIN products ON products.id = orders.product_id").
+			Where("products.seller_id = ?", filter.SellerID)
+	}
+	if filter.UserID != 0 {
+		query = query.Where("orders.user_id = ?", filter.UserID)
+	}
+	if filter.ProductID != 0 {
+		query = query.Where("orders.product_id = ?", filter.ProductID)
+	}
+	if filter.Cursor != 0 {
+		query = query.Where("orders.id < ?", filter.Cursor)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("orders.created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("orders.created_at < ?", filter.To)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var orders []models.Order
+	err := query.Preload("Product").Preload("User").Order("orders.id desc").Find(&orders).Error
+	return orders, err
+}
+
+func (r gormOrders) Lock(id uint) (models.Order, error) {
+	var order models.Order
+	return order, found(locking(r.db).Preload("Change").Where("id = ?", id).First(&order))
+}
+
+func (r gormOrders) Create(order *models.Order) error {
+	return r.db.Omit(clause.Associations).Create(order).Error
+}
+
+func (r gormOrders) AddChange(change []models.OrderChange) error {
+	if len(change) == 0 {
+		return nil
+	}
+	return r.db.Create(&change).Error
+}
+
+func (r gormOrders) Refund(id uint, units int, amount int, reason string, at time.Time) error {
+	return found(r.db.Model(&models.Order{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"refunded_units": gorm.Expr("refunded_units + ?", units),
+			"refund_amount":  gorm.Expr("refund_amount + ?", amount),
+			"refund_reason":  reason,
+			"refunded_at":    at,
+		}))
+}
+
+type gormCoins struct {
+	db *gorm.DB
+}
+
+func (r gormCoins) Denominations(machineID uint) ([]int, error) {
+	values := make([]int, 0)
+	err := r.db.Model(&models.Denomination{}).
+		Where("machine_id = ?", machineID).
+		Order("value asc").
+		Pluck("value", &values).Error
+	return values, err
+}
+
+func (r gormCoins) AddDenomination(machineID uint, value int) error {
+	return unique(r.db.Create(&models.Denomination{MachineID: machineID, Value: value}).Error)
+}
+
+func (r gormCoins) RemoveDenomination(machineID uint, value int) error {
+	return found(r.db.Where("machine_id = ? AND value = ?", machineID, value).Delete(&models.Denomination{}))
+}
+
+func (r gormCoins) Lock(machineID uint, denominations []int) ([]models.Coin, error) {
+	var coins []models.Coin
+	err := locking(r.db).
+		Where("machine_id = ? AND denomination IN ?", machineID, denominations).
+		Order("denomination desc").
+		Find(&coins).Error
+	return coins, err
+}
+
+func (r gormCoins) LockAll() ([]models.Coin, error) {
+	var coins []models.Coin
+	err := locking(r.db).Order("machine_id asc, denomination desc").Find(&coins).Error
+	return coins, err
+}
+
+func (r gormCoins) Add(machineID uint, denomination int, count int) error {
+	//an upsert on the unique machine and denomination, two first deposits cannot both insert
+	coin := models.Coin{MachineID: machineID, Denomination: denomination, Count: count}
+	return r.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "machine_id"}, {Name: "denomination"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"count":      gorm.Expr("count + ?", count),
+			"updated_at": time.Now(),
+		}),
+	}).Create(&coin).Error
+}
+
+func (r gormCoins) Increment(machineID uint, denomination int, delta int) (bool, error) {
+	rows := r.db.Model(&models.Coin{}).
+		Where("machine_id = ? AND denomination = ? AND count + ? >= 0", machineID, denomination, delta).
+		Update("count", gorm.Expr("count + ?", delta))
+	return rows.RowsAffected > 0, rows.Error
+}
+
+func (r gormCoins) Create(coin *models.Coin) error {
+	return unique(r.db.Create(coin).Error)
+}
+
+func (r gormCoins) SetCount(machineID uint, denomination int, count int) (bool, error) {
+	rows := r.db.Model(&models.Coin{}).
+		Where("machine_id = ? AND denomination = ?", machineID, denomination).
+		Update("count", count)
+	return rows.RowsAffected > 0, rows.Error
+}
+
+func (r gormCoins) Record(movement *models.CoinMovement) error {
+	return r.db.Create(movement).Error
+}
+
+func (r gormCoins) Movements(orderID uint, kind string) ([]models.CoinMovement, error) {
+	var movements []models.CoinMovement
+	err := r.db.Where("order_id = ? AND kind = ?", orderID, kind).Order("id asc").Find(&movements).Error
+	return movements, err
+}
+
+func (r gormCoins) Totals() ([]models.Coin, error) {
+	var totals []models.Coin
+	err := r.db.Model(&models.CoinMovement{}).
+		Select("machine_id, denomination, SUM(delta) AS count").
+		Group("machine_id, denomination").
+		Order("machine_id asc, denomination desc").
+		Scan(&totals).Error
+	return totals, err
+}
+
+type gormWallets struct {
+	db *gorm.DB
+}
+
+func (r gormWallets) Find(id uint) (models.Wallet, error) {
+	var entry models.Wallet
+	return entry, found(r.db.Where("id = ?", id).First(&entry))
+}
+
+func (r gormWallets) FindByReference(source string, referenceID uint) (models.Wallet, error) {
+	var entry models.Wallet
+	rows := r.db.Where("source = ? AND reference_id = ?", source, referenceID).Order("id asc").First(&entry)
+	return entry, found(rows)
+}
+
+func (r gormWallets) List(filter WalletFilter) ([]models.Wallet, error) {
+	query := r.db.Where("user_id = ?", filter.UserID)
+
+	switch filter.Type {
+	case "credit":
+		query = query.Where("credit > 0")
+	case "debit":
+		query = query.Where("debit > 0")
+	}
+
+	if filter.Cursor != 0 {
+		query = query.Where("id < ?", filter.Cursor)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var entries []models.Wallet
+	return entries, query.Order("id desc").Find(&entries).Error
+}
+
+func (r gormWallets) Create(entry *models.Wallet) error {
+	return r.db.Omit(clause.Associations).Create(entry).Error
+}
+
+func (r gormWallets) Drifted() ([]Balance, error) {
+	var balances []Balance
+	err := r.db.Model(&models.User{}).
+		Select("users.id AS user_id, users.deposit AS deposit, COALESCE(SUM(wallets.credit - wallets.debit), 0) AS ledger").
+		Joins("LEFT JOIN wallets ON wallets.user_id = users.id").
+		Group("users.id, users.deposit").
+		Having("users.deposit <> COALESCE(SUM(wallets.credit - wallets.debit), 0)").
+		Order("users.id asc").
+		Scan(&balances).Error
+	return balances, err
+}
+
+type gormMachines struct {
+	db *gorm.DB
+}
+
+func (r gormMachines) Find(id uint) (models.Machine, error) {
+	var machine models.Machine
+	return machine, found(r.db.Where("id = ?", id).First(&machine))
+}
+
+func (r gormMachines) Default() (models.Machine, error) {
+	var machine models.Machine
+	return machine, found(r.db.Order("id asc").First(&machine))
+}
+
+func (r gormMachines) List() ([]models.Machine, error) {
+	var machines []models.Machine
+	return machines, r.db.Order("id asc").Find(&machines).Error
+}
+
+func (r gormMachines) Create(machine *models.Machine) error {
+	return r.db.Create(machine).Error
+}
+
+type gormSlots struct {
+	db *gorm.DB
+}
+
+func (r gormSlots) Find(machineID uint, code string) (models.Slot, error) {
+	var slot models.Slot
+	rows := r.db.Preload("Product").
+		Where("machine_id = ? AND code = ?", machineID, code).
+		First(&slot)
+	return slot, found(rows)
+}
+
+func (r gormSlots) List(machineID uint) ([]models.Slot, error) {
+	var slots []models.Slot
+	err := r.db.Preload("Product").Where("machine_id = ?", machineID).Order("code asc").Find(&slots).Error
+	return slots, err
+}
+
+func (r gormSlots) Lock(machineID uint, code string) (models.Slot, error) {
+	var slot models.Slot
+	rows := locking(r.db).
+		Where("machine_id = ? AND code = ?", machineID, code).
+		First(&slot)
+	return slot, found(rows)
+}
+
+func (r gormSlots) LockForProduct(productID uint) ([]models.Slot, error) {
+	var slots []models.Slot
+	err := locking(r.db).Where("product_id = ?", productID).Order("id asc").Find(&slots).Error
+	return slots, err
+}
+
+func (r gormSlots) CountForProduct(productID uint) (int, error) {
+	var count int64
+	err := r.db.Model(&models.Slot{}).Where("product_id = ?", productID).Count(&count).Error
+	return int(count), err
+}
+
+func (r gormSlots) Create(slot *models.Slot) error {
+	return unique(r.db.Omit(clause.Associations).Create(slot).Error)
+}
+
+func (r gormSlots) SetFill(id uint, fill int) error {
+	return r.db.Model(&models.Slot{}).Where("id = ?", id).Update("fill", fill).Error
+}
+
+func (r gormSlots) Assign(id uint, productID uint) error {
+	return r.db.Model(&models.Slot{}).Where("id = ?", id).Update("product_id", productID).Error
+}
+
+func (r gormSlots) Clear(productID uint) error {
+	return r.db.Model(&models.Slot{}).
+		Where("product_id = ?", productID).
+		Updates(map[string]interface{}{"product_id": 0, "fill": 0}).Error
+}
+
+type gormSessions struct {
+	db *gorm.DB
+}
+
+func (r gormSessions) Find(id uint) (models.Session, error) {
+	var session models.Session
+	return session, found(r.db.Where("id = ?", id).First(&session))
+}
+
+func (r gormSessions) List(userID uint, now time.Time) ([]models.Session, error) {
+	var sessions []models.Session
+	err := r.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, now).
+		Order("id desc").Find(&sessions).Error
+	return sessions, err
+}
+
+func (r gormSessions) Create(session *models.Session) error {
+	return r.db.Create(session).Error
+}
+
+func (r gormSessions) Touch(id uint, at time.Time, expires time.Time) error {
+	return found(r.db.Model(&models.Session{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"last_used_at": at, "expires_at": expires}))
+}
+
+func (r gormSessions) Revoke(id uint, at time.Time) error {
+	return found(r.db.Model(&models.Session{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", at))
+}
+
+func (r gormSessions) RevokeAll(userID uint, at time.Time) error {
+	return r.db.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", userID).Update("revoked_at", at).Error
+}
+
+func (r gormSessions) FindToken(hash string) (models.RefreshToken, error) {
+	var token models.RefreshToken
+	return token, found(r.db.Preload("Session").Where("token_hash = ?", hash).First(&token))
+}
+
+func (r gormSessions) CreateToken(token *models.RefreshToken) error {
+	return unique(r.db.Omit(clause.Associations).Create(token).Error)
+}
+
+func (r gormSessions) UseToken(id uint, at time.Time) (bool, error) {
+	//only the first of two refreshes racing with the same token gets to use it
+	rows := r.db.Model(&models.RefreshToken{}).Where("id = ? AND used_at IS NULL", id).Update("used_at", at)
+	return rows.RowsAffected == 1, rows.Error
+}
+
+type gormAuditLogs struct {
+	db *gorm.DB
+}
+
+func (r gormAuditLogs) List(filter AuditFilter) ([]models.AuditLog, error) {
+	//the entries keep naming admins deleted since
+	query := r.db.Preload("Actor", func(db *gorm.DB) *gorm.DB { return db.Unscoped() })
+
+	if filter.ActorID != 0 {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != 0 {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if filter.Cursor != 0 {
+		query = query.Where("id < ?", filter.Cursor)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var entries []models.AuditLog
+	return entries, query.Order("id desc").Find(&entries).Error
+}
+
+func (r gormAuditLogs) Create(entry *models.AuditLog) error {
+	return r.db.Omit(clause.Associations).Create(entry).Error
+}
@@ -0,0 +1,1379 @@
+package repository
+
+import (
+	"context"
+	"mvpmatch/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory is a Store that keeps every record in maps, for tests that run without a database.
+// Transactions run one at a time against a copy of the data that replaces it on success,
+// so everything a transaction reads stays locked until it ends.
+type Memory struct {
+	mu   *sync.Mutex
+	data *memoryData
+	inTx bool
+}
+
+type memoryData struct {
+	lastID        uint
+	users         map[uint]models.User
+	roles         map[uint]models.Role
+	permissions   map[uint]models.Permission
+	grants        map[grantKey]models.RolePermission
+	products      map[uint]models.Product
+	orders        map[uint]models.Order
+	orderChanges  map[uint]models.OrderChange
+	coins         map[uint]models.Coin
+	movements     map[uint]models.CoinMovement
+	denominations map[uint]models.Denomination
+	wallets       map[uint]models.Wallet
+	machines      map[uint]models.Machine
+	slots         map[uint]models.Slot
+	sessions      map[uint]models.Session
+	refreshTokens map[uint]models.RefreshToken
+	auditLogs     map[uint]models.AuditLog
+}
+
+// grantKey identifies a role permission, which has no id of its own.
+type grantKey struct {
+	roleID       uint
+	permissionID uint
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		mu: &sync.Mutex{},
+		data: &memoryData{
+			users:         map[uint]models.User{},
+			roles:         map[uint]models.Role{},
+			permissions:   map[uint]models.Permission{},
+			grants:        map[grantKey]models.RolePermission{},
+			products:      map[uint]models.Product{},
+			orders:        map[uint]models.Order{},
+			orderChanges:  map[uint]models.OrderChange{},
+			coins:         map[uint]models.Coin{},
+			movements:     map[uint]models.CoinMovement{},
+			denominations: map[uint]models.Denomination{},
+			wallets:       map[uint]models.Wallet{},
+			machines:      map[uint]models.Machine{},
+			slots:         map[uint]models.Slot{},
+			sessions:      map[uint]models.Session{},
+			refreshTokens: map[uint]models.RefreshToken{},
+			auditLogs:     map[uint]models.AuditLog{},
+		},
+	}
+}
+
+func (d *memoryData) clone() *memoryData {
+	copied := &memoryData{
+		lastID:        d.lastID,
+		users:         map[uint]models.User{},
+		roles:         map[uint]models.Role{},
+		permissions:   map[uint]models.Permission{},
+		grants:        map[grantKey]models.RolePermission{},
+		products:      map[uint]models.Product{},
+		orders:        map[uint]models.Order{},
+		orderChanges:  map[uint]models.OrderChange{},
+		coins:         map[uint]models.Coin{},
+		movements:     map[uint]models.CoinMovement{},
+		denominations: map[uint]models.Denomination{},
+		wallets:       map[uint]models.Wallet{},
+		machines:      map[uint]models.Machine{},
+		slots:         map[uint]models.Slot{},
+		sessions:      map[uint]models.Session{},
+		refreshTokens: map[uint]models.RefreshToken{},
+		auditLogs:     map[uint]models.AuditLog{},
+	}
+	for id, item := range d.users {
+		copied.users[id] = item
+	}
+	for id, item := range d.roles {
+		copied.roles[id] = item
+	}
+	for id, item := range d.permissions {
+		copied.permissions[id] = item
+	}
+	for key, item := range d.grants {
+		copied.grants[key] = item
+	}
+	for id, item := range d.products {
+		copied.products[id] = item
+	}
+	for id, item := range d.orders {
+		copied.orders[id] = item
+	}
+	for id, item := range d.orderChanges {
+		copied.orderChanges[id] = item
+	}
+	for id, item := range d.coins {
+		copied.coins[id] = item
+	}
+	for id, item := range d.movements {
+		copied.movements[id] = item
+	}
+	for id, item := range d.denominations {
+		copied.denominations[id] = item
+	}
+	for id, item := range d.wallets {
+		copied.wallets[id] = item
+	}
+	for id, item := range d.machines {
+		copied.machines[id] = item
+	}
+	for id, item := range d.slots {
+		copied.slots[id] = item
+	}
+	for id, item := range d.sessions {
+		copied.sessions[id] = item
+	}
+	for id, item := range d.refreshTokens {
+		copied.refreshTokens[id] = item
+	}
+	for id, item := range d.auditLogs {
+		copied.auditLogs[id] = item
+	}
+	return copied
+}
+
+// nextID hands out ids from one sequence shared by every table, so they only ever grow.
+func (d *memoryData) nextID() uint {
+	d.lastID++
+	return d.lastID
+}
+
+// lock guards a single call made outside a transaction, a transaction already holds the lock.
+func (m *Memory) lock() func() {
+	if m.inTx {
+		return func() {}
+	}
+	m.mu.Lock()
+	return m.mu.Unlock
+}
+
+func (m *Memory) Users() Users             { return memoryUsers{m} }
+func (m *Memory) Roles() Roles             { return memoryRoles{m} }
+func (m *Memory) Permissions() Permissions { return memoryPermissions{m} }
+func (m *Memory) Products() Products       { return memoryProducts{m} }
+func (m *Memory) Orders() Orders           { return memoryOrders{m} }
+func (m *Memory) Coins() Coins             { return memoryCoins{m} }
+func (m *Memory) Wallets() Wallets         { return memoryWallets{m} }
+func (m *Memory) Machines() Machines       { return memoryMachines{m} }
+func (m *Memory) Slots() Slots             { return memorySlots{m} }
+func (m *Memory) Sessions() Sessions       { return memorySessions{m} }
+func (m *Memory) AuditLogs() AuditLogs     { return memoryAuditLogs{m} }
+
+func (m *Memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *Memory) Transaction(fn func(tx Store) error) error {
+	if m.inTx {
+		return fn(m)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx := &Memory{mu: m.mu, data: m.data.clone(), inTx: true}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	m.data = tx.data
+	return nil
+}
+
+func sortedIDs(ids []uint) []uint {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+//the loaders below fill in the associations the gorm store preloads
+
+func (d *memoryData) user(id uint) (models.User, bool) {
+	user, ok := d.users[id]
+	if ok {
+		user.Role = d.roles[user.RoleID]
+	}
+	return user, ok
+}
+
+func (d *memoryData) product(id uint) (models.Product, bool) {
+	product, ok := d.products[id]
+	if ok {
+		product.Seller = d.users[product.SellerID]
+		product.Machine = d.machines[product.MachineID]
+	}
+	return product, ok
+}
+
+func (d *memoryData) order(id uint) (models.Order, bool) {
+	order, ok := d.orders[id]
+	if !ok {
+		return order, false
+	}
+	order.Product = d.products[order.ProductID]
+	order.User = d.users[order.UserID]
+
+	var changeIDs []uint
+	for changeID, item := range d.orderChanges {
+		if item.OrderID == id {
+			changeIDs = append(changeIDs, changeID)
+		}
+	}
+	for _, changeID := range sortedIDs(changeIDs) {
+		order.Change = append(order.Change, d.orderChanges[changeID])
+	}
+	return order, true
+}
+
+func (d *memoryData) slot(id uint) models.Slot {
+	slot := d.slots[id]
+	slot.Product = d.products[slot.ProductID]
+	return slot
+}
+
+type memoryUsers struct {
+	m *Memory
+}
+
+func (r memoryUsers) Find(id uint) (models.User, error) {
+	defer r.m.lock()()
+	user, ok := r.m.data.user(id)
+	if !ok {
+		return user, ErrNotFound
+	}
+	return user, nil
+}
+
+func (r memoryUsers) FindByUsername(username string) (models.User, error) {
+	defer r.m.lock()()
+	for id, item := range r.m.data.users {
+		if item.Username == username {
+			user, _ := r.m.data.user(id)
+			return user, nil
+		}
+	}
+	return models.User{}, ErrNotFound
+}
+
+func (r memoryUsers) List() ([]models.User, error) {
+	defer r.m.lock()()
+	var ids []uint
+	for id := range r.m.data.users {
+		ids = append(ids, id)
+	}
+
+	var users []models.User
+	for _, id := range sortedIDs(ids) {
+		user, _ := r.m.data.user(id)
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func (r memoryUsers) Lock(id uint) (models.User, error) {
+	defer r.m.lock()()
+	user, ok := r.m.data.users[id]
+	if !ok {
+		return user, ErrNotFound
+	}
+	return user, nil
+}
+
+func (r memoryUsers) Create(user *models.User) error {
+	defer r.m.lock()()
+	if r.taken(0, user.Username) {
+		return ErrDuplicate
+	}
+	user.ID = r.m.data.nextID()
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+
+	stored := *user
+	stored.Role = models.Role{}
+	r.m.data.users[user.ID] = stored
+	return nil
+}
+
+func (r memoryUsers) UpdateProfile(id uint, username string, password string) error {
+	defer r.m.lock()()
+	user, ok := r.m.data.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if r.taken(id, username) {
+		return ErrDuplicate
+	}
+	user.Username = username
+	if password != "" {
+		user.Password = password
+	}
+	user.UpdatedAt = time.Now()
+	r.m.data.users[id] = user
+	return nil
+}
+
+// taken mirrors the unique index on usernames, ignoring the user being updated.
+func (r memoryUsers) taken(id uint, username string) bool {
+	for _, user := range r.m.data.users {
+		if user.ID != id && user.Username == username {
+			return true
+		}
+	}
+	return false
+}
+
+func (r memoryUsers) SetDeposit(id uint, deposit int) error {
+	defer r.m.lock()()
+	user, ok := r.m.data.users[id]
+	if ok {
+		user.Deposit = deposit
+		r.m.data.users[id] = user
+	}
+	return nil
+}
+
+func (r memoryUsers) SetDepositMachine(id uint, machineID uint) error {
+	defer r.m.lock()()
+	user, ok := r.m.data.users[id]
+	if ok {
+		user.DepositMachineID = machineID
+		r.m.data.users[id] = user
+	}
+	return nil
+}
+
+func (r memoryUsers) Suspend(id uint, at time.Time, reason string) error {
+	defer r.m.lock()()
+	user, ok := r.m.data.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.SuspendedAt = &at
+	user.SuspendReason = reason
+	r.m.data.users[id] = user
+	return nil
+}
+
+func (r memoryUsers) Restore(id uint) error {
+	defer r.m.lock()()
+	user, ok := r.m.data.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.SuspendedAt = nil
+	user.SuspendReason = ""
+	r.m.data.users[id] = user
+	return nil
+}
+
+func (r memoryUsers) Delete(id uint) error {
+	defer r.m.lock()()
+	if _, ok := r.m.data.users[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.m.data.users, id)
+	return nil
+}
+
+type memoryRoles struct {
+	m *Memory
+}
+
+func (r memoryRoles) Find(id uint) (models.Role, error) {
+	defer r.m.lock()()
+	role, ok := r.m.data.roles[id]
+	if !ok {
+		return role, ErrNotFound
+	}
+	return role, nil
+}
+
+func (r memoryRoles) FindByName(name string) (models.Role, error) {
+	defer r.m.lock()()
+	for _, role := range r.m.data.roles {
+		if role.Name == name {
+			return role, nil
+		}
+	}
+	return models.Role{}, ErrNotFound
+}
+
+func (r memoryRoles) List() ([]models.Role, error) {
+	defer r.m.lock()()
+	var ids []uint
+	for id := range r.m.data.roles {
+		ids = append(ids, id)
+	}
+
+	var roles []models.Role
+	for _, id := range sortedIDs(ids) {
+		roles = append(roles, r.m.data.roles[id])
+	}
+	return roles, nil
+}
+
+func (r memoryRoles) Create(role *models.Role) error {
+	defer r.m.lock()()
+	role.ID = r.m.data.nextID()
+	role.CreatedAt = time.Now()
+	role.UpdatedAt = role.CreatedAt
+	r.m.data.roles[role.ID] = *role
+	return nil
+}
+
+func (r memoryRoles) SetSelfAssignable(id uint, selfAssignable bool) error {
+	defer r.m.lock()()
+	role, ok := r.m.data.roles[id]
+	if !ok {
+		return ErrNotFound
+	}
+	role.SelfAssignable = selfAssignable
+	role.UpdatedAt = time.Now()
+	r.m.data.roles[id] = role
+	return nil
+}
+
+type memoryPermissions struct {
+	m *Memory
+}
+
+func (r memoryPermissions) FindByName(name string) (models.Permission, error) {
+	defer r.m.lock()()
+	for _, permission := range r.m.data.permissions {
+		if permission.Name == name {
+			return permission, nil
+		}
+	}
+	return models.Permission{}, ErrNotFound
+}
+
+func (r memoryPermissions) List() ([]models.Permission, error) {
+	defer r.m.lock()()
+	var permissions []models.Permission
+	for _, permission := range r.m.data.permissions {
+		permissions = append(permissions, permission)
+	}
+	return sortPermissions(permissions), nil
+}
+
+func (r memoryPermissions) Create(permission *models.Permission) error {
+	defer r.m.lock()()
+	for _, existing := range r.m.data.permissions {
+		if existing.Name == permission.Name {
+			return ErrDuplicate
+		}
+	}
+	permission.ID = r.m.data.nextID()
+	permission.CreatedAt = time.Now()
+	r.m.data.permissions[permission.ID] = *permission
+	return nil
+}
+
+func (r memoryPermissions) ForRole(roleID uint) ([]models.Permission, error) {
+	defer r.m.lock()()
+	var permissions []models.Permission
+	for key := range r.m.data.grants {
+		if key.roleID == roleID {
+			permissions = append(permissions, r.m.data.permissions[key.permissionID])
+		}
+	}
+	return sortPermissions(permissions), nil
+}
+
+func (r memoryPermissions) Granted(role string, permission string) (bool, error) {
+	defer r.m.lock()()
+	for key := range r.m.data.grants {
+		if r.m.data.roles[key.roleID].Name == role && r.m.data.permissions[key.permissionID].Name == permission {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r memoryPermissions) Grant(roleID uint, permissionID uint) error {
+	defer r.m.lock()()
+	key := grantKey{roleID: roleID, permissionID: permissionID}
+	if _, ok := r.m.data.grants[key]; ok {
+		return nil
+	}
+	r.m.data.grants[key] = models.RolePermission{RoleID: roleID, PermissionID: permissionID, CreatedAt: time.Now()}
+	return nil
+}
+
+func (r memoryPermissions) SetForRole(roleID uint, permissionIDs []uint) error {
+	defer r.m.lock()()
+	kept := map[grantKey]models.RolePermission{}
+	for _, permissionID := range permissionIDs {
+		key := grantKey{roleID: roleID, permissionID: permissionID}
+		grant, ok := r.m.data.grants[key]
+		if !ok {
+			grant = models.RolePermission{RoleID: roleID, PermissionID: permissionID, CreatedAt: time.Now()}
+		}
+		kept[key] = grant
+	}
+	for key := range r.m.data.grants {
+		if key.roleID == roleID {
+			delete(r.m.data.grants, key)
+		}
+	}
+	for key, grant := range kept {
+		r.m.data.grants[key] = grant
+	}
+	return nil
+}
+
+func sortPermissions(permissions []models.Permission) []models.Permission {
+	sort.Slice(permissions, func(i, j int) bool { return permissions[i].Name < permissions[j].Name })
+	return permissions
+}
+
+type memoryProducts struct {
+	m *Memory
+}
+
+func (r memoryProducts) Find(id uint) (models.Product, error) {
+	defer r.m.lock()()
+	product, ok := r.m.data.product(id)
+	if !ok {
+		return product, ErrNotFound
+	}
+	return product, nil
+}
+
+func (r memoryProducts) FindByName(name string) (models.Product, error) {
+	defer r.m.lock()()
+	for id, item := range r.m.data.products {
+		if item.ProductName == name {
+			product, _ := r.m.data.product(id)
+			return product, nil
+		}
+	}
+	return models.Product{}, ErrNotFound
+}
+
+func (r memoryProducts) List(machineID uint) ([]models.Product, error) {
+	defer r.m.lock()()
+	var ids []uint
+	for id, item := range r.m.data.products {
+		if machineID == 0 || item.MachineID == machineID {
+			ids = append(ids, id)
+		}
+	}
+
+	var products []models.Product
+	for _, id := range sortedIDs(ids) {
+		product, _ := r.m.data.product(id)
+		products = append(products, product)
+	}
+	return products, nil
+}
+
+func (r memoryProducts) Lock(id uint) (models.Product, error) {
+	defer r.m.lock()()
+	product, ok := r.m.data.products[id]
+	if !ok {
+		return product, ErrNotFound
+	}
+	return product, nil
+}
+
+func (r memoryProducts) Create(product *models.Product) error {
+	defer r.m.lock()()
+	if r.taken(0, product.ProductName) {
+		return ErrDuplicate
+	}
+	product.ID = r.m.data.nextID()
+	product.CreatedAt = time.Now()
+	product.UpdatedAt = product.CreatedAt
+
+	stored := *product
+	stored.Seller = models.User{}
+	stored.Machine = models.Machine{}
+	r.m.data.products[product.ID] = stored
+	return nil
+}
+
+func (r memoryProducts) Update(product models.Product) error {
+	defer r.m.lock()()
+	stored, ok := r.m.data.products[product.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if r.taken(product.ID, product.ProductName) {
+		return ErrDuplicate
+	}
+	stored.ProductName = product.ProductName
+	stored.Cost = product.Cost
+	stored.AmountAvailable = product.AmountAvailable
+	stored.UpdatedAt = time.Now()
+	r.m.data.products[product.ID] = stored
+	return nil
+}
+
+// taken mirrors the unique index on product names, ignoring the product being updated.
+func (r memoryProducts) taken(id uint, name string) bool {
+	for _, product := range r.m.data.products {
+		if product.ID != id && product.ProductName == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (r memoryProducts) Delete(id uint) error {
+	defer r.m.lock()()
+	if _, ok := r.m.data.products[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.m.data.products, id)
+	return nil
+}
+
+func (r memoryProducts) TakeStock(id uint, units int) (bool, error) {
+	defer r.m.lock()()
+	product, ok := r.m.data.products[id]
+	if !ok || product.AmountAvailable < units {
+		return false, nil
+	}
+	product.AmountAvailable = product.AmountAvailable - units
+	r.m.data.products[id] = product
+	return true, nil
+}
+
+func (r memoryProducts) AddStock(id uint, units int) error {
+	defer r.m.lock()()
+	product, ok := r.m.data.products[id]
+	if ok {
+		product.AmountAvailable = product.AmountAvailable + units
+		r.m.data.products[id] = product
+	}
+	return nil
+}
+
+func (r memoryProducts) SetStock(id uint, amount int) error {
+	defer r.m.lock()()
+	product, ok := r.m.data.products[id]
+	if ok {
+		product.AmountAvailable = amount
+		r.m.data.products[id] = product
+	}
+	return nil
+}
+
+type memoryOrders struct {
+	m *Memory
+}
+
+func (r memoryOrders) Find(id uint) (models.Order, error) {
+	defer r.m.lock()()
+	order, ok := r.m.data.order(id)
+	if !ok {
+		return order, ErrNotFound
+	}
+	return order, nil
+}
+
+func (r memoryOrders) List(filter OrderFilter) ([]models.Order, error) {
+	defer r.m.lock()()
+	var ids []uint
+	for id, item := range r.m.data.orders {
+		if filter.SellerID != 0 && r.m.data.products[item.ProductID].SellerID != filter.SellerID {
+			continue
+		}
+		if filter.UserID != 0 && item.UserID != filter.UserID {
+			continue
+		}
+		if filter.ProductID != 0 && item.ProductID != filter.ProductID {
+			continue
+		}
+		if filter.Cursor != 0 && id >= filter.Cursor {
+			continue
+		}
+		if !filter.From.IsZero() && item.CreatedAt.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !item.CreatedAt.Before(filter.To) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] > ids[j] })
+	if filter.Limit > 0 && len(ids) > filter.Limit {
+		ids = ids[:filter.Limit]
+	}
+
+	var orders []models.Order
+	for _, id := range ids {
+		order, _ := r.m.data.order(id)
+		order.Change = nil
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
+
+func (r memoryOrders) Lock(id uint) (models.Order, error) {
+	defer r.m.lock()()
+	order, ok := r.m.data.order(id)
+	if !ok {
+		return order, ErrNotFound
+	}
+	order.Product = models.Product{}
+	order.User = models.User{}
+	return order, nil
+}
+
+func (r memoryOrders) Create(order *models.Order) error {
+	defer r.m.lock()()
+	order.ID = r.m.data.nextID()
+	order.CreatedAt = time.Now()
+	order.UpdatedAt = order.CreatedAt
+
+	stored := *order
+	stored.Product = models.Product{}
+	stored.User = models.User{}
+	stored.Change = nil
+	r.m.data.orders[order.ID] = stored
+	return nil
+}
+
+func (r memoryOrders) AddChange(change []models.OrderChange) error {
+	defer r.m.lock()()
+	for i := range change {
+		change[i].ID = r.m.data.nextID()
+		change[i].CreatedAt = time.Now()
+		change[i].UpdatedAt = change[i].CreatedAt
+		r.m.data.orderChanges[change[i].ID] = change[i]
+	}
+	return nil
+}
+
+func (r memoryOrders) Refund(id uint, units int, amount int, reason string, at time.Time) error {
+	defer r.m.lock()()
+	order, ok := r.m.data.orders[id]
+	if !ok {
+		return ErrNotFound
+	}
+	order.RefundedUnits = order.RefundedUnits + units
+	order.RefundAmount = order.RefundAmount + amount
+	order.RefundReason = reason
+	order.RefundedAt = &at
+	r.m.data.orders[id] = order
+	return nil
+}
+
+type memoryCoins struct {
+	m *Memory
+}
+
+func (r memoryCoins) Denominations(machineID uint) ([]int, error) {
+	defer r.m.lock()()
+	values := make([]int, 0)
+	for _, item := range r.m.data.denominations {
+		if item.MachineID == machineID {
+			values = append(values, item.Value)
+		}
+	}
+	sort.Ints(values)
+	return values, nil
+}
+
+func (r memoryCoins) AddDenomination(machineID uint, value int) error {
+	defer r.m.lock()()
+	for _, item := range r.m.data.denominations {
+		if item.MachineID == machineID && item.Value == value {
+			return ErrDuplicate
+		}
+	}
+	id := r.m.data.nextID()
+	r.m.data.denominations[id] = models.Denomination{
+		ID:        id,
+		MachineID: machineID,
+		Value:     value,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	return nil
+}
+
+func (r memoryCoins) RemoveDenomination(machineID uint, value int) error {
+	defer r.m.lock()()
+	removed := false
+	for id, item := range r.m.data.denominations {
+		if item.MachineID == machineID && item.Value == value {
+			delete(r.m.data.denominations, id)
+			removed = true
+		}
+	}
+	if !removed {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r memoryCoins) find(machineID uint, denomination int) (models.Coin, bool) {
+	for _, item := range r.m.data.coins {
+		if item.MachineID == machineID && item.Denomination == denomination {
+			return item, true
+		}
+	}
+	return models.Coin{}, false
+}
+
+func (r memoryCoins) Lock(machineID uint, denominations []int) ([]models.Coin, error) {
+	defer r.m.lock()()
+	var coins []models.Coin
+	for _, item := range r.m.data.coins {
+		if item.MachineID != machineID {
+			continue
+		}
+		for _, denomination := range denominations {
+			if item.Denomination == denomination {
+				coins = append(coins, item)
+				break
+			}
+		}
+	}
+	sort.Slice(coins, func(i, j int) bool { return coins[i].Denomination > coins[j].Denomination })
+	return coins, nil
+}
+
+func (r memoryCoins) LockAll() ([]models.Coin, error) {
+	defer r.m.lock()()
+	var coins []models.Coin
+	for _, item := range r.m.data.coins {
+		coins = append(coins, item)
+	}
+	sortCoins(coins)
+	return coins, nil
+}
+
+func (r memoryCoins) Increment(machineID uint, denomination int, delta int) (bool, error) {
+	defer r.m.lock()()
+	coin, ok := r.find(machineID, denomination)
+	if !ok || coin.Count+delta < 0 {
+		return false, nil
+	}
+	coin.Count = coin.Count + delta
+	r.m.data.coins[coin.ID] = coin
+	return true, nil
+}
+
+func (r memoryCoins) Add(machineID uint, denomination int, count int) error {
+	defer r.m.lock()()
+	coin, ok := r.find(machineID, denomination)
+	if !ok {
+		coin = models.Coin{ID: r.m.data.nextID(), MachineID: machineID, Denomination: denomination, CreatedAt: time.Now()}
+	}
+	coin.Count = coin.Count + count
+	coin.UpdatedAt = time.Now()
+	r.m.data.coins[coin.ID] = coin
+	return nil
+}
+
+func (r memoryCoins) Create(coin *models.Coin) error {
+	defer r.m.lock()()
+	if _, ok := r.find(coin.MachineID, coin.Denomination); ok {
+		return ErrDuplicate
+	}
+	coin.ID = r.m.data.nextID()
+	coin.CreatedAt = time.Now()
+	coin.UpdatedAt = coin.CreatedAt
+	r.m.data.coins[coin.ID] = *coin
+	return nil
+}
+
+func (r memoryCoins) SetCount(machineID uint, denomination int, count int) (bool, error) {
+	defer r.m.lock()()
+	coin, ok := r.find(machineID, denomination)
+	if !ok {
+		return false, nil
+	}
+	coin.Count = count
+	coin.UpdatedAt = time.Now()
+	r.m.data.coins[coin.ID] = coin
+	return true, nil
+}
+
+func (r memoryCoins) Record(movement *models.CoinMovement) error {
+	defer r.m.lock()()
+	movement.ID = r.m.data.nextID()
+	movement.CreatedAt = time.Now()
+	movement.UpdatedAt = movement.CreatedAt
+	r.m.data.movements[movement.ID] = *movement
+	return nil
+}
+
+func (r memoryCoins) Movements(orderID uint, kind string) ([]models.CoinMovement, error) {
+	defer r.m.lock()()
+	var ids []uint
+	for id, item := range r.m.data.movements {
+		if item.OrderID == orderID && item.Kind == kind {
+			ids = append(ids, id)
+		}
+	}
+
+	var movements []models.CoinMovement
+	for _, id := range sortedIDs(ids) {
+		movements = append(movements, r.m.data.movements[id])
+	}
+	return movements, nil
+}
+
+func (r memoryCoins) Totals() ([]models.Coin, error) {
+	defer r.m.lock()()
+	type key struct {
+		machineID    uint
+		denomination int
+	}
+	sums := map[key]int{}
+	for _, item := range r.m.data.movements {
+		sums[key{item.MachineID, item.Denomination}] += item.Delta
+	}
+
+	var totals []models.Coin
+	for k, count := range sums {
+		totals = append(totals, models.Coin{MachineID: k.machineID, Denomination: k.denomination, Count: count})
+	}
+	sortCoins(totals)
+	return totals, nil
+}
+
+func sortCoins(coins []models.Coin) {
+	sort.Slice(coins, func(i, j int) bool {
+		if coins[i].MachineID != coins[j].MachineID {
+			return coins[i].MachineID < coins[j].MachineID
+		}
+		return coins[i].Denomination > coins[j].Denomination
+	})
+}
+
+type memoryWallets struct {
+	m *Memory
+}
+
+func (r memoryWallets) Find(id uint) (models.Wallet, error) {
+	defer r.m.lock()()
+	entry, ok := r.m.data.wallets[id]
+	if !ok {
+		return entry, ErrNotFound
+	}
+	return entry, nil
+}
+
+func (r memoryWallets) FindByReference(source string, referenceID uint) (models.Wallet, error) {
+	defer r.m.lock()()
+	var ids []uint
+	for id, item := range r.m.data.wallets {
+		if item.Source == source && item.ReferenceID == referenceID {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return models.Wallet{}, ErrNotFound
+	}
+	return r.m.data.wallets[sortedIDs(ids)[0]], nil
+}
+
+func (r memoryWallets) List(filter WalletFilter) ([]models.Wallet, error) {
+	defer r.m.lock()()
+	var ids []uint
+	for id, item := range r.m.data.wallets {
+		if item.UserID != filter.UserID {
+			continue
+		}
+		if filter.Type == "credit" && item.Credit <= 0 {
+			continue
+		}
+		if filter.Type == "debit" && item.Debit <= 0 {
+			continue
+		}
+		if filter.Cursor != 0 && id >= filter.Cursor {
+			continue
+		}
+		if !filter.From.IsZero() && item.CreatedAt.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !item.CreatedAt.Before(filter.To) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] > ids[j] })
+	if filter.Limit > 0 && len(ids) > filter.Limit {
+		ids = ids[:filter.Limit]
+	}
+
+	var entries []models.Wallet
+	for _, id := range ids {
+		entries = append(entries, r.m.data.wallets[id])
+	}
+	return entries, nil
+}
+
+func (r memoryWallets) Create(entry *models.Wallet) error {
+	defer r.m.lock()()
+	entry.ID = r.m.data.nextID()
+	entry.CreatedAt = time.Now()
+	entry.UpdatedAt = entry.CreatedAt
+
+	stored := *entry
+	stored.User = models.User{}
+	r.m.data.wallets[entry.ID] = stored
+	return nil
+}
+
+func (r memoryWallets) Drifted() ([]Balance, error) {
+	defer r.m.lock()()
+	ledgers := map[uint]int{}
+	for _, item := range r.m.data.wallets {
+		ledgers[item.UserID] += item.Credit - item.Debit
+	}
+
+	var ids []uint
+	for id := range r.m.data.users {
+		ids = append(ids, id)
+	}
+
+	var balances []Balance
+	for _, id := range sortedIDs(ids) {
+		user := r.m.data.users[id]
+		if user.Deposit != ledgers[id] {
+			balances = append(balances, Balance{UserID: id, Deposit: user.Deposit, Ledger: ledgers[id]})
+		}
+	}
+	return balances, nil
+}
+
+type memoryMachines struct {
+	m *Memory
+}
+
+func (r memoryMachines) Find(id uint) (models.Machine, error) {
+	defer r.m.lock()()
+	machine, ok := r.m.data.machines[id]
+	if !ok {
+		return machine, ErrNotFound
+	}
+	return machine, nil
+}
+
+func (r memoryMachines) Default() (models.Machine, error) {
+	defer r.m.lock()()
+	var ids []uint
+	for id := range r.m.data.machines {
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return models.Machine{}, ErrNotFound
+	}
+	return r.m.data.machines[sortedIDs(ids)[0]], nil
+}
+
+func (r memoryMachines) List() ([]models.Machine, error) {
+	defer r.m.lock()()
+	var ids []uint
+	for id := range r.m.data.machines {
+		ids = append(ids, id)
+	}
+
+	var machines []models.Machine
+	for _, id := range sortedIDs(ids) {
+		machines = append(machines, r.m.data.machines[id])
+	}
+	return machines, nil
+}
+
+func (r memoryMachines) Create(machine *models.Machine) error {
+	defer r.m.lock()()
+	machine.ID = r.m.data.nextID()
+	machine.CreatedAt = time.Now()
+	machine.UpdatedAt = machine.CreatedAt
+	r.m.data.machines[machine.ID] = *machine
+	return nil
+}
+
+type memorySlots struct {
+	m *Memory
+}
+
+func (r memorySlots) find(machineID uint, code string) (uint, bool) {
+	for id, item := range r.m.data.slots {
+		if item.MachineID == machineID && item.Code == code {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+func (r memorySlots) Find(machineID uint, code string) (models.Slot, error) {
+	defer r.m.lock()()
+	id, ok := r.find(machineID, code)
+	if !ok {
+		return models.Slot{}, ErrNotFound
+	}
+	return r.m.data.slot(id), nil
+}
+
+func (r memorySlots) List(machineID uint) ([]models.Slot, error) {
+	defer r.m.lock()()
+	var slots []models.Slot
+	for id, item := range r.m.data.slots {
+		if item.MachineID == machineID {
+			slots = append(slots, r.m.data.slot(id))
+		}
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i].Code < slots[j].Code })
+	return slots, nil
+}
+
+func (r memorySlots) Lock(machineID uint, code string) (models.Slot, error) {
+	defer r.m.lock()()
+	id, ok := r.find(machineID, code)
+	if !ok {
+		return models.Slot{}, ErrNotFound
+	}
+	return r.m.data.slots[id], nil
+}
+
+func (r memorySlots) LockForProduct(productID uint) ([]models.Slot, error) {
+	defer r.m.lock()()
+	var ids []uint
+	for id, item := range r.m.data.slots {
+		if item.ProductID == productID {
+			ids = append(ids, id)
+		}
+	}
+
+	var slots []models.Slot
+	for _, id := range sortedIDs(ids) {
+		slots = append(slots, r.m.data.slots[id])
+	}
+	return slots, nil
+}
+
+func (r memorySlots) CountForProduct(productID uint) (int, error) {
+	defer r.m.lock()()
+	count := 0
+	for _, item := range r.m.data.slots {
+		if item.ProductID == productID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r memorySlots) Create(slot *models.Slot) error {
+	defer r.m.lock()()
+	if _, ok := r.find(slot.MachineID, slot.Code); ok {
+		return ErrDuplicate
+	}
+	slot.ID = r.m.data.nextID()
+	slot.CreatedAt = time.Now()
+	slot.UpdatedAt = slot.CreatedAt
+
+	stored := *slot
+	stored.Product = models.Product{}
+	r.m.data.slots[slot.ID] = stored
+	return nil
+}
+
+func (r memorySlots) SetFill(id uint, fill int) error {
+	defer r.m.lock()()
+	slot, ok := r.m.data.slots[id]
+	if ok {
+		slot.Fill = fill
+		r.m.data.slots[id] = slot
+	}
+	return nil
+}
+
+func (r memorySlots) Assign(id uint, productID uint) error {
+	defer r.m.lock()()
+	slot, ok := r.m.data.slots[id]
+	if ok {
+		slot.ProductID = productID
+		r.m.data.slots[id] = slot
+	}
+	return nil
+}
+
+func (r memorySlots) Clear(productID uint) error {
+	defer r.m.lock()()
+	for id, item := range r.m.data.slots {
+		if item.ProductID == productID {
+			item.ProductID = 0
+			item.Fill = 0
+			r.m.data.slots[id] = item
+		}
+	}
+	return nil
+}
+
+type memorySessions struct {
+	m *Memory
+}
+
+func (r memorySessions) Find(id uint) (models.Session, error) {
+	defer r.m.lock()()
+	session, ok := r.m.data.sessions[id]
+	if !ok {
+		return session, ErrNotFound
+	}
+	return session, nil
+}
+
+func (r memorySessions) List(userID uint, now time.Time) ([]models.Session, error) {
+	defer r.m.lock()()
+	var sessions []models.Session
+	for _, item := range r.m.data.sessions {
+		if item.UserID == userID && item.RevokedAt == nil && item.ExpiresAt.After(now) {
+			sessions = append(sessions, item)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID > sessions[j].ID })
+	return sessions, nil
+}
+
+func (r memorySessions) Create(session *models.Session) error {
+	defer r.m.lock()()
+	session.ID = r.m.data.nextID()
+	session.CreatedAt = time.Now()
+	session.UpdatedAt = session.CreatedAt
+	r.m.data.sessions[session.ID] = *session
+	return nil
+}
+
+func (r memorySessions) Touch(id uint, at time.Time, expires time.Time) error {
+	defer r.m.lock()()
+	session, ok := r.m.data.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	session.LastUsedAt = at
+	session.ExpiresAt = expires
+	session.UpdatedAt = time.Now()
+	r.m.data.sessions[id] = session
+	return nil
+}
+
+func (r memorySessions) Revoke(id uint, at time.Time) error {
+	defer r.m.lock()()
+	session, ok := r.m.data.sessions[id]
+	if !ok || session.RevokedAt != nil {
+		return ErrNotFound
+	}
+	session.RevokedAt = &at
+	r.m.data.sessions[id] = session
+	return nil
+}
+
+func (r memorySessions) RevokeAll(userID uint, at time.Time) error {
+	defer r.m.lock()()
+	for id, session := range r.m.data.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			revokedAt := at
+			session.RevokedAt = &revokedAt
+			r.m.data.sessions[id] = session
+		}
+	}
+	return nil
+}
+
+func (r memorySessions) FindToken(hash string) (models.RefreshToken, error) {
+	defer r.m.lock()()
+	for _, token := range r.m.data.refreshTokens {
+		if token.TokenHash == hash {
+			token.Session = r.m.data.sessions[token.SessionID]
+			return token, nil
+		}
+	}
+	return models.RefreshToken{}, ErrNotFound
+}
+
+func (r memorySessions) CreateToken(token *models.RefreshToken) error {
+	defer r.m.lock()()
+	for _, item := range r.m.data.refreshTokens {
+		if item.TokenHash == token.TokenHash {
+			return ErrDuplicate
+		}
+	}
+	token.ID = r.m.data.nextID()
+	token.CreatedAt = time.Now()
+	token.UpdatedAt = token.CreatedAt
+
+	stored := *token
+	stored.Session = models.Session{}
+	r.m.data.refreshTokens[token.ID] = stored
+	return nil
+}
+
+func (r memorySessions) UseToken(id uint, at time.Time) (bool, error) {
+	defer r.m.lock()()
+	token, ok := r.m.data.refreshTokens[id]
+	if !ok || token.UsedAt != nil {
+		return false, nil
+	}
+	token.UsedAt = &at
+	r.m.data.refreshTokens[id] = token
+	return true, nil
+}
+
+type memoryAuditLogs struct {
+	m *Memory
+}
+
+func (r memoryAuditLogs) List(filter AuditFilter) ([]models.AuditLog, error) {
+	defer r.m.lock()()
+	var ids []uint
+	for id, item := range r.m.data.auditLogs {
+		if filter.ActorID != 0 && item.ActorID != filter.ActorID {
+			continue
+		}
+		if filter.Action != "" && item.Action != filter.Action {
+			continue
+		}
+		if filter.TargetType != "" && item.TargetType != filter.TargetType {
+			continue
+		}
+		if filter.TargetID != 0 && item.TargetID != filter.TargetID {
+			continue
+		}
+		if filter.Cursor != 0 && id >= filter.Cursor {
+			continue
+		}
+		if !filter.From.IsZero() && item.CreatedAt.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !item.CreatedAt.Before(filter.To) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] > ids[j] })
+	if filter.Limit > 0 && len(ids) > filter.Limit {
+		ids = ids[:filter.Limit]
+	}
+
+	var entries []models.AuditLog
+	for _, id := range ids {
+		entry := r.m.data.auditLogs[id]
+		entry.Actor, _ = r.m.data.user(entry.ActorID)
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (r memoryAuditLogs) Create(entry *models.AuditLog) error {
+	defer r.m.lock()()
+	entry.ID = r.m.data.nextID()
+	entry.CreatedAt = time.Now()
+
+	stored := *entry
+	stored.Actor = models.User{}
+	r.m.data.auditLogs[entry.ID] = stored
+	return nil
+}
@@ -0,0 +1,229 @@
+package repository
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"mvpmatch/models"
+	"time"
+)
+
+// ErrNotFound is returned when no record matches a lookup.
+var ErrNotFound = errors.New("record not found")
+
+// ErrDuplicate is returned when a write would repeat a username or product name.
+var ErrDuplicate = errors.New("record already exists")
+
+// Store gives the handlers every repository they read and write through.
+// Transaction runs fn against a store whose writes are committed together
+// or not at all, the Lock methods of that store hold their rows until it ends.
+// Ping reports whether the storage behind the store can be reached.
+type Store interface {
+	Users() Users
+	Roles() Roles
+	Permissions() Permissions
+	Products() Products
+	Orders() Orders
+	Coins() Coins
+	Wallets() Wallets
+	Machines() Machines
+	Slots() Slots
+	Sessions() Sessions
+	AuditLogs() AuditLogs
+	Transaction(fn func(tx Store) error) error
+	Ping(ctx context.Context) error
+}
+
+// Users reads users along with their role.
+type Users interface {
+	Find(id uint) (models.User, error)
+	FindByUsername(username string) (models.User, error)
+	List() ([]models.User, error)
+	Lock(id uint) (models.User, error)
+	Create(user *models.User) error
+	//UpdateProfile changes the username, and the password hash when one is given
+	UpdateProfile(id uint, username string, password string) error
+	SetDeposit(id uint, deposit int) error
+	SetDepositMachine(id uint, machineID uint) error
+	//Suspend keeps a user from logging in until Restore lets them back
+	Suspend(id uint, at time.Time, reason string) error
+	Restore(id uint) error
+	Delete(id uint) error
+}
+
+type Roles interface {
+	Find(id uint) (models.Role, error)
+	FindByName(name string) (models.Role, error)
+	List() ([]models.Role, error)
+	Create(role *models.Role) error
+	SetSelfAssignable(id uint, selfAssignable bool) error
+}
+
+// Permissions reads the permissions and which roles they are granted to, sorted by name.
+type Permissions interface {
+	FindByName(name string) (models.Permission, error)
+	List() ([]models.Permission, error)
+	Create(permission *models.Permission) error
+	//ForRole returns the permissions granted to a role
+	ForRole(roleID uint) ([]models.Permission, error)
+	//Granted reports whether the role with the given name holds the permission
+	Granted(role string, permission string) (bool, error)
+	Grant(roleID uint, permissionID uint) error
+	//SetForRole replaces every permission of a role with the given ones
+	SetForRole(roleID uint, permissionIDs []uint) error
+}
+
+// Products reads products along with their seller and machine.
+type Products interface {
+	Find(id uint) (models.Product, error)
+	FindByName(name string) (models.Product, error)
+	//List returns the products of a machine, or of every machine when machineID is 0
+	List(machineID uint) ([]models.Product, error)
+	Lock(id uint) (models.Product, error)
+	Create(product *models.Product) error
+	//Update saves the name, cost and amount available of the product
+	Update(product models.Product) error
+	Delete(id uint) error
+	//TakeStock reports false and leaves the stock alone when less than units are available
+	TakeStock(id uint, units int) (bool, error)
+	AddStock(id uint, units int) error
+	SetStock(id uint, amount int) error
+}
+
+// OrderFilter narrows an order list, zero values are ignored.
+// Cursor is the id of the last order on the previous page and To is exclusive.
+type OrderFilter struct {
+	UserID    uint
+	SellerID  uint
+	ProductID uint
+	Cursor    uint
+	From      time.Time
+	To        time.Time
+	Limit     int
+}
+
+// Orders reads orders along with their product, buyer and change breakdown, newest first.
+type Orders interface {
+	Find(id uint) (models.Order, error)
+	List(filter OrderFilter) ([]models.Order, error)
+	Lock(id uint) (models.Order, error)
+	Create(order *models.Order) error
+	AddChange(change []models.OrderChange) error
+	//Refund adds units and amount to what the order refunded before, keeping the latest reason and time
+	Refund(id uint, units int, amount int, reason string, at time.Time) error
+}
+
+// Coins keeps the coin inventory, its ledger and the accepted denominations of each machine.
+type Coins interface {
+	//Denominations lists the coin values a machine accepts, smallest first
+	Denominations(machineID uint) ([]int, error)
+	//AddDenomination returns ErrDuplicate when the machine already accepts the value
+	AddDenomination(machineID uint, value int) error
+	RemoveDenomination(machineID uint, value int) error
+	//Lock returns the coins of the given denominations held by a machine, largest first
+	Lock(machineID uint, denominations []int) ([]models.Coin, error)
+	//LockAll returns the coins of every machine, by machine and then largest denomination first
+	LockAll() ([]models.Coin, error)
+	//Add puts count coins into a machine, creating the row of a denomination it never held
+	Add(machineID uint, denomination int, count int) error
+	//Increment adds delta to a coin count, it reports false and changes nothing
+	//when the machine has no such coin or the count would drop below zero
+	Increment(machineID uint, denomination int, delta int) (bool, error)
+	//Create returns ErrDuplicate when the machine already has a row for the denomination
+	Create(coin *models.Coin) error
+	//SetCount overwrites a coin count, it reports false when the machine has no such coin
+	SetCount(machineID uint, denomination int, count int) (bool, error)
+	Record(movement *models.CoinMovement) error
+	//Totals sums the ledger into a count per machine and denomination, ordered like LockAll
+	Totals() ([]models.Coin, error)
+	//Movements lists the ledger entries of one kind recorded against an order
+	Movements(orderID uint, kind string) ([]models.CoinMovement, error)
+}
+
+// WalletFilter narrows a wallet list, zero values are ignored.
+// Type is credit or debit, Cursor and To work like they do on OrderFilter.
+type WalletFilter struct {
+	UserID uint
+	Type   string
+	Cursor uint
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+// Wallets reads the ledger of deposit changes, newest first.
+type Wallets interface {
+	Find(id uint) (models.Wallet, error)
+	FindByReference(source string, referenceID uint) (models.Wallet, error)
+	List(filter WalletFilter) ([]models.Wallet, error)
+	Create(entry *models.Wallet) error
+	//Drifted lists the users whose deposit differs from the sum of their ledger, by user
+	Drifted() ([]Balance, error)
+}
+
+// Balance is the deposit a user holds next to the sum of their ledger.
+type Balance struct {
+	UserID  uint
+	Deposit int
+	Ledger  int
+}
+
+type Machines interface {
+	Find(id uint) (models.Machine, error)
+	//Default returns the oldest machine
+	Default() (models.Machine, error)
+	List() ([]models.Machine, error)
+	Create(machine *models.Machine) error
+}
+
+// Slots reads slots along with the product they carry.
+type Slots interface {
+	Find(machineID uint, code string) (models.Slot, error)
+	List(machineID uint) ([]models.Slot, error)
+	Lock(machineID uint, code string) (models.Slot, error)
+	//LockForProduct returns every slot carrying the product
+	LockForProduct(productID uint) ([]models.Slot, error)
+	CountForProduct(productID uint) (int, error)
+	//Create returns ErrDuplicate when the machine already has a slot with the code
+	Create(slot *models.Slot) error
+	SetFill(id uint, fill int) error
+	Assign(id uint, productID uint) error
+	//Clear empties every slot carrying the product and takes the product out of them
+	Clear(productID uint) error
+}
+
+// Sessions keeps the devices users are logged in on along with their refresh tokens.
+type Sessions interface {
+	Find(id uint) (models.Session, error)
+	//List returns the sessions of a user that are neither revoked nor expired at now, newest first
+	List(userID uint, now time.Time) ([]models.Session, error)
+	Create(session *models.Session) error
+	//Touch records a refresh of the session and moves its expiry
+	Touch(id uint, at time.Time, expires time.Time) error
+	Revoke(id uint, at time.Time) error
+	//RevokeAll ends every session of a user still running
+	RevokeAll(userID uint, at time.Time) error
+	//FindToken returns the refresh token with the hash along with its session
+	FindToken(hash string) (models.RefreshToken, error)
+	CreateToken(token *models.RefreshToken) error
+	//UseToken marks a refresh token used, it reports false when it had been used already
+	UseToken(id uint, at time.Time) (bool, error)
+}
+
+// AuditFilter narrows an audit log list, zero values are ignored.
+// Cursor and To work like they do on OrderFilter.
+type AuditFilter struct {
+	ActorID    uint
+	Action     string
+	TargetType string
+	TargetID   uint
+	Cursor     uint
+	From       time.Time
+	To         time.Time
+	Limit      int
+}
+
+// AuditLogs keeps the record of admin actions along with the admin who took them, newest first.
+type AuditLogs interface {
+	List(filter AuditFilter) ([]models.AuditLog, error)
+	Create(entry *models.AuditLog) error
+}
@@ -2,50 +2,116 @@ package routes
 
 import (
 	"github.com/gofiber/fiber/v2"
-	jwtware "github.com/gofiber/jwt/v2"
 	"mvpmatch/config"
 	"mvpmatch/handlers"
 	"mvpmatch/middleware"
+	"mvpmatch/permissions"
+	"mvpmatch/repository"
+	"mvpmatch/tokens"
 )
 
-func Routes(app *fiber.App) {
-
-	jwtToken := jwtware.New(jwtware.Config{
-		SigningKey: []byte(config.App.JWTKey),
-		ErrorHandler: func(c *fiber.Ctx, err error) error {
-			if err.Error() == "Missing or malformed JWT" {
-				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-					"message": "Missing or malformed JWT", "status": false})
-			} else {
-				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-					"message": "Invalid or expired JWT", "status": false})
-			}
-		},
-	})
+func Routes(app *fiber.App, store repository.Store, revoked tokens.Revocations) {
+
+	//every route behind the token also refuses tokens that were logged out
+	auth := middleware.NewAuth(store, revoked, config.Redis.Policy)
+
+	jwtToken := middleware.JWT(tokens.Keys(), auth.Active)
+
+	h := handlers.New(store, revoked)
+
+	//other services verify the tokens with the published keys
+	app.Get("/.well-known/jwks.json", h.JWKS)
 
 	route := app.Group("/v1")
-	userRoutes(route, jwtToken)
+	route.Get("health", h.Health)
+	userRoutes(route, h, auth, jwtToken)
+	machineRoutes(route, h, auth, jwtToken)
+	adminRoutes(route, h, auth, jwtToken)
+}
+
+func userRoutes(route fiber.Router, h *handlers.Handler, auth *middleware.Auth, token fiber.Handler) {
+
+	route.Post("user", h.AddUser)
+	route.Get("user", h.GetUsers)
+	route.Patch("user", token, h.EditUser)
+	route.Delete("user", token, h.DeleteUser)
+
+	route.Post("login", h.Login)
+	route.Post("logout", token, h.Logout)
+	route.Post("token/refresh", h.RefreshToken)
+	route.Get("sessions", token, h.GetSessions)
+	route.Delete("sessions/:id", token, h.DeleteSession)
+
+	route.Post("product", token, auth.Require(permissions.ProductWrite), h.AddProduct)
+	route.Get("product", h.GetProducts)
+	route.Put("product", token, auth.Require(permissions.ProductWrite), h.EditProduct)
+	route.Delete("product", token, auth.Require(permissions.ProductWrite), h.DeleteProduct)
+
+	route.Post("deposit", token, auth.Require(permissions.CoinDeposit), h.Deposit)
+	route.Post("buy", token, auth.Require(permissions.OrderCreate), h.Buy)
+	route.Patch("deposit/reset", token, auth.Require(permissions.CoinDeposit), h.ResetDeposit)
+
+	route.Get("wallet", token, auth.Require(permissions.WalletRead), h.GetWallet)
+	route.Get("wallet/:id", token, auth.Require(permissions.WalletRead), h.GetWalletEntry)
+
+	route.Get("orders", token, auth.Require(permissions.OrderRead), h.GetOrders)
+	route.Get("orders/:id", token, auth.Require(permissions.OrderRead), h.GetOrderReceipt)
+	route.Get("seller/orders", token, auth.Require(permissions.SaleRead), h.GetSellerOrders)
+	route.Get("seller/orders/:id", token, auth.Require(permissions.SaleRead), h.GetSellerOrderReceipt)
+	route.Post("orders/:id/refund", token, auth.Require(permissions.OrderRefund), h.RefundOrder)
+
+	route.Get("denominations", h.GetDenominations)
+	route.Post("denominations", token, auth.Require(permissions.DenominationWrite), h.AddDenomination)
+	route.Delete("denominations", token, auth.Require(permissions.DenominationWrite), h.DeleteDenomination)
+
+	route.Get("role", h.GetRole)
+}
+
+// machineRoutes act on one machine of the fleet, the routes above use the default machine.
+func machineRoutes(route fiber.Router, h *handlers.Handler, auth *middleware.Auth, token fiber.Handler) {
+
+	route.Get("machines", h.GetMachines)
+	route.Post("machines", token, auth.Require(permissions.MachineWrite), h.AddMachine)
+
+	machine := route.Group("machines/:machine")
+
+	machine.Get("products", h.GetProducts)
+
+	machine.Get("slots", h.GetSlots)
+	machine.Post("slots", token, auth.Require(permissions.MachineWrite), h.AddSlot)
+	machine.Put("slots/:code/restock", token, auth.Require(permissions.SlotStock), h.RestockSlot)
+	machine.Put("slots/:code/assign", token, auth.Require(permissions.SlotStock), h.AssignSlot)
+
+	machine.Post("deposit", token, auth.Require(permissions.CoinDeposit), h.Deposit)
+	machine.Post("buy", token, auth.Require(permissions.OrderCreate), h.Buy)
+	machine.Patch("deposit/reset", token, auth.Require(permissions.CoinDeposit), h.ResetDeposit)
+
+	machine.Get("denominations", h.GetDenominations)
+	machine.Post("denominations", token, auth.Require(permissions.DenominationWrite), h.AddDenomination)
+	machine.Delete("denominations", token, auth.Require(permissions.DenominationWrite), h.DeleteDenomination)
 }
 
-func userRoutes(route fiber.Router, token fiber.Handler) {
+// adminRoutes moderate users, deposits, products, orders and coins and manage what roles may do,
+// every write lands in the audit log.
+func adminRoutes(route fiber.Router, h *handlers.Handler, auth *middleware.Auth, token fiber.Handler) {
+
+	admin := route.Group("admin", token)
+
+	admin.Get("users", auth.Require(permissions.UserModerate), h.GetAdminUsers)
+	admin.Post("users/:id/suspend", auth.Require(permissions.UserModerate), h.SuspendUser)
+	admin.Post("users/:id/restore", auth.Require(permissions.UserModerate), h.RestoreUser)
+	admin.Post("users/:id/deposit", auth.Require(permissions.DepositModerate), h.PayOutDeposit)
 
-	route.Post("user", handlers.AddUser)
-	route.Get("user", handlers.GetUsers)
-	route.Patch("user", token, handlers.EditUser)
-	route.Delete("user", token, handlers.DeleteUser)
+	admin.Put("products/:id", auth.Require(permissions.ProductModerate), h.AdminEditProduct)
+	admin.Delete("products/:id", auth.Require(permissions.ProductModerate), h.AdminDeleteProduct)
 
-	route.Post("login", handlers.Login)
-	route.Post("logout", token, handlers.Logout)
-	route.Post("login/test", handlers.Logintest)
+	admin.Post("orders/:id/refund", auth.Require(permissions.OrderModerate), h.AdminRefundOrder)
 
-	route.Post("product", token, middleware.Seller, handlers.AddProduct)
-	route.Get("product", handlers.GetProducts)
-	route.Put("product", token, middleware.Seller, handlers.EditProduct)
-	route.Delete("product", token, middleware.Seller, handlers.DeleteProduct)
+	admin.Post("machines/:machine/coins", auth.Require(permissions.CoinAdjust), h.AdjustCoins)
 
-	route.Post("deposit", token, middleware.Buyer, handlers.Deposit)
-	route.Post("buy", token, middleware.Buyer, handlers.Buy)
-	route.Patch("deposit/reset", token, handlers.ResetDeposit)
+	admin.Get("audit", auth.Require(permissions.AuditRead), h.GetAuditLogs)
 
-	route.Get("role", handlers.GetRole)
+	admin.Get("permissions", auth.Require(permissions.RoleWrite), h.GetPermissions)
+	admin.Get("roles", auth.Require(permissions.RoleWrite), h.GetAdminRoles)
+	admin.Put("roles/:id", auth.Require(permissions.RoleWrite), h.EditRole)
 }
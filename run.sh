@@ -1,2 +1,2 @@
 #!/bin/sh
-go run main.go
\ No newline at end of file
+go run main.go migrate up && go run main.go seed --demo=false && go run main.go serve
\ No newline at end of file
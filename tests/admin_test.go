@@ -0,0 +1,530 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+	"mvpmatch/audit"
+	"mvpmatch/config"
+	"mvpmatch/inventory"
+	"mvpmatch/models"
+	"mvpmatch/repository"
+	"mvpmatch/routes"
+	"mvpmatch/tokens"
+	"mvpmatch/wallet"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdmin(t *testing.T) {
+	t.Run("memory", func(t *testing.T) { admin(t, newFixture(t)) })
+	t.Run("sqlite", func(t *testing.T) { admin(t, newSQLiteFixture(t)) })
+}
+
+// admin has an admin suspend and restore a buyer, edit and remove a seller's product
+// and adjust the coins of the machine, then checks every action was audited.
+func admin(t *testing.T, f fixture) {
+
+	adminRole, _ := f.store.Roles().FindByName(config.Role.Admin)
+	buyerRole, _ := f.store.Roles().FindByName(config.Role.Buyer)
+
+	moderator := models.User{Username: "moderator", RoleID: adminRole.ID}
+	if err := f.store.Users().Create(&moderator); err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	erin := models.User{Username: "erin", Password: string(hash), RoleID: buyerRole.ID}
+	if err := f.store.Users().Create(&erin); err != nil {
+		t.Fatal(err)
+	}
+
+	other := models.Product{MachineID: f.machine.ID, AmountAvailable: 3, Cost: 10, ProductName: "other", SellerID: f.seller.ID}
+	if err := f.store.Products().Create(&other); err != nil {
+		t.Fatal(err)
+	}
+
+	suspend := fmt.Sprintf("/v1/admin/users/%d/suspend", erin.ID)
+	restore := fmt.Sprintf("/v1/admin/users/%d/restore", erin.ID)
+	product := fmt.Sprintf("/v1/admin/products/%d", f.product.ID)
+	coins := fmt.Sprintf("/v1/admin/machines/%d/coins", f.machine.ID)
+	login := fiber.Map{"username": "erin", "password": "secret"}
+
+	tests := []struct {
+		description  string      // description of the test case
+		method       string      // http method of the step
+		route        string      // route path to test
+		as           string      // name of the token making the request
+		save         string      // name to keep the tokens of a login under
+		payload      interface{} // request body
+		expectedCode int         // expected HTTP status code
+	}{
+		{
+			description:  "Test: log the buyer in, get HTTP status 200",
+			method:       http.MethodPost,
+			route:        "/v1/login",
+			save:         "erin",
+			payload:      login,
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: a buyer lists users as admin, get HTTP status 401",
+			method:       http.MethodGet,
+			route:        "/v1/admin/users",
+			as:           "erin",
+			expectedCode: 401,
+		},
+		{
+			description:  "Test: list users, get HTTP status 200",
+			method:       http.MethodGet,
+			route:        "/v1/admin/users",
+			as:           "admin",
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: suspend without a reason, get HTTP status 400",
+			method:       http.MethodPost,
+			route:        suspend,
+			as:           "admin",
+			payload:      fiber.Map{},
+			expectedCode: 400,
+		},
+		{
+			description:  "Test: suspend yourself, get HTTP status 400",
+			method:       http.MethodPost,
+			route:        fmt.Sprintf("/v1/admin/users/%d/suspend", moderator.ID),
+			as:           "admin",
+			payload:      fiber.Map{"reason": "testing"},
+			expectedCode: 400,
+		},
+		{
+			description:  "Test: suspend an unknown user, get HTTP status 404",
+			method:       http.MethodPost,
+			route:        "/v1/admin/users/9999/suspend",
+			as:           "admin",
+			payload:      fiber.Map{"reason": "testing"},
+			expectedCode: 404,
+		},
+		{
+			description:  "Test: suspend the buyer, get HTTP status 200",
+			method:       http.MethodPost,
+			route:        suspend,
+			as:           "admin",
+			payload:      fiber.Map{"reason": "chargeback abuse"},
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: use a token of the suspended buyer, get HTTP status 401",
+			method:       http.MethodGet,
+			route:        "/v1/wallet",
+			as:           "erin",
+			expectedCode: 401,
+		},
+		{
+			description:  "Test: the suspended buyer logs in, get HTTP status 403",
+			method:       http.MethodPost,
+			route:        "/v1/login",
+			payload:      login,
+			expectedCode: 403,
+		},
+		{
+			description:  "Test: suspend the buyer again, get HTTP status 400",
+			method:       http.MethodPost,
+			route:        suspend,
+			as:           "admin",
+			payload:      fiber.Map{"reason": "again"},
+			expectedCode: 400,
+		},
+		{
+			description:  "Test: restore the buyer, get HTTP status 200",
+			method:       http.MethodPost,
+			route:        restore,
+			as:           "admin",
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: restore a buyer who is not suspended, get HTTP status 400",
+			method:       http.MethodPost,
+			route:        restore,
+			as:           "admin",
+			expectedCode: 400,
+		},
+		{
+			description:  "Test: the restored buyer logs in, get HTTP status 200",
+			method:       http.MethodPost,
+			route:        "/v1/login",
+			save:         "erin",
+			payload:      login,
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: a seller edits a product as admin, get HTTP status 401",
+			method:       http.MethodPut,
+			route:        product,
+			as:           "seller",
+			payload:      fiber.Map{"product_name": "renamed", "cost": 15, "amount_available": 4},
+			expectedCode: 401,
+		},
+		{
+			description:  "Test: rename a product onto another one, get HTTP status 400",
+			method:       http.MethodPut,
+			route:        product,
+			as:           "admin",
+			payload:      fiber.Map{"product_name": "other", "cost": 15, "amount_available": 4},
+			expectedCode: 400,
+		},
+		{
+			description:  "Test: edit an unknown product, get HTTP status 404",
+			method:       http.MethodPut,
+			route:        "/v1/admin/products/9999",
+			as:           "admin",
+			payload:      fiber.Map{"product_name": "renamed", "cost": 15, "amount_available": 4},
+			expectedCode: 404,
+		},
+		{
+			description:  "Test: edit the seller's product, get HTTP status 200",
+			method:       http.MethodPut,
+			route:        product,
+			as:           "admin",
+			payload:      fiber.Map{"product_name": "renamed", "cost": 15, "amount_available": 4},
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: add coins the machine does not accept, get HTTP status 400",
+			method:       http.MethodPost,
+			route:        coins,
+			as:           "admin",
+			payload:      fiber.Map{"coins": fiber.Map{"7": 1}, "reason": "float"},
+			expectedCode: 400,
+		},
+		{
+			description:  "Test: take out more coins than the machine holds, get HTTP status 400",
+			method:       http.MethodPost,
+			route:        coins,
+			as:           "admin",
+			payload:      fiber.Map{"coins": fiber.Map{"10": -1}, "reason": "stuck deposit"},
+			expectedCode: 400,
+		},
+		{
+			description:  "Test: adjust coins without a reason, get HTTP status 400",
+			method:       http.MethodPost,
+			route:        coins,
+			as:           "admin",
+			payload:      fiber.Map{"coins": fiber.Map{"5": 3}},
+			expectedCode: 400,
+		},
+		{
+			description:  "Test: add coins to the machine, get HTTP status 200",
+			method:       http.MethodPost,
+			route:        coins,
+			as:           "admin",
+			payload:      fiber.Map{"coins": fiber.Map{"5": 3, "10": 2}, "reason": "float for change"},
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: take coins out of the machine, get HTTP status 200",
+			method:       http.MethodPost,
+			route:        coins,
+			as:           "admin",
+			payload:      fiber.Map{"coins": fiber.Map{"10": -2}, "reason": "paid out a stuck deposit"},
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: remove the seller's product, get HTTP status 200",
+			method:       http.MethodDelete,
+			route:        product,
+			as:           "admin",
+			payload:      fiber.Map{"reason": "counterfeit"},
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: remove the product again, get HTTP status 404",
+			method:       http.MethodDelete,
+			route:        product,
+			as:           "admin",
+			expectedCode: 404,
+		},
+		{
+			description:  "Test: accept a new coin, get HTTP status 201",
+			method:       http.MethodPost,
+			route:        "/v1/denominations",
+			as:           "admin",
+			payload:      fiber.Map{"value": 200},
+			expectedCode: 201,
+		},
+		{
+			description:  "Test: read the audit log, get HTTP status 200",
+			method:       http.MethodGet,
+			route:        "/v1/admin/audit?action=" + audit.SuspendUser,
+			as:           "admin",
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: a buyer reads the audit log, get HTTP status 401",
+			method:       http.MethodGet,
+			route:        "/v1/admin/audit",
+			as:           "erin",
+			expectedCode: 401,
+		},
+	}
+
+	// Define Fiber app.
+	app := fiber.New()
+	routes.Routes(app, f.store, tokens.NewMemory())
+
+	logins := map[string]string{
+		"admin":  mintToken(t, moderator.ID, config.Role.Admin),
+		"seller": mintToken(t, f.seller.ID, config.Role.Seller),
+	}
+
+	// Run every step in order, each one builds on the ones before it
+	for _, test := range tests {
+		var body io.Reader
+		if test.payload != nil {
+			payload, err := json.Marshal(test.payload)
+			if err != nil {
+				panic(err)
+			}
+			body = bytes.NewReader(payload)
+		}
+
+		req := httptest.NewRequest(test.method, test.route, body)
+		req.Header.Set("Content-Type", "application/json")
+		if test.as != "" {
+			req.Header.Set("Authorization", "Bearer "+logins[test.as])
+		}
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Verify, if the status code is as expected
+		assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+
+		if test.save != "" && resp.StatusCode == 200 {
+			var response struct {
+				Data struct {
+					Token string `json:"token"`
+				} `json:"data"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			logins[test.save] = response.Data.Token
+		}
+	}
+
+	_, err = f.store.Products().Find(f.product.ID)
+	assert.Equalf(t, repository.ErrNotFound, err, "Test: the removed product is gone")
+
+	user, err := f.store.Users().Find(erin.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equalf(t, true, user.SuspendedAt == nil, "Test: the buyer is no longer suspended")
+
+	counts := map[int]int{}
+	locked, _ := f.store.Coins().Lock(f.machine.ID, []int{5, 10})
+	for _, coin := range locked {
+		counts[coin.Denomination] = coin.Count
+	}
+	assert.Equalf(t, map[int]int{5: 3, 10: 0}, counts, "Test: the coin adjustments add up")
+
+	entries, err := f.store.AuditLogs().List(repository.AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for i := len(entries) - 1; i >= 0; i-- {
+		actions = append(actions, entries[i].Action)
+		assert.Equalf(t, moderator.ID, entries[i].ActorID, "Test: every entry names the admin")
+		assert.Equalf(t, "moderator", entries[i].Actor.Username, "Test: entries come with their admin")
+	}
+	assert.Equalf(t, []string{
+		audit.SuspendUser,
+		audit.RestoreUser,
+		audit.EditProduct,
+		audit.AdjustCoins,
+		audit.AdjustCoins,
+		audit.DeleteProduct,
+		audit.AddDenomination,
+	}, actions, "Test: every admin action is audited, failed ones are not")
+
+}
+
+func TestPayOutDeposit(t *testing.T) {
+	t.Run("memory", func(t *testing.T) { payOutDeposit(t, newFixture(t)) })
+	t.Run("sqlite", func(t *testing.T) { payOutDeposit(t, newSQLiteFixture(t)) })
+}
+
+// payOutDeposit has an admin fix a deposit of 35 the machine cannot make change for, paying
+// part of it out in coins and clearing the rest, and checks the deposit, the wallet ledger
+// and the coins of the machine agree after every step.
+func payOutDeposit(t *testing.T, f fixture) {
+
+	adminRole, _ := f.store.Roles().FindByName(config.Role.Admin)
+	moderator := models.User{Username: "moderator", RoleID: adminRole.ID}
+	if err := f.store.Users().Create(&moderator); err != nil {
+		t.Fatal(err)
+	}
+
+	//three 10s cannot pay back 35
+	restock := models.CoinMovement{Kind: inventory.Restock, MachineID: f.machine.ID}
+	if err := inventory.Apply(f.store, restock, map[int]int{10: 3}); err != nil {
+		t.Fatal(err)
+	}
+	f.fund(t, f.buyer.ID, 35)
+
+	route := fmt.Sprintf("/v1/admin/users/%d/deposit", f.buyer.ID)
+
+	tests := []struct {
+		description     string      // description of the test case
+		route           string      // route path to test
+		as              string      // name of the token making the request
+		payload         interface{} // request body
+		expectedCode    int         // expected HTTP status code
+		expectedDeposit int         // expected deposit of the buyer after the step
+		expectedTens    int         // expected 10s held by the machine after the step
+	}{
+		{
+			description:     "Test: a seller pays out a deposit, get HTTP status 401",
+			route:           route,
+			as:              "seller",
+			payload:         fiber.Map{"clear": true, "reason": "stuck"},
+			expectedCode:    401,
+			expectedDeposit: 35,
+			expectedTens:    3,
+		},
+		{
+			description:     "Test: pay out neither coins nor clear, get HTTP status 400",
+			route:           route,
+			as:              "admin",
+			payload:         fiber.Map{"reason": "stuck"},
+			expectedCode:    400,
+			expectedDeposit: 35,
+			expectedTens:    3,
+		},
+		{
+			description:     "Test: pay out more than the deposit, get HTTP status 400",
+			route:           route,
+			as:              "admin",
+			payload:         fiber.Map{"coins": map[int]int{10: 4}, "reason": "stuck"},
+			expectedCode:    400,
+			expectedDeposit: 35,
+			expectedTens:    3,
+		},
+		{
+			description:     "Test: pay out coins the machine does not hold, get HTTP status 400",
+			route:           route,
+			as:              "admin",
+			payload:         fiber.Map{"coins": map[int]int{5: 1}, "reason": "stuck"},
+			expectedCode:    400,
+			expectedDeposit: 35,
+			expectedTens:    3,
+		},
+		{
+			description:     "Test: pay out the coins the machine holds, get HTTP status 200",
+			route:           route,
+			as:              "admin",
+			payload:         fiber.Map{"coins": map[int]int{10: 3}, "reason": "stuck"},
+			expectedCode:    200,
+			expectedDeposit: 5,
+			expectedTens:    0,
+		},
+		{
+			description:     "Test: clear the 5 handed back by hand, get HTTP status 200",
+			route:           route,
+			as:              "admin",
+			payload:         fiber.Map{"clear": true, "reason": "paid from the float"},
+			expectedCode:    200,
+			expectedDeposit: 0,
+			expectedTens:    0,
+		},
+		{
+			description:     "Test: pay out a cleared deposit, get HTTP status 400",
+			route:           route,
+			as:              "admin",
+			payload:         fiber.Map{"clear": true, "reason": "stuck"},
+			expectedCode:    400,
+			expectedDeposit: 0,
+			expectedTens:    0,
+		},
+		{
+			description:     "Test: pay out the deposit of an unknown user, get HTTP status 404",
+			route:           "/v1/admin/users/9999/deposit",
+			as:              "admin",
+			payload:         fiber.Map{"clear": true, "reason": "stuck"},
+			expectedCode:    404,
+			expectedDeposit: 0,
+			expectedTens:    0,
+		},
+	}
+
+	// Define Fiber app.
+	app := fiber.New()
+	routes.Routes(app, f.store, tokens.NewMemory())
+
+	logins := map[string]string{
+		"admin":  mintToken(t, moderator.ID, config.Role.Admin),
+		"seller": mintToken(t, f.seller.ID, config.Role.Seller),
+	}
+
+	// Run every step in order, each one builds on the ones before it
+	for _, test := range tests {
+		payload, err := json.Marshal(test.payload)
+		if err != nil {
+			panic(err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, test.route, bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+logins[test.as])
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Verify, if the status code is as expected
+		assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+
+		buyer, _ := f.store.Users().Find(f.buyer.ID)
+		assert.Equalf(t, test.expectedDeposit, buyer.Deposit, test.description)
+		assert.Equalf(t, test.expectedTens, coinCounts(f.store, f.machine.ID)[10], test.description)
+	}
+
+	entries, _ := f.store.Wallets().List(repository.WalletFilter{UserID: f.buyer.ID, Type: "debit"})
+	debits := map[string]int{}
+	for _, entry := range entries {
+		debits[entry.Source] += entry.Debit
+	}
+	assert.Equalf(t, map[string]int{wallet.Reset: 30, wallet.Adjustment: 5}, debits, "Test: the coins and the cleared rest are debited from the wallet")
+
+	mismatches, _ := wallet.Reconcile(f.store)
+	assert.Equalf(t, 0, len(mismatches), "Test: the deposit agrees with the ledger")
+
+	var rebuilt []models.Coin
+	err := f.store.Transaction(func(tx repository.Store) error {
+		var err error
+		rebuilt, err = inventory.Rebuild(tx)
+		return err
+	})
+	assert.Equalf(t, nil, err, "Test: the coins paid out are in the coin ledger")
+	for _, coin := range rebuilt {
+		if coin.MachineID == f.machine.ID && coin.Denomination == 10 {
+			assert.Equalf(t, 0, coin.Count, "Test: the coin ledger agrees with the machine")
+		}
+	}
+
+	logs, _ := f.store.AuditLogs().List(repository.AuditFilter{Action: audit.PayOutDeposit})
+	assert.Equalf(t, 2, len(logs), "Test: every payout is audited")
+}
@@ -0,0 +1,129 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"mvpmatch/config"
+	"mvpmatch/handlers"
+	"mvpmatch/middleware"
+	"mvpmatch/models"
+	"mvpmatch/repository"
+	"mvpmatch/tokens"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentBuy(t *testing.T) {
+	t.Run("memory", func(t *testing.T) { concurrentBuy(t, newFixture(t)) })
+	t.Run("sqlite", func(t *testing.T) { concurrentBuy(t, newSQLiteFixture(t)) })
+	t.Run("mysql", func(t *testing.T) { concurrentBuy(t, newMySQLFixture(t)) })
+}
+
+// concurrentBuy fires more buys than there is stock at once and checks only the stock is
+// sold and every buyer who missed out keeps their deposit. The memory store and SQLite run
+// one transaction at a time, so there it only shows a failed buy rolls back in full. MySQL
+// runs the buys side by side, it is the run that tests the row locks.
+func concurrentBuy(t *testing.T, f fixture) {
+
+	const (
+		stock   = 3
+		buyers  = 10
+		cost    = 10
+		deposit = 10
+	)
+
+	store := f.store
+
+	product := models.Product{
+		MachineID:       f.machine.ID,
+		AmountAvailable: stock,
+		Cost:            cost,
+		ProductName:     "limited",
+		SellerID:        f.seller.ID,
+	}
+	if err := store.Products().Create(&product); err != nil {
+		t.Fatal(err)
+	}
+
+	var buyerIDs []uint
+	var buyerTokens []string
+	for i := 0; i < buyers; i++ {
+		buyer := models.User{Username: fmt.Sprintf("buyer_%d", i), RoleID: f.buyer.RoleID}
+		if err := store.Users().Create(&buyer); err != nil {
+			t.Fatal(err)
+		}
+		f.fund(t, buyer.ID, deposit)
+		buyerIDs = append(buyerIDs, buyer.ID)
+		buyerTokens = append(buyerTokens, mintToken(t, buyer.ID, config.Role.Buyer))
+	}
+
+	// Define Fiber app.
+	app := fiber.New()
+	jwtToken := middleware.JWT(tokens.Keys(), nil)
+	app.Post("buy", jwtToken, handlers.New(store, nil).Buy)
+
+	payload, err := json.Marshal(fiber.Map{"product_id": product.ID, "amount": 1})
+	if err != nil {
+		panic(err)
+	}
+
+	// Fire every buy at the same time
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+	)
+	for _, token := range buyerTokens {
+		wg.Add(1)
+		go func(token string) {
+			defer wg.Done()
+
+			req := httptest.NewRequest(http.MethodPost, "/buy", bytes.NewReader(payload))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+token)
+
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if resp.StatusCode == 200 {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}(token)
+	}
+	wg.Wait()
+
+	after, err := store.Products().Find(product.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orders, err := store.Orders().List(repository.OrderFilter{ProductID: product.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equalf(t, stock, succeeded, "Test: only the available stock can be sold")
+	assert.Equalf(t, stock, len(orders), "Test: one order per successful buy")
+	assert.GreaterOrEqualf(t, after.AmountAvailable, 0, "Test: stock never goes negative")
+	assert.Equalf(t, 0, after.AmountAvailable, "Test: stock is sold out")
+
+	held := 0
+	for _, id := range buyerIDs {
+		buyer, err := store.Users().Find(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		held = held + buyer.Deposit
+	}
+	assert.Equalf(t, (buyers-stock)*deposit, held, "Test: buyers who missed out keep their deposit")
+}
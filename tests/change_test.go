@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"mvpmatch/change"
+	"mvpmatch/models"
+	"testing"
+)
+
+func TestMakeChange(t *testing.T) {
+
+	fullFloat := []models.Coin{
+		{Denomination: 5, Count: 10},
+		{Denomination: 10, Count: 10},
+		{Denomination: 20, Count: 10},
+		{Denomination: 50, Count: 10},
+		{Denomination: 100, Count: 10},
+	}
+
+	tests := []struct {
+		description string // description of the test case
+		amount      int    // change to be returned
+		inventory   []models.Coin
+		expected    map[int]int // expected coins by denomination
+		expectedErr error
+	}{
+		{
+			description: "Test: no change due returns no coins",
+			amount:      0,
+			inventory:   fullFloat,
+			expected:    map[int]int{},
+		},
+		{
+			description: "Test: exact match on a single coin",
+			amount:      50,
+			inventory:   fullFloat,
+			expected:    map[int]int{50: 1},
+		},
+		{
+			description: "Test: same denomination used more than once",
+			amount:      40,
+			inventory:   fullFloat,
+			expected:    map[int]int{20: 2},
+		},
+		{
+			description: "Test: fewest coins across every denomination",
+			amount:      185,
+			inventory:   fullFloat,
+			expected:    map[int]int{100: 1, 50: 1, 20: 1, 10: 1, 5: 1},
+		},
+		{
+			description: "Test: amount larger than four coins of one denomination",
+			amount:      600,
+			inventory:   fullFloat,
+			expected:    map[int]int{100: 6},
+		},
+		{
+			description: "Test: no 5-coins makes 15 impossible",
+			amount:      15,
+			inventory: []models.Coin{
+				{Denomination: 10, Count: 5},
+				{Denomination: 20, Count: 5},
+			},
+			expectedErr: change.ErrCannotMakeChange,
+		},
+		{
+			description: "Test: only 20s and 50s, greedy would fail on 60",
+			amount:      60,
+			inventory: []models.Coin{
+				{Denomination: 20, Count: 5},
+				{Denomination: 50, Count: 5},
+			},
+			expected: map[int]int{20: 3},
+		},
+		{
+			description: "Test: only 20s and 50s, 110 needs both",
+			amount:      110,
+			inventory: []models.Coin{
+				{Denomination: 20, Count: 5},
+				{Denomination: 50, Count: 5},
+			},
+			expected: map[int]int{50: 1, 20: 3},
+		},
+		{
+			description: "Test: only 20s and 50s cannot make 30",
+			amount:      30,
+			inventory: []models.Coin{
+				{Denomination: 20, Count: 5},
+				{Denomination: 50, Count: 5},
+			},
+			expectedErr: change.ErrCannotMakeChange,
+		},
+		{
+			description: "Test: bounded counts force smaller coins",
+			amount:      100,
+			inventory: []models.Coin{
+				{Denomination: 50, Count: 1},
+				{Denomination: 20, Count: 2},
+				{Denomination: 10, Count: 1},
+			},
+			expected: map[int]int{50: 1, 20: 2, 10: 1},
+		},
+		{
+			description: "Test: not enough coins in total",
+			amount:      100,
+			inventory: []models.Coin{
+				{Denomination: 50, Count: 1},
+				{Denomination: 20, Count: 1},
+			},
+			expectedErr: change.ErrCannotMakeChange,
+		},
+		{
+			description: "Test: empty denominations are ignored",
+			amount:      20,
+			inventory: []models.Coin{
+				{Denomination: 20, Count: 0},
+				{Denomination: 10, Count: 2},
+			},
+			expected: map[int]int{10: 2},
+		},
+		{
+			description: "Test: empty inventory",
+			amount:      5,
+			inventory:   []models.Coin{},
+			expectedErr: change.ErrCannotMakeChange,
+		},
+	}
+
+	for _, test := range tests {
+		breakdown, err := change.Make(test.amount, test.inventory)
+
+		if test.expectedErr != nil {
+			assert.Equalf(t, test.expectedErr, err, test.description)
+			assert.Nilf(t, breakdown, test.description)
+			continue
+		}
+
+		assert.NoErrorf(t, err, test.description)
+		assert.Equalf(t, test.expected, breakdown, test.description)
+	}
+}
+
+func TestChangeCoins(t *testing.T) {
+
+	tests := []struct {
+		description string // description of the test case
+		breakdown   map[int]int
+		expected    []int
+	}{
+		{
+			description: "Test: empty breakdown gives no coins",
+			breakdown:   map[int]int{},
+			expected:    []int{},
+		},
+		{
+			description: "Test: coins are listed largest first",
+			breakdown:   map[int]int{5: 1, 100: 1, 20: 2},
+			expected:    []int{100, 20, 20, 5},
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equalf(t, test.expected, change.Coins(test.breakdown), test.description)
+	}
+}
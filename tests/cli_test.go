@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"mvpmatch/cli"
+	"mvpmatch/config"
+	"mvpmatch/database"
+	"mvpmatch/repository"
+	"path/filepath"
+	"testing"
+)
+
+func TestCommands(t *testing.T) {
+
+	//point the commands at a fresh SQLite database
+	settings := config.Database
+	t.Cleanup(func() { config.Database = settings })
+	config.Database.Driver = database.SQLite
+	config.Database.Path = filepath.Join(t.TempDir(), "cli.db")
+
+	tests := []struct {
+		description  string   // description of the test case
+		args         []string // command line after the binary name
+		expectedCode int      // expected exit code
+	}{
+		{
+			description:  "Test: unknown command, get exit code 2",
+			args:         []string{"launch"},
+			expectedCode: 2,
+		},
+		{
+			description:  "Test: seed before migrating, get exit code 1",
+			args:         []string{"seed"},
+			expectedCode: 1,
+		},
+		{
+			description:  "Test: migrate without a direction, get exit code 2",
+			args:         []string{"migrate"},
+			expectedCode: 2,
+		},
+		{
+			description:  "Test: migrate up, get exit code 0",
+			args:         []string{"migrate", "up"},
+			expectedCode: 0,
+		},
+		{
+			description:  "Test: migration status, get exit code 0",
+			args:         []string{"migrate", "status"},
+			expectedCode: 0,
+		},
+		{
+			description:  "Test: seed with demo data, get exit code 0",
+			args:         []string{"seed"},
+			expectedCode: 0,
+		},
+		{
+			description:  "Test: seed again, get exit code 0",
+			args:         []string{"seed"},
+			expectedCode: 0,
+		},
+		{
+			description:  "Test: create an admin, get exit code 0",
+			args:         []string{"create-user", "--username=root", "--password=secret", "--role=admin"},
+			expectedCode: 0,
+		},
+		{
+			description:  "Test: create a user with a taken name, get exit code 1",
+			args:         []string{"create-user", "--username=root", "--password=secret", "--role=buyer"},
+			expectedCode: 1,
+		},
+		{
+			description:  "Test: create a user with an unknown role, get exit code 1",
+			args:         []string{"create-user", "--username=guest", "--password=secret", "--role=guest"},
+			expectedCode: 1,
+		},
+		{
+			description:  "Test: create a user without a password, get exit code 2",
+			args:         []string{"create-user", "--username=guest", "--role=buyer"},
+			expectedCode: 2,
+		},
+		{
+			description:  "Test: load coins, get exit code 0",
+			args:         []string{"coins", "load", "5=10", "100=2"},
+			expectedCode: 0,
+		},
+		{
+			description:  "Test: load a coin the machine does not accept, get exit code 1",
+			args:         []string{"coins", "load", "3=10"},
+			expectedCode: 1,
+		},
+		{
+			description:  "Test: load coins into a missing machine, get exit code 1",
+			args:         []string{"coins", "load", "--machine=99", "5=10"},
+			expectedCode: 1,
+		},
+		{
+			description:  "Test: load a malformed count, get exit code 1",
+			args:         []string{"coins", "load", "5=many"},
+			expectedCode: 1,
+		},
+		{
+			description:  "Test: rebuild the coins from their history, get exit code 0",
+			args:         []string{"coins", "rebuild"},
+			expectedCode: 0,
+		},
+		{
+			description:  "Test: rebuild with arguments, get exit code 2",
+			args:         []string{"coins", "rebuild", "5=10"},
+			expectedCode: 2,
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equalf(t, test.expectedCode, cli.Run(test.args), test.description)
+	}
+
+	db, err := database.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := repository.NewGorm(db)
+
+	admin, err := store.Users().FindByUsername("root")
+	assert.Equalf(t, nil, err, "Test: the admin exists")
+	assert.Equalf(t, config.Role.Admin, admin.Role.Name, "Test: the admin has the admin role")
+
+	_, err = store.Users().FindByUsername("demo_buyer")
+	assert.Equalf(t, nil, err, "Test: the demo buyer exists")
+
+	machine, _ := store.Machines().Default()
+	products, _ := store.Products().List(machine.ID)
+	assert.Equalf(t, 4, len(products), "Test: seeding twice adds the demo products once")
+
+	held, _ := store.Coins().Lock(machine.ID, []int{5, 100})
+	counts := map[int]int{}
+	for _, coin := range held {
+		counts[coin.Denomination] = coin.Count
+	}
+	assert.Equalf(t, 30, counts[5], "Test: loaded coins add to the float and survive a rebuild")
+	assert.Equalf(t, 22, counts[100], "Test: loaded coins add to the float and survive a rebuild")
+}
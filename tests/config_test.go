@@ -0,0 +1,146 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"mvpmatch/config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadConfig(t *testing.T) {
+
+	tests := []struct {
+		description string            // description of the test case
+		file        string            // name of the config file, empty for none
+		content     string            // content of the config file
+		env         map[string]string // environment set for the test case
+		expectedErr bool              // expected a config error
+		check       func(t *testing.T, c config.Config)
+	}{
+		{
+			description: "Test: environment only, get the defaults",
+			check: func(t *testing.T, c config.Config) {
+				assert.Equalf(t, "3000", c.App.Port, "Test: default port")
+				assert.Equalf(t, "mysql", c.Database.Driver, "Test: default driver")
+				assert.Equalf(t, "localhost:6379", c.Redis.Addr, "Test: default redis")
+			},
+		},
+		{
+			description: "Test: read settings from a .env file",
+			file:        ".env",
+			content:     "# local settings\nexport Port=8080\nDBDriver=sqlite\nDBPath=\"local.db\"\nRedisDB=2\n",
+			check: func(t *testing.T, c config.Config) {
+				assert.Equalf(t, "8080", c.App.Port, "Test: port from file")
+				assert.Equalf(t, "sqlite", c.Database.Driver, "Test: driver from file")
+				assert.Equalf(t, "local.db", c.Database.Path, "Test: quotes are dropped")
+				assert.Equalf(t, 2, c.Redis.DB, "Test: redis db from file")
+			},
+		},
+		{
+			description: "Test: read settings from a YAML file",
+			file:        "config.yaml",
+			content:     "Port: 9000\nRedisAddr: redis:6379\nRedisPassword: secret\n",
+			check: func(t *testing.T, c config.Config) {
+				assert.Equalf(t, "9000", c.App.Port, "Test: port from file")
+				assert.Equalf(t, "redis:6379", c.Redis.Addr, "Test: redis from file")
+				assert.Equalf(t, "secret", c.Redis.Password, "Test: redis password from file")
+			},
+		},
+		{
+			description: "Test: the environment wins over the file",
+			file:        ".env",
+			content:     "Port=8080\n",
+			env:         map[string]string{"Port": "7000"},
+			check: func(t *testing.T, c config.Config) {
+				assert.Equalf(t, "7000", c.App.Port, "Test: port from environment")
+			},
+		},
+		{
+			description: "Test: token lifetimes per role from a YAML file",
+			file:        "config.yaml",
+			content:     "TokenLifetime: 30m\nBuyerTokenLifetime: 8h\n",
+			check: func(t *testing.T, c config.Config) {
+				assert.Equalf(t, 30*time.Minute, c.Token.Lifetime, "Test: default token lifetime from file")
+				assert.Equalf(t, 8*time.Hour, c.Token.BuyerLifetime, "Test: buyer token lifetime from file")
+				assert.Equalf(t, time.Duration(0), c.Token.SellerLifetime, "Test: seller falls back to the default")
+			},
+		},
+		{
+			description: "Test: token lifetime is not positive, get an error",
+			env:         map[string]string{"TokenLifetime": "0s"},
+			expectedErr: true,
+		},
+		{
+			description: "Test: token outlasts the session, get an error",
+			env:         map[string]string{"AdminTokenLifetime": "1000h"},
+			expectedErr: true,
+		},
+		{
+			description: "Test: missing JWTKeyFile, get an error",
+			env:         map[string]string{"JWTKeyFile": ""},
+			expectedErr: true,
+		},
+		{
+			description: "Test: mysql without DBPassword, get an error",
+			env:         map[string]string{"DBDriver": "mysql", "DBPassword": ""},
+			expectedErr: true,
+		},
+		{
+			description: "Test: sqlite without DBPassword",
+			env:         map[string]string{"DBDriver": "sqlite", "DBPassword": ""},
+		},
+		{
+			description: "Test: unknown driver, get an error",
+			env:         map[string]string{"DBDriver": "postgres"},
+			expectedErr: true,
+		},
+		{
+			description: "Test: port is not a number, get an error",
+			env:         map[string]string{"Port": "http"},
+			expectedErr: true,
+		},
+		{
+			description: "Test: redis db is not a number, get an error",
+			env:         map[string]string{"RedisDB": "first"},
+			expectedErr: true,
+		},
+		{
+			description: "Test: malformed .env line, get an error",
+			file:        ".env",
+			content:     "Port\n",
+			expectedErr: true,
+		},
+		{
+			description: "Test: config file does not exist, get an error",
+			file:        "missing.env",
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+
+			var file string
+			if test.file != "" {
+				file = filepath.Join(t.TempDir(), test.file)
+				if test.content != "" {
+					if err := os.WriteFile(file, []byte(test.content), 0600); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			c, err := config.Read(file)
+
+			assert.Equalf(t, test.expectedErr, err != nil, test.description)
+			if err == nil && test.check != nil {
+				test.check(t, c)
+			}
+		})
+	}
+}
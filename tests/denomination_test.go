@@ -0,0 +1,215 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"mvpmatch/audit"
+	"mvpmatch/config"
+	"mvpmatch/models"
+	"mvpmatch/repository"
+	"mvpmatch/routes"
+	"mvpmatch/tokens"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDenominations(t *testing.T) {
+	t.Run("memory", func(t *testing.T) { denominations(t, newFixture(t)) })
+	t.Run("sqlite", func(t *testing.T) { denominations(t, newSQLiteFixture(t)) })
+}
+
+// denominations has an admin add and remove the coins of two machines and checks
+// a machine never drops the coin its products are priced in, nor its last coin.
+func denominations(t *testing.T, f fixture) {
+
+	adminRole, _ := f.store.Roles().FindByName(config.Role.Admin)
+	moderator := models.User{Username: "moderator", RoleID: adminRole.ID}
+	if err := f.store.Users().Create(&moderator); err != nil {
+		t.Fatal(err)
+	}
+
+	//the kiosk takes 5 and 10 and sells gum at 15, which needs the 5
+	kiosk := models.Machine{Name: "kiosk"}
+	if err := f.store.Machines().Create(&kiosk); err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []int{5, 10} {
+		if err := f.store.Coins().AddDenomination(kiosk.ID, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gum := models.Product{MachineID: kiosk.ID, AmountAvailable: 5, Cost: 15, ProductName: "gum", SellerID: f.seller.ID}
+	if err := f.store.Products().Create(&gum); err != nil {
+		t.Fatal(err)
+	}
+
+	kioskRoute := fmt.Sprintf("/v1/machines/%d/denominations", kiosk.ID)
+
+	tests := []struct {
+		description   string      // description of the test case
+		method        string      // http method of the step
+		route         string      // route path to test
+		as            string      // name of the token making the request
+		payload       interface{} // request body
+		expectedCode  int         // expected HTTP status code
+		machineID     uint        // machine whose coins are checked after the step
+		expectedCoins []int       // expected coins the machine accepts after the step
+	}{
+		{
+			description:   "Test: list the coins of the default machine, get HTTP status 200",
+			method:        http.MethodGet,
+			route:         "/v1/denominations",
+			expectedCode:  200,
+			machineID:     f.machine.ID,
+			expectedCoins: []int{5, 10, 20, 50, 100},
+		},
+		{
+			description:   "Test: a buyer adds a coin, get HTTP status 401",
+			method:        http.MethodPost,
+			route:         "/v1/denominations",
+			as:            "buyer",
+			payload:       fiber.Map{"value": 200},
+			expectedCode:  401,
+			machineID:     f.machine.ID,
+			expectedCoins: []int{5, 10, 20, 50, 100},
+		},
+		{
+			description:   "Test: add a coin without a value, get HTTP status 400",
+			method:        http.MethodPost,
+			route:         "/v1/denominations",
+			as:            "admin",
+			payload:       fiber.Map{"value": 0},
+			expectedCode:  400,
+			machineID:     f.machine.ID,
+			expectedCoins: []int{5, 10, 20, 50, 100},
+		},
+		{
+			description:   "Test: add a coin already accepted, get HTTP status 400",
+			method:        http.MethodPost,
+			route:         "/v1/denominations",
+			as:            "admin",
+			payload:       fiber.Map{"value": 50},
+			expectedCode:  400,
+			machineID:     f.machine.ID,
+			expectedCoins: []int{5, 10, 20, 50, 100},
+		},
+		{
+			description:   "Test: add a coin, get HTTP status 201",
+			method:        http.MethodPost,
+			route:         "/v1/denominations",
+			as:            "admin",
+			payload:       fiber.Map{"value": 200},
+			expectedCode:  201,
+			machineID:     f.machine.ID,
+			expectedCoins: []int{5, 10, 20, 50, 100, 200},
+		},
+		{
+			description:   "Test: add a coin to a machine that does not exist, get HTTP status 404",
+			method:        http.MethodPost,
+			route:         "/v1/machines/9999/denominations",
+			as:            "admin",
+			payload:       fiber.Map{"value": 200},
+			expectedCode:  404,
+			machineID:     kiosk.ID,
+			expectedCoins: []int{5, 10},
+		},
+		{
+			description:   "Test: remove a coin that is not accepted, get HTTP status 400",
+			method:        http.MethodDelete,
+			route:         "/v1/denominations",
+			as:            "admin",
+			payload:       fiber.Map{"value": 7},
+			expectedCode:  400,
+			machineID:     f.machine.ID,
+			expectedCoins: []int{5, 10, 20, 50, 100, 200},
+		},
+		{
+			description:   "Test: remove the smallest coin while products are priced in the next one, get HTTP status 200",
+			method:        http.MethodDelete,
+			route:         "/v1/denominations",
+			as:            "admin",
+			payload:       fiber.Map{"value": 5},
+			expectedCode:  200,
+			machineID:     f.machine.ID,
+			expectedCoins: []int{10, 20, 50, 100, 200},
+		},
+		{
+			description:   "Test: remove the smallest coin a product needs, get HTTP status 400",
+			method:        http.MethodDelete,
+			route:         kioskRoute,
+			as:            "admin",
+			payload:       fiber.Map{"value": 5},
+			expectedCode:  400,
+			machineID:     kiosk.ID,
+			expectedCoins: []int{5, 10},
+		},
+		{
+			description:   "Test: remove a coin larger than the smallest, get HTTP status 200",
+			method:        http.MethodDelete,
+			route:         kioskRoute,
+			as:            "admin",
+			payload:       fiber.Map{"value": 10},
+			expectedCode:  200,
+			machineID:     kiosk.ID,
+			expectedCoins: []int{5},
+		},
+		{
+			description:   "Test: remove the last coin, get HTTP status 400",
+			method:        http.MethodDelete,
+			route:         kioskRoute,
+			as:            "admin",
+			payload:       fiber.Map{"value": 5},
+			expectedCode:  400,
+			machineID:     kiosk.ID,
+			expectedCoins: []int{5},
+		},
+	}
+
+	// Define Fiber app.
+	app := fiber.New()
+	routes.Routes(app, f.store, tokens.NewMemory())
+
+	logins := map[string]string{
+		"admin": mintToken(t, moderator.ID, config.Role.Admin),
+		"buyer": mintToken(t, f.buyer.ID, config.Role.Buyer),
+	}
+
+	// Run every step in order, each one builds on the ones before it
+	for _, test := range tests {
+		var body io.Reader
+		if test.payload != nil {
+			payload, err := json.Marshal(test.payload)
+			if err != nil {
+				panic(err)
+			}
+			body = bytes.NewReader(payload)
+		}
+
+		req := httptest.NewRequest(test.method, test.route, body)
+		req.Header.Set("Content-Type", "application/json")
+		if test.as != "" {
+			req.Header.Set("Authorization", "Bearer "+logins[test.as])
+		}
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Verify, if the status code is as expected
+		assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+
+		accepted, _ := f.store.Coins().Denominations(test.machineID)
+		assert.Equalf(t, test.expectedCoins, accepted, test.description)
+	}
+
+	added, _ := f.store.AuditLogs().List(repository.AuditFilter{Action: audit.AddDenomination})
+	assert.Equalf(t, 1, len(added), "Test: every added coin is audited")
+	removed, _ := f.store.AuditLogs().List(repository.AuditFilter{Action: audit.DeleteDenomination})
+	assert.Equalf(t, 2, len(removed), "Test: every removed coin is audited, rejected ones are not")
+}
@@ -0,0 +1,228 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+	"mvpmatch/config"
+	"mvpmatch/handlers"
+	"mvpmatch/inventory"
+	"mvpmatch/middleware"
+	"mvpmatch/models"
+	"mvpmatch/repository"
+	"mvpmatch/tokens"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestSQLiteFlow signs a buyer up next to a seller against a fresh SQLite database
+// and walks them through stocking, paying and buying over HTTP.
+func TestSQLiteFlow(t *testing.T) {
+
+	f := newSQLiteFixture(t)
+
+	//give the machine coins to pay change with
+	float := map[int]int{5: 5, 10: 5, 20: 5}
+	err := inventory.Apply(f.store, models.CoinMovement{Kind: inventory.Restock, MachineID: f.machine.ID}, float)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sellerRole, _ := f.store.Roles().FindByName(config.Role.Seller)
+	buyerRole, _ := f.store.Roles().FindByName(config.Role.Buyer)
+	adminRole, _ := f.store.Roles().FindByName(config.Role.Admin)
+
+	//sellers cannot sign themselves up, they get an account like create-user hands out
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := models.User{Username: "alice", Password: string(hash), RoleID: sellerRole.ID}
+	if err := f.store.Users().Create(&alice); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		description  string      // description of the test case
+		method       string      // http method of the step
+		route        string      // route path to test
+		as           string      // name of the logged in user making the request
+		payload      interface{} // request body
+		expectedCode int         // expected HTTP status code
+	}{
+		{
+			description:  "Test: sign up as a seller, get HTTP status 400",
+			method:       http.MethodPost,
+			route:        "/user",
+			payload:      fiber.Map{"username": "mallet", "password": "secret", "role_id": sellerRole.ID},
+			expectedCode: 400,
+		},
+		{
+			description:  "Test: sign up a buyer, get HTTP status 201",
+			method:       http.MethodPost,
+			route:        "/user",
+			payload:      fiber.Map{"username": "bob", "password": "secret", "role_id": buyerRole.ID},
+			expectedCode: 201,
+		},
+		{
+			description:  "Test: sign up as an admin, get HTTP status 400",
+			method:       http.MethodPost,
+			route:        "/user",
+			payload:      fiber.Map{"username": "mallory", "password": "secret", "role_id": adminRole.ID},
+			expectedCode: 400,
+		},
+		{
+			description:  "Test: log the seller in, get HTTP status 200",
+			method:       http.MethodPost,
+			route:        "/login",
+			payload:      fiber.Map{"username": "alice", "password": "secret"},
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: log the buyer in, get HTTP status 200",
+			method:       http.MethodPost,
+			route:        "/login",
+			payload:      fiber.Map{"username": "bob", "password": "secret"},
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: log in with a wrong password, get HTTP status 401",
+			method:       http.MethodPost,
+			route:        "/login",
+			payload:      fiber.Map{"username": "bob", "password": "wrong"},
+			expectedCode: 401,
+		},
+		{
+			description:  "Test: seller adds a product, get HTTP status 201",
+			method:       http.MethodPost,
+			route:        "/product",
+			as:           "alice",
+			payload:      fiber.Map{"product_name": "cola", "cost": 35, "amount_available": 5},
+			expectedCode: 201,
+		},
+		{
+			description:  "Test: buyer deposits a coin, get HTTP status 200",
+			method:       http.MethodPost,
+			route:        "/deposit",
+			as:           "bob",
+			payload:      fiber.Map{"coin": 50},
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: buyer deposits another coin, get HTTP status 200",
+			method:       http.MethodPost,
+			route:        "/deposit",
+			as:           "bob",
+			payload:      fiber.Map{"coin": 50},
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: buy more than is in stock, get HTTP status 400",
+			method:       http.MethodPost,
+			route:        "/buy",
+			as:           "bob",
+			payload:      fiber.Map{"product_id": f.product.ID, "amount": 100},
+			expectedCode: 400,
+		},
+		{
+			description:  "Test: buy two units and take the change, get HTTP status 200",
+			method:       http.MethodPost,
+			route:        "/buy",
+			as:           "bob",
+			payload:      fiber.Map{"product_id": f.product.ID, "amount": 2},
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: list the buyer's orders, get HTTP status 200",
+			method:       http.MethodGet,
+			route:        "/orders",
+			as:           "bob",
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: reset the spent deposit, get HTTP status 200",
+			method:       http.MethodPatch,
+			route:        "/deposit/reset",
+			as:           "bob",
+			expectedCode: 200,
+		},
+	}
+
+	// Define Fiber app.
+	app := fiber.New()
+	h := handlers.New(f.store, nil)
+	jwtToken := middleware.JWT(tokens.Keys(), nil)
+	app.Post("user", h.AddUser)
+	app.Post("login", h.Login)
+	app.Post("product", jwtToken, h.AddProduct)
+	app.Post("deposit", jwtToken, h.Deposit)
+	app.Post("buy", jwtToken, h.Buy)
+	app.Get("orders", jwtToken, h.GetOrders)
+	app.Patch("deposit/reset", jwtToken, h.ResetDeposit)
+
+	logins := map[string]string{}
+
+	// Run every step in order, each one builds on the ones before it
+	for _, test := range tests {
+		var body io.Reader
+		if test.payload != nil {
+			payload, err := json.Marshal(test.payload)
+			if err != nil {
+				panic(err)
+			}
+			body = bytes.NewReader(payload)
+		}
+
+		req := httptest.NewRequest(test.method, test.route, body)
+		req.Header.Set("Content-Type", "application/json")
+		if test.as != "" {
+			req.Header.Set("Authorization", "Bearer "+logins[test.as])
+		}
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Verify, if the status code is as expected
+		assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+
+		//keep the token of every successful login for the steps after it
+		if test.route == "/login" && resp.StatusCode == 200 {
+			var response struct {
+				Data struct {
+					Username string `json:"username"`
+					Token    string `json:"token"`
+				} `json:"data"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			logins[response.Data.Username] = response.Data.Token
+		}
+	}
+
+	buyer, err := f.store.Users().FindByUsername("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	product, err := f.store.Products().Find(f.product.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders, err := f.store.Orders().List(repository.OrderFilter{UserID: buyer.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equalf(t, 0, buyer.Deposit, "Test: the change is paid out with the purchase")
+	assert.Equalf(t, f.product.AmountAvailable-2, product.AmountAvailable, "Test: two units leave the stock")
+	assert.Equalf(t, 1, len(orders), "Test: one order is recorded")
+	if len(orders) == 1 {
+		assert.Equalf(t, 2*f.product.Cost, orders[0].TotalCost, "Test: the order is charged for two units")
+	}
+}
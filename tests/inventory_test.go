@@ -0,0 +1,138 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"mvpmatch/inventory"
+	"mvpmatch/models"
+	"mvpmatch/repository"
+	"testing"
+)
+
+func TestRebuild(t *testing.T) {
+	t.Run("memory", func(t *testing.T) { rebuildCoins(t, newFixture(t)) })
+	t.Run("sqlite", func(t *testing.T) { rebuildCoins(t, newSQLiteFixture(t)) })
+}
+
+// rebuildCoins records a known coin history on a second machine, corrupts the counts
+// and checks rebuilding recounts every coin from the history alone.
+func rebuildCoins(t *testing.T, f fixture) {
+
+	kiosk := models.Machine{Name: "kiosk"}
+	if err := f.store.Machines().Create(&kiosk); err != nil {
+		t.Fatal(err)
+	}
+
+	history := []struct {
+		kind   string
+		deltas map[int]int
+	}{
+		{kind: inventory.Restock, deltas: map[int]int{5: 4, 10: 4, 50: 2}},
+		{kind: inventory.Deposit, deltas: map[int]int{100: 1, 5: 1}},
+		{kind: inventory.Change, deltas: map[int]int{5: -4, 50: -1}},
+		{kind: inventory.Withdrawal, deltas: map[int]int{50: -1}},
+	}
+	for _, step := range history {
+		movement := models.CoinMovement{Kind: step.kind, MachineID: kiosk.ID}
+		if err := inventory.Apply(f.store, movement, step.deltas); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//a movement whose coin row was lost, and counts that drifted from the ledger
+	lost := models.CoinMovement{Kind: inventory.Restock, MachineID: kiosk.ID, Denomination: 20, Delta: 3}
+	if err := f.store.Coins().Record(&lost); err != nil {
+		t.Fatal(err)
+	}
+	f.store.Coins().SetCount(kiosk.ID, 5, 99)
+	f.store.Coins().SetCount(kiosk.ID, 10, 0)
+	stray := models.Coin{MachineID: kiosk.ID, Denomination: 200, Count: 7}
+	if err := f.store.Coins().Create(&stray); err != nil {
+		t.Fatal(err)
+	}
+
+	before := coinCounts(f.store, f.machine.ID)
+
+	var rebuilt []models.Coin
+	err := f.store.Transaction(func(tx repository.Store) error {
+		var err error
+		rebuilt, err = inventory.Rebuild(tx)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := map[int]int{}
+	for _, coin := range rebuilt {
+		if coin.MachineID == kiosk.ID {
+			counts[coin.Denomination] = coin.Count
+		}
+	}
+
+	tests := []struct {
+		description   string // description of the test case
+		denomination  int    // coin of the kiosk to check
+		expectedCount int    // expected count after the rebuild
+	}{
+		{
+			description:   "Test: a coin taken in and paid out is recounted",
+			denomination:  5,
+			expectedCount: 1,
+		},
+		{
+			description:   "Test: a coin that drifted to zero is recounted",
+			denomination:  10,
+			expectedCount: 4,
+		},
+		{
+			description:   "Test: a coin row lost from the inventory is created again",
+			denomination:  20,
+			expectedCount: 3,
+		},
+		{
+			description:   "Test: a coin paid out in full is empty",
+			denomination:  50,
+			expectedCount: 0,
+		},
+		{
+			description:   "Test: a deposited coin is recounted",
+			denomination:  100,
+			expectedCount: 1,
+		},
+		{
+			description:   "Test: a coin without any history holds nothing",
+			denomination:  200,
+			expectedCount: 0,
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equalf(t, test.expectedCount, counts[test.denomination], test.description)
+	}
+
+	assert.Equalf(t, before, coinCounts(f.store, f.machine.ID), "Test: a machine whose counts match its history keeps them")
+
+	//a history that pays out coins it never took in cannot be rebuilt
+	overdrawn := models.CoinMovement{Kind: inventory.Withdrawal, MachineID: kiosk.ID, Denomination: 100, Delta: -2}
+	if err := f.store.Coins().Record(&overdrawn); err != nil {
+		t.Fatal(err)
+	}
+	err = f.store.Transaction(func(tx repository.Store) error {
+		_, err := inventory.Rebuild(tx)
+		return err
+	})
+	assert.Equalf(t, true, err != nil, "Test: an overdrawn history is rejected")
+	held, _ := f.store.Coins().Lock(kiosk.ID, []int{100})
+	assert.Equalf(t, 1, held[0].Count, "Test: a rejected rebuild changes nothing")
+}
+
+// coinCounts returns the coins a machine holds keyed by denomination.
+func coinCounts(store repository.Store, machineID uint) map[int]int {
+	accepted, _ := store.Coins().Denominations(machineID)
+	held, _ := store.Coins().Lock(machineID, accepted)
+	counts := map[int]int{}
+	for _, coin := range held {
+		counts[coin.Denomination] = coin.Count
+	}
+	return counts
+}
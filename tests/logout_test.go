@@ -0,0 +1,222 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"mvpmatch/config"
+	"mvpmatch/routes"
+	"mvpmatch/tokens"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestLogout logs one buyer in on several devices and checks which of the tokens
+// each kind of logout revokes.
+func TestLogout(t *testing.T) {
+
+	f := newFixture(t)
+	buyerRole, _ := f.store.Roles().FindByName(config.Role.Buyer)
+
+	//a token from before tokens carried an ID
+	legacyToken, err := tokens.Keys().Sign(jwt.MapClaims{
+		"uid":  f.buyer.ID,
+		"role": config.Role.Buyer,
+		"exp":  time.Now().Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		description  string      // description of the test case
+		method       string      // http method of the step
+		route        string      // route path to test
+		as           string      // name of the session making the request
+		save         string      // name to keep the token of a login under
+		payload      interface{} // request body
+		expectedCode int         // expected HTTP status code
+	}{
+		{
+			description:  "Test: sign up a buyer, get HTTP status 201",
+			method:       http.MethodPost,
+			route:        "/v1/user",
+			payload:      fiber.Map{"username": "carol", "password": "secret", "role_id": buyerRole.ID},
+			expectedCode: 201,
+		},
+		{
+			description:  "Test: log in on a phone, get HTTP status 200",
+			method:       http.MethodPost,
+			route:        "/v1/login",
+			save:         "phone",
+			payload:      fiber.Map{"username": "carol", "password": "secret"},
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: log in on a laptop, get HTTP status 200",
+			method:       http.MethodPost,
+			route:        "/v1/login",
+			save:         "laptop",
+			payload:      fiber.Map{"username": "carol", "password": "secret"},
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: log the phone out, get HTTP status 200",
+			method:       http.MethodPost,
+			route:        "/v1/logout",
+			as:           "phone",
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: use the logged out phone, get HTTP status 401",
+			method:       http.MethodGet,
+			route:        "/v1/wallet",
+			as:           "phone",
+			expectedCode: 401,
+		},
+		{
+			description:  "Test: use the logged out phone on a route without a role, get HTTP status 401",
+			method:       http.MethodPatch,
+			route:        "/v1/deposit/reset",
+			as:           "phone",
+			expectedCode: 401,
+		},
+		{
+			description:  "Test: the laptop stays logged in, get HTTP status 200",
+			method:       http.MethodGet,
+			route:        "/v1/wallet",
+			as:           "laptop",
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: log in on a tablet, get HTTP status 200",
+			method:       http.MethodPost,
+			route:        "/v1/login",
+			save:         "tablet",
+			payload:      fiber.Map{"username": "carol", "password": "secret"},
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: log the laptop out everywhere, get HTTP status 200",
+			method:       http.MethodPost,
+			route:        "/v1/logout?everywhere=true",
+			as:           "laptop",
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: use the laptop, get HTTP status 401",
+			method:       http.MethodGet,
+			route:        "/v1/wallet",
+			as:           "laptop",
+			expectedCode: 401,
+		},
+		{
+			description:  "Test: use the tablet logged in before, get HTTP status 401",
+			method:       http.MethodGet,
+			route:        "/v1/wallet",
+			as:           "tablet",
+			expectedCode: 401,
+		},
+		{
+			description:  "Test: log in again, get HTTP status 200",
+			method:       http.MethodPost,
+			route:        "/v1/login",
+			save:         "phone",
+			payload:      fiber.Map{"username": "carol", "password": "secret"},
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: use the new login, get HTTP status 200",
+			method:       http.MethodGet,
+			route:        "/v1/wallet",
+			as:           "phone",
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: use a token without an ID, get HTTP status 401",
+			method:       http.MethodGet,
+			route:        "/v1/wallet",
+			as:           "legacy",
+			expectedCode: 401,
+		},
+	}
+
+	// Define Fiber app.
+	app := fiber.New()
+	routes.Routes(app, f.store, tokens.NewMemory())
+
+	sessions := map[string]string{"legacy": legacyToken}
+
+	// Run every step in order, each one builds on the ones before it
+	for _, test := range tests {
+		var body io.Reader
+		if test.payload != nil {
+			payload, err := json.Marshal(test.payload)
+			if err != nil {
+				panic(err)
+			}
+			body = bytes.NewReader(payload)
+		}
+
+		req := httptest.NewRequest(test.method, test.route, body)
+		req.Header.Set("Content-Type", "application/json")
+		if test.as != "" {
+			req.Header.Set("Authorization", "Bearer "+sessions[test.as])
+		}
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Verify, if the status code is as expected
+		assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+
+		if test.save != "" && resp.StatusCode == 200 {
+			var response struct {
+				Data struct {
+					Token string `json:"token"`
+				} `json:"data"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			sessions[test.save] = response.Data.Token
+		}
+	}
+}
+
+func TestRevocationExpiry(t *testing.T) {
+
+	ctx := context.Background()
+	list := tokens.NewMemory()
+
+	assert.Equalf(t, nil, list.Revoke(ctx, "expired", time.Now().Add(-time.Minute)), "Test: revoke an expired token")
+	revoked, _ := list.IsRevoked(ctx, "expired", 1, 0, time.Now())
+	assert.Equalf(t, false, revoked, "Test: an expired token is not kept")
+
+	assert.Equalf(t, nil, list.Revoke(ctx, "live", time.Now().Add(time.Minute)), "Test: revoke a live token")
+	revoked, _ = list.IsRevoked(ctx, "live", 1, 0, time.Now())
+	assert.Equalf(t, true, revoked, "Test: a live token is revoked")
+
+	cutoff := time.Now()
+	assert.Equalf(t, nil, list.RevokeBefore(ctx, 2, cutoff), "Test: log a user out everywhere")
+	revoked, _ = list.IsRevoked(ctx, "older", 2, 0, cutoff.Add(-time.Second))
+	assert.Equalf(t, true, revoked, "Test: tokens issued before the cutoff are revoked")
+	revoked, _ = list.IsRevoked(ctx, "newer", 2, 0, cutoff.Add(time.Second))
+	assert.Equalf(t, false, revoked, "Test: tokens issued after the cutoff are not")
+	revoked, _ = list.IsRevoked(ctx, "other", 3, 0, cutoff.Add(-time.Second))
+	assert.Equalf(t, false, revoked, "Test: other users keep their tokens")
+
+	assert.Equalf(t, nil, list.RevokeSession(ctx, 4), "Test: end a session")
+	revoked, _ = list.IsRevoked(ctx, "session", 5, 4, time.Now())
+	assert.Equalf(t, true, revoked, "Test: tokens of an ended session are revoked")
+	revoked, _ = list.IsRevoked(ctx, "unbound", 5, 0, time.Now())
+	assert.Equalf(t, false, revoked, "Test: tokens of no session are not")
+}
@@ -0,0 +1,212 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"mvpmatch/config"
+	"mvpmatch/inventory"
+	"mvpmatch/models"
+	"mvpmatch/routes"
+	"mvpmatch/tokens"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMachines(t *testing.T) {
+	t.Run("memory", func(t *testing.T) { machines(t, newFixture(t)) })
+	t.Run("sqlite", func(t *testing.T) { machines(t, newSQLiteFixture(t)) })
+}
+
+// machines has a buyer deposit, buy and reset across two machines and checks each
+// machine keeps its own coins and products, and a deposit stays in the machine it
+// was made in until it is spent or reset.
+func machines(t *testing.T, f fixture) {
+
+	north := models.Machine{Name: "north"}
+	south := models.Machine{Name: "south"}
+	for _, machine := range []*models.Machine{&north, &south} {
+		if err := f.store.Machines().Create(machine); err != nil {
+			t.Fatal(err)
+		}
+		for _, value := range inventory.DefaultDenominations {
+			if err := f.store.Coins().AddDenomination(machine.ID, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	chips := models.Product{MachineID: north.ID, AmountAvailable: 3, Cost: 20, ProductName: "chips", SellerID: f.seller.ID}
+	if err := f.store.Products().Create(&chips); err != nil {
+		t.Fatal(err)
+	}
+	tea := models.Product{MachineID: south.ID, AmountAvailable: 5, Cost: 10, ProductName: "tea", SellerID: f.seller.ID}
+	if err := f.store.Products().Create(&tea); err != nil {
+		t.Fatal(err)
+	}
+
+	route := func(machine models.Machine, path string) string {
+		return fmt.Sprintf("/v1/machines/%d/%s", machine.ID, path)
+	}
+
+	tests := []struct {
+		description     string      // description of the test case
+		method          string      // http method of the step
+		route           string      // route path to test
+		payload         interface{} // request body
+		expectedCode    int         // expected HTTP status code
+		expectedDeposit int         // expected deposit of the buyer after the step
+		expectedMachine uint        // expected machine holding the deposit, checked while there is one
+		expectedNorth   map[int]int // expected coins in the north machine after the step
+		expectedSouth   map[int]int // expected coins in the south machine after the step
+		expectedChips   int         // expected stock of the north product after the step
+		expectedTea     int         // expected stock of the south product after the step
+	}{
+		{
+			description:     "Test: deposit in the north machine, get HTTP status 200",
+			method:          http.MethodPost,
+			route:           route(north, "deposit"),
+			payload:         fiber.Map{"coin": 20},
+			expectedCode:    200,
+			expectedDeposit: 20,
+			expectedMachine: north.ID,
+			expectedNorth:   map[int]int{20: 1},
+			expectedSouth:   map[int]int{},
+			expectedChips:   3,
+			expectedTea:     5,
+		},
+		{
+			description:     "Test: deposit in the south machine while the north one holds a deposit, get HTTP status 400",
+			method:          http.MethodPost,
+			route:           route(south, "deposit"),
+			payload:         fiber.Map{"coin": 10},
+			expectedCode:    400,
+			expectedDeposit: 20,
+			expectedMachine: north.ID,
+			expectedNorth:   map[int]int{20: 1},
+			expectedSouth:   map[int]int{},
+			expectedChips:   3,
+			expectedTea:     5,
+		},
+		{
+			description:     "Test: buy a product of the south machine from the north one, get HTTP status 400",
+			method:          http.MethodPost,
+			route:           route(north, "buy"),
+			payload:         fiber.Map{"product_id": tea.ID, "amount": 1},
+			expectedCode:    400,
+			expectedDeposit: 20,
+			expectedMachine: north.ID,
+			expectedNorth:   map[int]int{20: 1},
+			expectedSouth:   map[int]int{},
+			expectedChips:   3,
+			expectedTea:     5,
+		},
+		{
+			description:     "Test: reset the deposit from the south machine, get HTTP status 400",
+			method:          http.MethodPatch,
+			route:           route(south, "deposit/reset"),
+			expectedCode:    400,
+			expectedDeposit: 20,
+			expectedMachine: north.ID,
+			expectedNorth:   map[int]int{20: 1},
+			expectedSouth:   map[int]int{},
+			expectedChips:   3,
+			expectedTea:     5,
+		},
+		{
+			description:   "Test: spend the deposit in the north machine, get HTTP status 200",
+			method:        http.MethodPost,
+			route:         route(north, "buy"),
+			payload:       fiber.Map{"product_id": chips.ID, "amount": 1},
+			expectedCode:  200,
+			expectedNorth: map[int]int{20: 1},
+			expectedSouth: map[int]int{},
+			expectedChips: 2,
+			expectedTea:   5,
+		},
+		{
+			description:     "Test: deposit in the south machine once the deposit is spent, get HTTP status 200",
+			method:          http.MethodPost,
+			route:           route(south, "deposit"),
+			payload:         fiber.Map{"coin": 50},
+			expectedCode:    200,
+			expectedDeposit: 50,
+			expectedMachine: south.ID,
+			expectedNorth:   map[int]int{20: 1},
+			expectedSouth:   map[int]int{50: 1},
+			expectedChips:   2,
+			expectedTea:     5,
+		},
+		{
+			description:     "Test: buy when only the other machine holds the change, get HTTP status 400",
+			method:          http.MethodPost,
+			route:           route(south, "buy"),
+			payload:         fiber.Map{"product_id": tea.ID, "amount": 3},
+			expectedCode:    400,
+			expectedDeposit: 50,
+			expectedMachine: south.ID,
+			expectedNorth:   map[int]int{20: 1},
+			expectedSouth:   map[int]int{50: 1},
+			expectedChips:   2,
+			expectedTea:     5,
+		},
+		{
+			description:   "Test: buy with the exact deposit in the south machine, get HTTP status 200",
+			method:        http.MethodPost,
+			route:         route(south, "buy"),
+			payload:       fiber.Map{"product_id": tea.ID, "amount": 5},
+			expectedCode:  200,
+			expectedNorth: map[int]int{20: 1},
+			expectedSouth: map[int]int{50: 1},
+			expectedChips: 2,
+			expectedTea:   0,
+		},
+	}
+
+	// Define Fiber app.
+	app := fiber.New()
+	routes.Routes(app, f.store, tokens.NewMemory())
+	buyerToken := mintToken(t, f.buyer.ID, config.Role.Buyer)
+
+	// Run every step in order, each one builds on the ones before it
+	for _, test := range tests {
+		var body io.Reader
+		if test.payload != nil {
+			payload, err := json.Marshal(test.payload)
+			if err != nil {
+				panic(err)
+			}
+			body = bytes.NewReader(payload)
+		}
+
+		req := httptest.NewRequest(test.method, test.route, body)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+buyerToken)
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Verify, if the status code is as expected
+		assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+
+		buyer, _ := f.store.Users().Find(f.buyer.ID)
+		assert.Equalf(t, test.expectedDeposit, buyer.Deposit, test.description)
+		if test.expectedDeposit > 0 {
+			assert.Equalf(t, test.expectedMachine, buyer.DepositMachineID, test.description)
+		}
+
+		assert.Equalf(t, test.expectedNorth, coinCounts(f.store, north.ID), test.description)
+		assert.Equalf(t, test.expectedSouth, coinCounts(f.store, south.ID), test.description)
+
+		northProduct, _ := f.store.Products().Find(chips.ID)
+		southProduct, _ := f.store.Products().Find(tea.ID)
+		assert.Equalf(t, test.expectedChips, northProduct.AmountAvailable, test.description)
+		assert.Equalf(t, test.expectedTea, southProduct.AmountAvailable, test.description)
+	}
+}
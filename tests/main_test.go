@@ -0,0 +1,54 @@
+package tests
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"log"
+	"mvpmatch/config"
+	"mvpmatch/tokens"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "mvpmatch-keys")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	//tests sign their tokens with a key of their own
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	keyFile := filepath.Join(dir, "jwt.pem")
+	if err := writeKey(keyFile, key); err != nil {
+		log.Fatalln(err)
+	}
+
+	os.Setenv("JWTKeyFile", keyFile)
+	//the tests run on sqlite, the mysql default only has to be valid
+	os.Setenv("DBPassword", "secret")
+	if err := config.Load(""); err != nil {
+		log.Fatalln(err)
+	}
+	if err := tokens.LoadKeys(); err != nil {
+		log.Fatalln(err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// writeKey saves a private key as PKCS #8 PEM, the way openssl genpkey writes it.
+func writeKey(file string, key interface{}) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+}
@@ -0,0 +1,505 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"mvpmatch/database"
+	"mvpmatch/inventory"
+	"mvpmatch/models"
+	"mvpmatch/repository"
+	"mvpmatch/wallet"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openSQLite(t *testing.T) *gorm.DB {
+	db, err := database.Open(database.SQLite, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func TestMigrations(t *testing.T) {
+
+	db := openSQLite(t)
+
+	list, err := database.Migrations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range list {
+		assert.Equalf(t, false, item.Applied, "Test: a fresh database has nothing applied")
+	}
+
+	done, err := database.MigrateUp(db)
+	assert.Equalf(t, nil, err, "Test: migrate up on a fresh database")
+	assert.Equalf(t, len(list), len(done), "Test: every migration is applied")
+
+	done, err = database.MigrateUp(db)
+	assert.Equalf(t, nil, err, "Test: migrate up again")
+	assert.Equalf(t, 0, len(done), "Test: applied migrations are not run twice")
+
+	pending, _ := database.Pending(db)
+	assert.Equalf(t, 0, pending, "Test: nothing is pending after migrate up")
+
+	store := repository.NewGorm(db)
+	first := models.User{Username: "taken"}
+	if err := store.Users().Create(&first); err != nil {
+		t.Fatal(err)
+	}
+	second := models.User{Username: "taken"}
+	assert.Equalf(t, repository.ErrDuplicate, store.Users().Create(&second), "Test: usernames are unique")
+
+	assert.Equalf(t, nil, store.Users().Delete(first.ID), "Test: delete the user")
+	assert.Equalf(t, repository.ErrDuplicate, store.Users().Create(&second), "Test: a deleted user keeps the name")
+
+	product := models.Product{ProductName: "taken"}
+	if err := store.Products().Create(&product); err != nil {
+		t.Fatal(err)
+	}
+	other := models.Product{ProductName: "other"}
+	if err := store.Products().Create(&other); err != nil {
+		t.Fatal(err)
+	}
+	other.ProductName = "taken"
+	assert.Equalf(t, repository.ErrDuplicate, store.Products().Update(other), "Test: product names are unique")
+
+	//roll back to the unique indexes, every migration after them undoes all it did
+	later := len(list) - 1
+	done, err = database.MigrateDown(db, later-1)
+	assert.Equalf(t, nil, err, "Test: roll back the migrations after the indexes")
+	assert.Equalf(t, later-1, len(done), "Test: every migration after the indexes is rolled back")
+	assert.Equalf(t, false, db.Migrator().HasTable(&models.Session{}), "Test: the sessions table is dropped")
+	assert.Equalf(t, false, db.Migrator().HasTable(&models.AuditLog{}), "Test: the audit log is dropped")
+	assert.Equalf(t, false, db.Migrator().HasTable(&models.Permission{}), "Test: the permissions are dropped")
+	assert.Equalf(t, false, db.Migrator().HasColumn(&models.User{}, "SuspendedAt"), "Test: the suspension columns are dropped")
+	assert.Equalf(t, false, db.Migrator().HasColumn(&models.Role{}, "SelfAssignable"), "Test: the sign-up column is dropped")
+
+	repeat := func() error {
+		return db.Exec("INSERT INTO users (username, created_at, updated_at) VALUES ('taken', ?, ?)", time.Now(), time.Now()).Error
+	}
+	assert.Equalf(t, true, repeat() != nil, "Test: dropping columns keeps the unique index")
+
+	done, err = database.MigrateDown(db, 1)
+	assert.Equalf(t, nil, err, "Test: roll back the unique indexes")
+	assert.Equalf(t, 1, len(done), "Test: one migration is rolled back")
+
+	pending, _ = database.Pending(db)
+	assert.Equalf(t, later, pending, "Test: the rolled back migrations are pending")
+	assert.Equalf(t, nil, repeat(), "Test: usernames repeat without the index")
+
+	_, err = database.MigrateUp(db)
+	assert.Equalf(t, true, err != nil, "Test: the index cannot be added over repeated names")
+	pending, _ = database.Pending(db)
+	assert.Equalf(t, later, pending, "Test: a failed migration stays pending along with the ones after it")
+
+	done, err = database.MigrateDown(db, len(list))
+	assert.Equalf(t, nil, err, "Test: roll back everything")
+	assert.Equalf(t, 1, len(done), "Test: only applied migrations are rolled back")
+	assert.Equalf(t, false, db.Migrator().HasTable(&models.User{}), "Test: the tables are dropped")
+
+	done, err = database.MigrateUp(db)
+	assert.Equalf(t, nil, err, "Test: migrate up after rolling everything back")
+	assert.Equalf(t, len(list), len(done), "Test: every migration applies again")
+
+	_, err = database.MigrateDown(db, 0)
+	assert.Equalf(t, true, err != nil, "Test: steps must be at least one")
+}
+
+func TestMemoryDuplicates(t *testing.T) {
+
+	f := newFixture(t)
+
+	user := models.User{Username: f.buyer.Username}
+	assert.Equalf(t, repository.ErrDuplicate, f.store.Users().Create(&user), "Test: usernames are unique")
+	assert.Equalf(t, repository.ErrDuplicate, f.store.Users().UpdateProfile(f.seller.ID, f.buyer.Username, ""), "Test: renaming onto a taken username")
+	assert.Equalf(t, nil, f.store.Users().UpdateProfile(f.seller.ID, f.seller.Username, ""), "Test: keeping the own username")
+
+	product := models.Product{ProductName: f.product.ProductName}
+	assert.Equalf(t, repository.ErrDuplicate, f.store.Products().Create(&product), "Test: product names are unique")
+
+	assert.Equalf(t, repository.ErrDuplicate, f.store.Coins().AddDenomination(f.machine.ID, 5), "Test: a machine accepts a coin once")
+	if err := f.store.Coins().Add(f.machine.ID, 5, 1); err != nil {
+		t.Fatal(err)
+	}
+	coin := models.Coin{MachineID: f.machine.ID, Denomination: 5}
+	assert.Equalf(t, repository.ErrDuplicate, f.store.Coins().Create(&coin), "Test: a machine has one row per coin")
+
+	slot := models.Slot{MachineID: f.machine.ID, Code: "A1", Capacity: 5}
+	if err := f.store.Slots().Create(&slot); err != nil {
+		t.Fatal(err)
+	}
+	repeat := models.Slot{MachineID: f.machine.ID, Code: "A1", Capacity: 5}
+	assert.Equalf(t, repository.ErrDuplicate, f.store.Slots().Create(&repeat), "Test: slot codes are unique in a machine")
+	other := models.Slot{MachineID: f.machine.ID + 1, Code: "A1", Capacity: 5}
+	assert.Equalf(t, nil, f.store.Slots().Create(&other), "Test: another machine may use the same slot code")
+}
+
+func TestOpeningBalances(t *testing.T) {
+
+	db := openSQLite(t)
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+
+	//go back to before the opening balances, when deposits moved without ledger entries
+	migrateDownTo(t, db, 5)
+
+	store := repository.NewGorm(db)
+	legacy := models.User{Username: "legacy", Deposit: 85}
+	empty := models.User{Username: "empty"}
+	partial := models.User{Username: "partial", Deposit: 30}
+	spent := models.User{Username: "spent", Deposit: 0}
+	for _, user := range []*models.User{&legacy, &empty, &partial, &spent} {
+		if err := store.Users().Create(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//partial deposited 50 through the ledger and spent 20 without one,
+	//spent deposited 40 through the ledger and spent all of it without one
+	for _, entry := range []models.Wallet{
+		{UserID: partial.ID, Credit: 50, Balance: 50, Source: wallet.Deposit},
+		{UserID: spent.ID, Credit: 40, Balance: 40, Source: wallet.Deposit},
+	} {
+		if err := store.Wallets().Create(&entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mismatches, err := wallet.Reconcile(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equalf(t, 3, len(mismatches), "Test: deposits held before the ledger do not add up")
+
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+
+	mismatches, err = wallet.Reconcile(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equalf(t, 0, len(mismatches), "Test: the opening balances make every ledger add up")
+
+	tests := []struct {
+		description    string // description of the test case
+		userID         uint   // user the opening entry belongs to
+		expectedCount  int    // expected number of opening entries
+		expectedCredit int    // expected credit of the opening entry
+		expectedDebit  int    // expected debit of the opening entry
+		expectedAmount int    // expected balance after the opening entry
+	}{
+		{
+			description:    "Test: a deposit without any ledger is credited in full",
+			userID:         legacy.ID,
+			expectedCount:  1,
+			expectedCredit: 85,
+			expectedAmount: 85,
+		},
+		{
+			description:   "Test: a user without a deposit gets no entry",
+			userID:        empty.ID,
+			expectedCount: 0,
+		},
+		{
+			description:    "Test: spending outside the ledger is debited",
+			userID:         partial.ID,
+			expectedCount:  1,
+			expectedDebit:  20,
+			expectedAmount: 30,
+		},
+		{
+			description:    "Test: a spent deposit is debited to zero",
+			userID:         spent.ID,
+			expectedCount:  1,
+			expectedDebit:  40,
+			expectedAmount: 0,
+		},
+	}
+
+	for _, test := range tests {
+		var entries []models.Wallet
+		db.Where("user_id = ? AND source = ?", test.userID, wallet.Opening).Find(&entries)
+
+		if assert.Equalf(t, test.expectedCount, len(entries), test.description) && len(entries) == 1 {
+			assert.Equalf(t, test.expectedCredit, entries[0].Credit, test.description)
+			assert.Equalf(t, test.expectedDebit, entries[0].Debit, test.description)
+			assert.Equalf(t, test.expectedAmount, entries[0].Balance, test.description)
+		}
+	}
+
+	migrateDownTo(t, db, 5)
+	var count int64
+	db.Model(&models.Wallet{}).Where("source = ?", wallet.Opening).Count(&count)
+	assert.Equalf(t, int64(0), count, "Test: rolling back removes every opening entry")
+}
+
+func TestUniqueCoins(t *testing.T) {
+
+	db := openSQLite(t)
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+
+	//go back to before the unique coins, when two first deposits could both insert a row
+	migrateDownTo(t, db, 6)
+	for _, coin := range []models.Coin{
+		{MachineID: 1, Denomination: 5, Count: 3},
+		{MachineID: 1, Denomination: 5, Count: 4},
+		{MachineID: 1, Denomination: 10, Count: 2},
+		{MachineID: 2, Denomination: 5, Count: 1},
+	} {
+		if err := db.Create(&coin).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+
+	store := repository.NewGorm(db)
+	repeat := models.Coin{MachineID: 1, Denomination: 10, Count: 1}
+	assert.Equalf(t, repository.ErrDuplicate, store.Coins().Create(&repeat), "Test: a machine has one row per coin")
+
+	for _, deposit := range []struct {
+		machineID    uint
+		denomination int
+		count        int
+	}{
+		{machineID: 1, denomination: 5, count: 1},
+		{machineID: 1, denomination: 20, count: 2},
+		{machineID: 1, denomination: 20, count: 3},
+	} {
+		if err := store.Coins().Add(deposit.machineID, deposit.denomination, deposit.count); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		description   string // description of the test case
+		machineID     uint   // machine holding the coin
+		denomination  int    // coin to check
+		expectedRows  int64  // expected number of rows for the coin
+		expectedCount int    // expected count of the coin
+	}{
+		{
+			description:   "Test: repeated rows are folded into one holding every coin",
+			machineID:     1,
+			denomination:  5,
+			expectedRows:  1,
+			expectedCount: 8,
+		},
+		{
+			description:   "Test: a coin without repeated rows keeps its count",
+			machineID:     1,
+			denomination:  10,
+			expectedRows:  1,
+			expectedCount: 2,
+		},
+		{
+			description:   "Test: the first coins of a denomination create its row once",
+			machineID:     1,
+			denomination:  20,
+			expectedRows:  1,
+			expectedCount: 5,
+		},
+		{
+			description:   "Test: the same coin of another machine is left alone",
+			machineID:     2,
+			denomination:  5,
+			expectedRows:  1,
+			expectedCount: 1,
+		},
+	}
+
+	for _, test := range tests {
+		var rows int64
+		db.Model(&models.Coin{}).Where("machine_id = ? AND denomination = ?", test.machineID, test.denomination).Count(&rows)
+		assert.Equalf(t, test.expectedRows, rows, test.description)
+
+		held, _ := store.Coins().Lock(test.machineID, []int{test.denomination})
+		if assert.Equalf(t, 1, len(held), test.description) {
+			assert.Equalf(t, test.expectedCount, held[0].Count, test.description)
+		}
+	}
+}
+
+func TestMachineIndexes(t *testing.T) {
+
+	db := openSQLite(t)
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+
+	//go back to before the indexes, when nothing stopped repeated denominations and slots
+	migrateDownTo(t, db, 7)
+	assert.Equalf(t, false, db.Migrator().HasIndex(&models.Coin{}, "idx_coins_machine_id"), "Test: rolling back drops the machine index of coins")
+
+	for _, item := range []interface{}{
+		&models.Denomination{MachineID: 1, Value: 5},
+		&models.Denomination{MachineID: 1, Value: 5},
+		&models.Denomination{MachineID: 1, Value: 10},
+		&models.Slot{MachineID: 1, Code: "A1", Capacity: 5},
+		&models.Slot{MachineID: 1, Code: "A1", Capacity: 5},
+	} {
+		if err := db.Omit("Product").Create(item).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := database.MigrateUp(db)
+	assert.Equalf(t, true, err != nil, "Test: the index cannot be added over repeated slot codes")
+
+	var repeated models.Slot
+	db.Where("machine_id = ? AND code = ?", 1, "A1").Order("id desc").First(&repeated)
+	db.Model(&repeated).Update("code", "A2")
+
+	_, err = database.MigrateUp(db)
+	assert.Equalf(t, nil, err, "Test: migrate up once slot codes are unique")
+	assert.Equalf(t, true, db.Migrator().HasIndex(&models.Coin{}, "idx_coins_machine_id"), "Test: coins are indexed by machine")
+
+	store := repository.NewGorm(db)
+	accepted, _ := store.Coins().Denominations(1)
+	assert.Equalf(t, []int{5, 10}, accepted, "Test: a repeated denomination is kept once")
+
+	assert.Equalf(t, repository.ErrDuplicate, store.Coins().AddDenomination(1, 10), "Test: a machine accepts a coin once")
+	assert.Equalf(t, nil, store.Coins().AddDenomination(2, 10), "Test: another machine may accept the same coin")
+
+	slot := models.Slot{MachineID: 1, Code: "A2", Capacity: 5}
+	assert.Equalf(t, repository.ErrDuplicate, store.Slots().Create(&slot), "Test: slot codes are unique in a machine")
+	slot = models.Slot{MachineID: 2, Code: "A2", Capacity: 5}
+	assert.Equalf(t, nil, store.Slots().Create(&slot), "Test: another machine may use the same slot code")
+}
+
+func TestLegacyMachineRows(t *testing.T) {
+
+	db := openSQLite(t)
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var machines int64
+	db.Model(&models.Machine{}).Count(&machines)
+	assert.Equalf(t, int64(0), machines, "Test: a fresh database gets no machine from migrating")
+
+	//go back to before the default machine, when coins, products and deposits had none
+	//and coins were counted without a ledger
+	migrateDownTo(t, db, 8)
+
+	store := repository.NewGorm(db)
+	legacy := models.User{Username: "legacy", Deposit: 40}
+	if err := store.Users().Create(&legacy); err != nil {
+		t.Fatal(err)
+	}
+	product := models.Product{ProductName: "Water", Cost: 20, AmountAvailable: 3}
+	if err := store.Products().Create(&product); err != nil {
+		t.Fatal(err)
+	}
+	for _, coin := range []models.Coin{
+		{Denomination: 5, Count: 6},
+		{Denomination: 10, Count: 4},
+		{Denomination: 50, Count: 0},
+	} {
+		if err := store.Coins().Create(&coin); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//10 was restocked through the ledger once, then paid out without one
+	partial := models.CoinMovement{Denomination: 10, Delta: 7, Kind: inventory.Restock}
+	if err := store.Coins().Record(&partial); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+
+	machine, err := store.Machines().Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, _ := store.Users().Find(legacy.ID)
+	assert.Equalf(t, machine.ID, user.DepositMachineID, "Test: a deposit is pinned to the default machine")
+	found, _ := store.Products().Find(product.ID)
+	assert.Equalf(t, machine.ID, found.MachineID, "Test: a product is stocked in the default machine")
+
+	tests := []struct {
+		description   string // description of the test case
+		denomination  int    // coin to check
+		expectedDelta int    // expected delta of its opening entry, 0 for none
+	}{
+		{
+			description:   "Test: a coin without any ledger opens with its count",
+			denomination:  5,
+			expectedDelta: 6,
+		},
+		{
+			description:   "Test: coins paid out outside the ledger open with a debit",
+			denomination:  10,
+			expectedDelta: -3,
+		},
+		{
+			description:   "Test: an empty coin gets no opening entry",
+			denomination:  50,
+			expectedDelta: 0,
+		},
+	}
+
+	for _, test := range tests {
+		var delta int
+		var entries []models.CoinMovement
+		db.Where("machine_id = ? AND denomination = ? AND kind = ?", machine.ID, test.denomination, inventory.Opening).Find(&entries)
+		for _, entry := range entries {
+			delta += entry.Delta
+		}
+		assert.Equalf(t, test.expectedDelta, delta, test.description)
+	}
+
+	var rebuilt []models.Coin
+	err = store.Transaction(func(tx repository.Store) error {
+		var err error
+		rebuilt, err = inventory.Rebuild(tx)
+		return err
+	})
+	assert.Equalf(t, nil, err, "Test: the coins rebuild from the opening ledger")
+	counts := map[int]int{}
+	for _, coin := range rebuilt {
+		counts[coin.Denomination] = coin.Count
+	}
+	assert.Equalf(t, map[int]int{5: 6, 10: 4, 50: 0}, counts, "Test: the opening ledger adds up to the counted coins")
+
+	migrateDownTo(t, db, 9)
+	var count int64
+	db.Model(&models.CoinMovement{}).Where("kind = ?", inventory.Opening).Count(&count)
+	assert.Equalf(t, int64(0), count, "Test: rolling back removes every opening coin entry")
+}
+
+// migrateDownTo rolls back every applied migration after the given version.
+func migrateDownTo(t *testing.T, db *gorm.DB, version int) {
+	list, err := database.Migrations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := 0
+	for _, item := range list {
+		if item.Applied && item.Version > version {
+			steps++
+		}
+	}
+	if steps == 0 {
+		return
+	}
+	if _, err := database.MigrateDown(db, steps); err != nil {
+		t.Fatal(err)
+	}
+}
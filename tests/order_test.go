@@ -0,0 +1,366 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"log"
+	"mvpmatch/config"
+	"mvpmatch/handlers"
+	"mvpmatch/inventory"
+	"mvpmatch/middleware"
+	"mvpmatch/models"
+	"mvpmatch/repository"
+	"mvpmatch/routes"
+	"mvpmatch/tokens"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOrderRoute(t *testing.T) {
+
+	f := newFixture(t)
+	buyerToken := mintToken(t, f.buyer.ID, config.Role.Buyer)
+
+	tests := []struct {
+		description  string // description of the test case
+		route        string // route path to test
+		expectedCode int    // expected HTTP status code
+		token        string
+	}{
+		{
+			description:  "Test: is endpoint secured, get http status 400 ",
+			route:        "/orders",
+			expectedCode: 400,
+			token:        "",
+		},
+		{
+			description:  "Test: list buyer orders, get HTTP status 200",
+			route:        "/orders?product_id=34&from=2021-01-01&to=2031-01-01",
+			expectedCode: 200,
+			token:        buyerToken,
+		},
+		{
+			description:  "Test: filter by an invalid product_id, get HTTP status 400",
+			route:        "/orders?product_id=abc",
+			expectedCode: 400,
+			token:        buyerToken,
+		},
+		{
+			description:  "Test: page with an invalid cursor, get HTTP status 400",
+			route:        "/orders?cursor=-1",
+			expectedCode: 400,
+			token:        buyerToken,
+		},
+		{
+			description:  "Test: get a receipt that does not exist, get HTTP status 404",
+			route:        "/orders/999999999",
+			expectedCode: 404,
+			token:        buyerToken,
+		},
+	}
+
+	// Define Fiber app.
+	app := fiber.New()
+	h := handlers.New(f.store, nil)
+	jwtToken := middleware.JWT(tokens.Keys(), nil)
+	app.Get("orders", jwtToken, h.GetOrders)
+	app.Get("orders/:id", jwtToken, h.GetOrderReceipt)
+
+	// Iterate through test single test cases
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, test.route, nil)
+		req.Header.Set("Authorization", "Bearer "+test.token)
+
+		// Perform the request plain with the app,
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			log.Println(err)
+		}
+
+		// Verify, if the status code is as expected
+		assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+	}
+}
+
+func TestOrderHistory(t *testing.T) {
+	t.Run("memory", func(t *testing.T) { orderHistory(t, newFixture(t)) })
+	t.Run("sqlite", func(t *testing.T) { orderHistory(t, newSQLiteFixture(t)) })
+}
+
+// orderHistory has two buyers buy the products of two sellers, then pages through
+// the orders of each buyer and seller and reads the receipts of the orders.
+func orderHistory(t *testing.T, f fixture) {
+
+	buyerRole, _ := f.store.Roles().FindByName(config.Role.Buyer)
+	sellerRole, _ := f.store.Roles().FindByName(config.Role.Seller)
+
+	dave := models.User{Username: "dave", RoleID: buyerRole.ID}
+	if err := f.store.Users().Create(&dave); err != nil {
+		t.Fatal(err)
+	}
+	rival := models.User{Username: "rival", RoleID: sellerRole.ID}
+	if err := f.store.Users().Create(&rival); err != nil {
+		t.Fatal(err)
+	}
+	gum := models.Product{MachineID: f.machine.ID, AmountAvailable: 5, Cost: 15, ProductName: "gum", SellerID: rival.ID}
+	if err := f.store.Products().Create(&gum); err != nil {
+		t.Fatal(err)
+	}
+
+	//enough of every coin to pay out any change below
+	float := models.CoinMovement{Kind: inventory.Restock, MachineID: f.machine.ID}
+	if err := inventory.Apply(f.store, float, map[int]int{5: 5, 10: 5, 20: 5, 50: 5}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Define Fiber app.
+	app := fiber.New()
+	routes.Routes(app, f.store, tokens.NewMemory())
+
+	logins := map[string]string{
+		"buyer":  mintToken(t, f.buyer.ID, config.Role.Buyer),
+		"dave":   mintToken(t, dave.ID, config.Role.Buyer),
+		"seller": mintToken(t, f.seller.ID, config.Role.Seller),
+		"rival":  mintToken(t, rival.ID, config.Role.Seller),
+	}
+
+	send := func(method string, route string, as string, payload interface{}) *http.Response {
+		var body io.Reader
+		if payload != nil {
+			data, err := json.Marshal(payload)
+			if err != nil {
+				panic(err)
+			}
+			body = bytes.NewReader(data)
+		}
+
+		req := httptest.NewRequest(method, route, body)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+logins[as])
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	//each purchase pays the rest of the deposit out as change
+	purchases := []struct {
+		as        string
+		userID    uint
+		deposit   int
+		productID uint
+		amount    int
+	}{
+		{as: "buyer", userID: f.buyer.ID, deposit: 50, productID: f.product.ID, amount: 2},
+		{as: "buyer", userID: f.buyer.ID, deposit: 100, productID: gum.ID, amount: 1},
+		{as: "dave", userID: dave.ID, deposit: 15, productID: gum.ID, amount: 1},
+		{as: "buyer", userID: f.buyer.ID, deposit: 20, productID: f.product.ID, amount: 1},
+	}
+	var orders []models.Order
+	for _, purchase := range purchases {
+		f.fund(t, purchase.userID, purchase.deposit)
+
+		resp := send(http.MethodPost, "/v1/buy", purchase.as, fiber.Map{"product_id": purchase.productID, "amount": purchase.amount})
+		if resp.StatusCode != 200 {
+			t.Fatalf("buy returned %d", resp.StatusCode)
+		}
+		placed, _ := f.store.Orders().List(repository.OrderFilter{UserID: purchase.userID, Limit: 1})
+		orders = append(orders, placed[0])
+	}
+
+	yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+
+	tests := []struct {
+		description        string // description of the test case
+		route              string // route path to test
+		as                 string // name of the token making the request
+		expectedIDs        []uint // expected orders, newest first
+		expectedQuantities []int
+		expectedTotals     []int
+		expectedCursor     uint // expected cursor of the next page, 0 on the last one
+	}{
+		{
+			description:        "Test: the first page holds the newest purchases",
+			route:              "/v1/orders?limit=2",
+			as:                 "buyer",
+			expectedIDs:        []uint{orders[3].ID, orders[1].ID},
+			expectedQuantities: []int{1, 1},
+			expectedTotals:     []int{20, 15},
+			expectedCursor:     orders[1].ID,
+		},
+		{
+			description:        "Test: the cursor continues where the first page ended",
+			route:              fmt.Sprintf("/v1/orders?limit=2&cursor=%d", orders[1].ID),
+			as:                 "buyer",
+			expectedIDs:        []uint{orders[0].ID},
+			expectedQuantities: []int{2},
+			expectedTotals:     []int{40},
+		},
+		{
+			description:        "Test: filter the purchases by product",
+			route:              fmt.Sprintf("/v1/orders?product_id=%d", f.product.ID),
+			as:                 "buyer",
+			expectedIDs:        []uint{orders[3].ID, orders[0].ID},
+			expectedQuantities: []int{1, 2},
+			expectedTotals:     []int{20, 40},
+		},
+		{
+			description: "Test: purchases of today are outside a range ending yesterday",
+			route:       "/v1/orders?to=" + yesterday,
+			as:          "buyer",
+		},
+		{
+			description:        "Test: another buyer sees only their own purchase",
+			route:              "/v1/orders",
+			as:                 "dave",
+			expectedIDs:        []uint{orders[2].ID},
+			expectedQuantities: []int{1},
+			expectedTotals:     []int{15},
+		},
+		{
+			description:        "Test: a seller sees the sales of their own products",
+			route:              "/v1/seller/orders",
+			as:                 "seller",
+			expectedIDs:        []uint{orders[3].ID, orders[0].ID},
+			expectedQuantities: []int{1, 2},
+			expectedTotals:     []int{20, 40},
+		},
+		{
+			description:        "Test: a full page of sales has a cursor",
+			route:              "/v1/seller/orders?limit=2",
+			as:                 "rival",
+			expectedIDs:        []uint{orders[2].ID, orders[1].ID},
+			expectedQuantities: []int{1, 1},
+			expectedTotals:     []int{15, 15},
+			expectedCursor:     orders[1].ID,
+		},
+	}
+
+	for _, test := range tests {
+		resp := send(http.MethodGet, test.route, test.as, nil)
+		assert.Equalf(t, 200, resp.StatusCode, test.description)
+
+		var result struct {
+			Data struct {
+				Orders []struct {
+					ID         uint `json:"id"`
+					Quantity   int  `json:"quantity"`
+					TotalSpent int  `json:"total_spent"`
+				} `json:"orders"`
+				NextCursor uint `json:"next_cursor"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+
+		var ids []uint
+		var quantities, totals []int
+		for _, item := range result.Data.Orders {
+			ids = append(ids, item.ID)
+			quantities = append(quantities, item.Quantity)
+			totals = append(totals, item.TotalSpent)
+		}
+		assert.Equalf(t, test.expectedIDs, ids, test.description)
+		assert.Equalf(t, test.expectedQuantities, quantities, test.description)
+		assert.Equalf(t, test.expectedTotals, totals, test.description)
+		assert.Equalf(t, test.expectedCursor, result.Data.NextCursor, test.description)
+	}
+
+	type receipt struct {
+		OrderID       uint   `json:"order_id"`
+		Product       string `json:"product"`
+		Buyer         string `json:"buyer"`
+		UnitCost      int    `json:"unit_cost"`
+		Quantity      int    `json:"quantity"`
+		TotalSpent    int    `json:"total_spent"`
+		DepositBefore int    `json:"deposit_before"`
+		DepositAfter  int    `json:"deposit_after"`
+		Change        []int  `json:"change"`
+	}
+
+	receipts := []struct {
+		description     string  // description of the test case
+		route           string  // route path to test
+		as              string  // name of the token making the request
+		expectedCode    int     // expected HTTP status code
+		expectedReceipt receipt // expected receipt when found
+	}{
+		{
+			description:  "Test: a receipt with change in one coin, get HTTP status 200",
+			route:        fmt.Sprintf("/v1/orders/%d", orders[0].ID),
+			as:           "buyer",
+			expectedCode: 200,
+			expectedReceipt: receipt{
+				OrderID: orders[0].ID, Product: "product", Buyer: f.buyer.Username,
+				UnitCost: 20, Quantity: 2, TotalSpent: 40, DepositBefore: 50, Change: []int{10},
+			},
+		},
+		{
+			description:  "Test: a receipt with change in every coin, get HTTP status 200",
+			route:        fmt.Sprintf("/v1/orders/%d", orders[1].ID),
+			as:           "buyer",
+			expectedCode: 200,
+			expectedReceipt: receipt{
+				OrderID: orders[1].ID, Product: "gum", Buyer: f.buyer.Username,
+				UnitCost: 15, Quantity: 1, TotalSpent: 15, DepositBefore: 100, Change: []int{50, 20, 10, 5},
+			},
+		},
+		{
+			description:  "Test: a receipt without change, get HTTP status 200",
+			route:        fmt.Sprintf("/v1/orders/%d", orders[3].ID),
+			as:           "buyer",
+			expectedCode: 200,
+			expectedReceipt: receipt{
+				OrderID: orders[3].ID, Product: "product", Buyer: f.buyer.Username,
+				UnitCost: 20, Quantity: 1, TotalSpent: 20, DepositBefore: 20, Change: []int{},
+			},
+		},
+		{
+			description:  "Test: the receipt of another buyer, get HTTP status 404",
+			route:        fmt.Sprintf("/v1/orders/%d", orders[2].ID),
+			as:           "buyer",
+			expectedCode: 404,
+		},
+		{
+			description:  "Test: a seller reads the receipt of a sale, get HTTP status 200",
+			route:        fmt.Sprintf("/v1/seller/orders/%d", orders[2].ID),
+			as:           "rival",
+			expectedCode: 200,
+			expectedReceipt: receipt{
+				OrderID: orders[2].ID, Product: "gum", Buyer: "dave",
+				UnitCost: 15, Quantity: 1, TotalSpent: 15, DepositBefore: 15, Change: []int{},
+			},
+		},
+		{
+			description:  "Test: a seller reads the receipt of another seller's sale, get HTTP status 404",
+			route:        fmt.Sprintf("/v1/seller/orders/%d", orders[2].ID),
+			as:           "seller",
+			expectedCode: 404,
+		},
+	}
+
+	for _, test := range receipts {
+		resp := send(http.MethodGet, test.route, test.as, nil)
+		assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+		if test.expectedCode != 200 {
+			continue
+		}
+
+		var result struct {
+			Data receipt `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equalf(t, test.expectedReceipt, result.Data, test.description)
+	}
+}
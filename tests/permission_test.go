@@ -0,0 +1,285 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"mvpmatch/audit"
+	"mvpmatch/config"
+	"mvpmatch/models"
+	"mvpmatch/permissions"
+	"mvpmatch/repository"
+	"mvpmatch/routes"
+	"mvpmatch/tokens"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPermissions(t *testing.T) {
+	t.Run("memory", func(t *testing.T) { rolePermissions(t, newFixture(t)) })
+	t.Run("sqlite", func(t *testing.T) { rolePermissions(t, newSQLiteFixture(t)) })
+}
+
+// rolePermissions has an admin change what buyers and sellers may do and checks
+// tokens already handed out follow the change, as does sign-up.
+func rolePermissions(t *testing.T, f fixture) {
+
+	adminRole, _ := f.store.Roles().FindByName(config.Role.Admin)
+	buyerRole, _ := f.store.Roles().FindByName(config.Role.Buyer)
+	sellerRole, _ := f.store.Roles().FindByName(config.Role.Seller)
+
+	moderator := models.User{Username: "moderator", RoleID: adminRole.ID}
+	if err := f.store.Users().Create(&moderator); err != nil {
+		t.Fatal(err)
+	}
+
+	buyerRoute := fmt.Sprintf("/v1/admin/roles/%d", buyerRole.ID)
+	sellerRoute := fmt.Sprintf("/v1/admin/roles/%d", sellerRole.ID)
+	sellerPermissions := []string{permissions.ProductWrite, permissions.SlotStock, permissions.SaleRead, permissions.OrderRefund}
+
+	tests := []struct {
+		description  string      // description of the test case
+		method       string      // http method of the step
+		route        string      // route path to test
+		as           string      // name of the token making the request
+		payload      interface{} // request body
+		expectedCode int         // expected HTTP status code
+	}{
+		{
+			description:  "Test: sign up as a buyer, get HTTP status 201",
+			method:       http.MethodPost,
+			route:        "/v1/user",
+			payload:      fiber.Map{"username": "frank", "password": "secret", "role_id": buyerRole.ID},
+			expectedCode: 201,
+		},
+		{
+			description:  "Test: sign up as an admin, get HTTP status 400",
+			method:       http.MethodPost,
+			route:        "/v1/user",
+			payload:      fiber.Map{"username": "grace", "password": "secret", "role_id": adminRole.ID},
+			expectedCode: 400,
+		},
+		{
+			description:  "Test: a buyer lists the roles, get HTTP status 401",
+			method:       http.MethodGet,
+			route:        "/v1/admin/roles",
+			as:           "buyer",
+			expectedCode: 401,
+		},
+		{
+			description:  "Test: list the permissions, get HTTP status 200",
+			method:       http.MethodGet,
+			route:        "/v1/admin/permissions",
+			as:           "admin",
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: list the roles, get HTTP status 200",
+			method:       http.MethodGet,
+			route:        "/v1/admin/roles",
+			as:           "admin",
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: read the wallet as a buyer, get HTTP status 200",
+			method:       http.MethodGet,
+			route:        "/v1/wallet",
+			as:           "buyer",
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: reset a deposit as a seller, get HTTP status 401",
+			method:       http.MethodPatch,
+			route:        "/v1/deposit/reset",
+			as:           "seller",
+			expectedCode: 401,
+		},
+		{
+			description:  "Test: reset a deposit in a machine as a seller, get HTTP status 401",
+			method:       http.MethodPatch,
+			route:        fmt.Sprintf("/v1/machines/%d/deposit/reset", f.machine.ID),
+			as:           "seller",
+			expectedCode: 401,
+		},
+		{
+			description:  "Test: add a product as a buyer, get HTTP status 401",
+			method:       http.MethodPost,
+			route:        "/v1/product",
+			as:           "buyer",
+			payload:      fiber.Map{"product_name": "tea", "cost": 10, "amount_available": 2},
+			expectedCode: 401,
+		},
+		{
+			description: "Test: grant a permission that does not exist, get HTTP status 400",
+			method:      http.MethodPut,
+			route:       buyerRoute,
+			as:          "admin",
+			payload: fiber.Map{
+				"permissions":     []string{permissions.CoinDeposit, "coin:counterfeit"},
+				"self_assignable": true,
+			},
+			expectedCode: 400,
+		},
+		{
+			description:  "Test: edit a role that does not exist, get HTTP status 404",
+			method:       http.MethodPut,
+			route:        "/v1/admin/roles/9999",
+			as:           "admin",
+			payload:      fiber.Map{"permissions": []string{}, "self_assignable": false},
+			expectedCode: 404,
+		},
+		{
+			description: "Test: take role:write from your own role, get HTTP status 400",
+			method:      http.MethodPut,
+			route:       fmt.Sprintf("/v1/admin/roles/%d", adminRole.ID),
+			as:          "admin",
+			payload: fiber.Map{
+				"permissions":     []string{permissions.UserModerate},
+				"self_assignable": false,
+			},
+			expectedCode: 400,
+		},
+		{
+			description: "Test: a buyer edits their own role, get HTTP status 401",
+			method:      http.MethodPut,
+			route:       buyerRoute,
+			as:          "buyer",
+			payload: fiber.Map{
+				"permissions":     []string{permissions.RoleWrite},
+				"self_assignable": true,
+			},
+			expectedCode: 401,
+		},
+		{
+			description: "Test: take wallet:read from buyers and give them product:write, get HTTP status 200",
+			method:      http.MethodPut,
+			route:       buyerRoute,
+			as:          "admin",
+			payload: fiber.Map{
+				"permissions": []string{
+					permissions.CoinDeposit, permissions.OrderCreate, permissions.OrderRead,
+					permissions.ProductWrite, permissions.ProductWrite,
+				},
+				"self_assignable": true,
+			},
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: read the wallet with a token issued before the change, get HTTP status 401",
+			method:       http.MethodGet,
+			route:        "/v1/wallet",
+			as:           "buyer",
+			expectedCode: 401,
+		},
+		{
+			description:  "Test: add a product as a buyer, get HTTP status 201",
+			method:       http.MethodPost,
+			route:        "/v1/product",
+			as:           "buyer",
+			payload:      fiber.Map{"product_name": "tea", "cost": 10, "amount_available": 2},
+			expectedCode: 201,
+		},
+		{
+			description:  "Test: sign up as a seller, get HTTP status 400",
+			method:       http.MethodPost,
+			route:        "/v1/user",
+			payload:      fiber.Map{"username": "heidi", "password": "secret", "role_id": sellerRole.ID},
+			expectedCode: 400,
+		},
+		{
+			description: "Test: open the seller role to sign-up, get HTTP status 200",
+			method:      http.MethodPut,
+			route:       sellerRoute,
+			as:          "admin",
+			payload: fiber.Map{
+				"permissions":     sellerPermissions,
+				"self_assignable": true,
+			},
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: sign up as a seller once the role is open, get HTTP status 201",
+			method:       http.MethodPost,
+			route:        "/v1/user",
+			payload:      fiber.Map{"username": "heidi", "password": "secret", "role_id": sellerRole.ID},
+			expectedCode: 201,
+		},
+		{
+			description:  "Test: sellers keep their permissions, get HTTP status 200",
+			method:       http.MethodGet,
+			route:        "/v1/seller/orders",
+			as:           "seller",
+			expectedCode: 200,
+		},
+	}
+
+	// Define Fiber app.
+	app := fiber.New()
+	routes.Routes(app, f.store, tokens.NewMemory())
+
+	logins := map[string]string{
+		"admin":  mintToken(t, moderator.ID, config.Role.Admin),
+		"seller": mintToken(t, f.seller.ID, config.Role.Seller),
+		"buyer":  mintToken(t, f.buyer.ID, config.Role.Buyer),
+	}
+
+	// Run every step in order, each one builds on the ones before it
+	for _, test := range tests {
+		var body io.Reader
+		if test.payload != nil {
+			payload, err := json.Marshal(test.payload)
+			if err != nil {
+				panic(err)
+			}
+			body = bytes.NewReader(payload)
+		}
+
+		req := httptest.NewRequest(test.method, test.route, body)
+		req.Header.Set("Content-Type", "application/json")
+		if test.as != "" {
+			req.Header.Set("Authorization", "Bearer "+logins[test.as])
+		}
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Verify, if the status code is as expected
+		assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+	}
+
+	granted, err := f.store.Permissions().ForRole(buyerRole.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, permission := range granted {
+		names = append(names, permission.Name)
+	}
+	assert.Equalf(t, []string{
+		permissions.CoinDeposit, permissions.OrderCreate, permissions.OrderRead, permissions.ProductWrite,
+	}, names, "Test: buyers hold what the admin gave them, sorted by name")
+
+	seller, _ := f.store.Roles().Find(sellerRole.ID)
+	assert.Equalf(t, true, seller.SelfAssignable, "Test: the seller role is open to sign-up")
+
+	//seeding again leaves the grants of known permissions to the admins
+	if err := permissions.Seed(f.store); err != nil {
+		t.Fatal(err)
+	}
+	held, _ := f.store.Permissions().Granted(config.Role.Buyer, permissions.WalletRead)
+	assert.Equalf(t, false, held, "Test: seeding does not grant a revoked permission again")
+	all, _ := f.store.Permissions().List()
+	assert.Equalf(t, len(permissions.All), len(all), "Test: seeding adds no permission twice")
+
+	entries, err := f.store.AuditLogs().List(repository.AuditFilter{Action: audit.EditRole})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equalf(t, 2, len(entries), "Test: every role change is audited, failed ones are not")
+}
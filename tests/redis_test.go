@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"mvpmatch/config"
+	"mvpmatch/handlers"
+	"mvpmatch/middleware"
+	"mvpmatch/permissions"
+	"mvpmatch/tokens"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// unreachableRedis is a client whose every command fails fast, as during an outage.
+func unreachableRedis(t *testing.T) *redis.Client {
+	client := redis.NewClient(&redis.Options{
+		Addr:        "127.0.0.1:1",
+		DialTimeout: 100 * time.Millisecond,
+		MaxRetries:  -1,
+	})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestRedisOutage(t *testing.T) {
+
+	f := newFixture(t)
+	client := unreachableRedis(t)
+	buyerToken := mintToken(t, f.buyer.ID, config.Role.Buyer)
+
+	policy := config.Redis.Policy
+	t.Cleanup(func() { config.Redis.Policy = policy })
+
+	tests := []struct {
+		description  string // description of the test case
+		policy       string // what token checks do while redis is down
+		method       string // http method of the request
+		route        string // route path to test
+		token        string // token sent with the request
+		expectedCode int    // expected HTTP status code
+	}{
+		{
+			description:  "Test: fail closed rejects the token, get HTTP status 503",
+			policy:       middleware.FailClosed,
+			method:       http.MethodGet,
+			route:        "/buyer",
+			token:        buyerToken,
+			expectedCode: 503,
+		},
+		{
+			description:  "Test: fail open lets the token through, get HTTP status 200",
+			policy:       middleware.FailOpen,
+			method:       http.MethodGet,
+			route:        "/buyer",
+			token:        buyerToken,
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: fail closed on a route without a role, get HTTP status 503",
+			policy:       middleware.FailClosed,
+			method:       http.MethodGet,
+			route:        "/active",
+			token:        buyerToken,
+			expectedCode: 503,
+		},
+		{
+			description:  "Test: fail open still checks the permission, get HTTP status 401",
+			policy:       middleware.FailOpen,
+			method:       http.MethodGet,
+			route:        "/seller",
+			token:        buyerToken,
+			expectedCode: 401,
+		},
+		{
+			description:  "Test: health while failing closed, get HTTP status 503",
+			policy:       middleware.FailClosed,
+			method:       http.MethodGet,
+			route:        "/health",
+			expectedCode: 503,
+		},
+		{
+			description:  "Test: health while failing open, get HTTP status 200",
+			policy:       middleware.FailOpen,
+			method:       http.MethodGet,
+			route:        "/health",
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: logout cannot revoke the token, get HTTP status 503",
+			policy:       middleware.FailOpen,
+			method:       http.MethodPost,
+			route:        "/logout",
+			token:        buyerToken,
+			expectedCode: 503,
+		},
+	}
+
+	for _, test := range tests {
+		config.Redis.Policy = test.policy
+
+		// Define Fiber app.
+		app := fiber.New()
+		revoked := tokens.NewRedis(client)
+		h := handlers.New(f.store, revoked)
+		auth := middleware.NewAuth(f.store, revoked, test.policy)
+		jwtToken := middleware.JWT(tokens.Keys(), auth.Active)
+		ok := func(c *fiber.Ctx) error { return c.SendStatus(200) }
+		app.Get("active", jwtToken, ok)
+		app.Get("buyer", jwtToken, auth.Require(permissions.WalletRead), ok)
+		app.Get("seller", jwtToken, auth.Require(permissions.ProductWrite), ok)
+		app.Get("health", h.Health)
+		app.Post("logout", jwtToken, h.Logout)
+
+		req := httptest.NewRequest(test.method, test.route, nil)
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Verify, if the status code is as expected
+		assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+	}
+}
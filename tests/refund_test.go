@@ -0,0 +1,504 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"mvpmatch/audit"
+	"mvpmatch/config"
+	"mvpmatch/models"
+	"mvpmatch/repository"
+	"mvpmatch/routes"
+	"mvpmatch/tokens"
+	"mvpmatch/wallet"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRefunds(t *testing.T) {
+	t.Run("memory", func(t *testing.T) { refunds(t, newFixture(t)) })
+	t.Run("sqlite", func(t *testing.T) { refunds(t, newSQLiteFixture(t)) })
+}
+
+// refunds has a buyer buy four units, then refunds them in parts as the seller and
+// as an admin, checking the deposit, the stock and the refunded units after every step.
+func refunds(t *testing.T, f fixture) {
+
+	adminRole, _ := f.store.Roles().FindByName(config.Role.Admin)
+	sellerRole, _ := f.store.Roles().FindByName(config.Role.Seller)
+
+	moderator := models.User{Username: "moderator", RoleID: adminRole.ID}
+	if err := f.store.Users().Create(&moderator); err != nil {
+		t.Fatal(err)
+	}
+	rival := models.User{Username: "rival", RoleID: sellerRole.ID}
+	if err := f.store.Users().Create(&rival); err != nil {
+		t.Fatal(err)
+	}
+
+	// Define Fiber app.
+	app := fiber.New()
+	routes.Routes(app, f.store, tokens.NewMemory())
+
+	logins := map[string]string{
+		"admin":  mintToken(t, moderator.ID, config.Role.Admin),
+		"seller": mintToken(t, f.seller.ID, config.Role.Seller),
+		"rival":  mintToken(t, rival.ID, config.Role.Seller),
+		"buyer":  mintToken(t, f.buyer.ID, config.Role.Buyer),
+	}
+
+	send := func(method string, route string, as string, payload interface{}) int {
+		var body io.Reader
+		if payload != nil {
+			data, err := json.Marshal(payload)
+			if err != nil {
+				panic(err)
+			}
+			body = bytes.NewReader(data)
+		}
+
+		req := httptest.NewRequest(method, route, body)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+logins[as])
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	//four units at 20 spend the whole deposit, so no change is due
+	f.fund(t, f.buyer.ID, 80)
+	code := send(http.MethodPost, "/v1/buy", "buyer", fiber.Map{"product_id": f.product.ID, "amount": 4})
+	if code != 200 {
+		t.Fatalf("buy returned %d", code)
+	}
+	orders, err := f.store.Orders().List(repository.OrderFilter{UserID: f.buyer.ID})
+	if err != nil || len(orders) != 1 {
+		t.Fatal("the buyer has no order")
+	}
+	order := orders[0]
+
+	sellerRoute := fmt.Sprintf("/v1/orders/%d/refund", order.ID)
+	adminRoute := fmt.Sprintf("/v1/admin/orders/%d/refund", order.ID)
+
+	tests := []struct {
+		description      string      // description of the test case
+		route            string      // route path to test
+		as               string      // name of the token making the request
+		payload          interface{} // request body
+		expectedCode     int         // expected HTTP status code
+		expectedDeposit  int         // expected deposit of the buyer after the step
+		expectedStock    int         // expected stock of the product after the step
+		expectedRefunded int         // expected refunded units of the order after the step
+	}{
+		{
+			description:   "Test: a buyer refunds their own order, get HTTP status 401",
+			route:         sellerRoute,
+			as:            "buyer",
+			payload:       fiber.Map{"reason": "changed my mind"},
+			expectedCode:  401,
+			expectedStock: 6,
+		},
+		{
+			description:   "Test: another seller refunds the order, get HTTP status 404",
+			route:         sellerRoute,
+			as:            "rival",
+			payload:       fiber.Map{"reason": "goodwill"},
+			expectedCode:  404,
+			expectedStock: 6,
+		},
+		{
+			description:   "Test: refund without a reason, get HTTP status 400",
+			route:         sellerRoute,
+			as:            "seller",
+			payload:       fiber.Map{"units": 1},
+			expectedCode:  400,
+			expectedStock: 6,
+		},
+		{
+			description:   "Test: refund more units than were bought, get HTTP status 400",
+			route:         sellerRoute,
+			as:            "seller",
+			payload:       fiber.Map{"units": 5, "reason": "damaged"},
+			expectedCode:  400,
+			expectedStock: 6,
+		},
+		{
+			description:      "Test: refund one unit, get HTTP status 200",
+			route:            sellerRoute,
+			as:               "seller",
+			payload:          fiber.Map{"units": 1, "reason": "damaged"},
+			expectedCode:     200,
+			expectedDeposit:  20,
+			expectedStock:    7,
+			expectedRefunded: 1,
+		},
+		{
+			description:      "Test: refund more units than are left, get HTTP status 400",
+			route:            sellerRoute,
+			as:               "seller",
+			payload:          fiber.Map{"units": 4, "reason": "damaged"},
+			expectedCode:     400,
+			expectedDeposit:  20,
+			expectedStock:    7,
+			expectedRefunded: 1,
+		},
+		{
+			description:      "Test: a seller refunds through the admin route, get HTTP status 401",
+			route:            adminRoute,
+			as:               "seller",
+			payload:          fiber.Map{"units": 2, "reason": "damaged"},
+			expectedCode:     401,
+			expectedDeposit:  20,
+			expectedStock:    7,
+			expectedRefunded: 1,
+		},
+		{
+			description:      "Test: an admin refunds two more units, get HTTP status 200",
+			route:            adminRoute,
+			as:               "admin",
+			payload:          fiber.Map{"units": 2, "reason": "seller unreachable"},
+			expectedCode:     200,
+			expectedDeposit:  60,
+			expectedStock:    9,
+			expectedRefunded: 3,
+		},
+		{
+			description:      "Test: refund the rest of the order, get HTTP status 200",
+			route:            sellerRoute,
+			as:               "seller",
+			payload:          fiber.Map{"reason": "recalled"},
+			expectedCode:     200,
+			expectedDeposit:  80,
+			expectedStock:    10,
+			expectedRefunded: 4,
+		},
+		{
+			description:      "Test: refund a refunded order, get HTTP status 400",
+			route:            sellerRoute,
+			as:               "seller",
+			payload:          fiber.Map{"reason": "recalled"},
+			expectedCode:     400,
+			expectedDeposit:  80,
+			expectedStock:    10,
+			expectedRefunded: 4,
+		},
+		{
+			description:      "Test: an admin refunds a refunded order, get HTTP status 400",
+			route:            adminRoute,
+			as:               "admin",
+			payload:          fiber.Map{"units": 1, "reason": "again"},
+			expectedCode:     400,
+			expectedDeposit:  80,
+			expectedStock:    10,
+			expectedRefunded: 4,
+		},
+		{
+			description:      "Test: an admin refunds an unknown order, get HTTP status 404",
+			route:            "/v1/admin/orders/9999/refund",
+			as:               "admin",
+			payload:          fiber.Map{"reason": "typo"},
+			expectedCode:     404,
+			expectedDeposit:  80,
+			expectedStock:    10,
+			expectedRefunded: 4,
+		},
+	}
+
+	// Run every step in order, each one builds on the ones before it
+	for _, test := range tests {
+		assert.Equalf(t, test.expectedCode, send(http.MethodPost, test.route, test.as, test.payload), test.description)
+
+		buyer, _ := f.store.Users().Find(f.buyer.ID)
+		product, _ := f.store.Products().Find(f.product.ID)
+		refunded, _ := f.store.Orders().Find(order.ID)
+		assert.Equalf(t, test.expectedDeposit, buyer.Deposit, test.description)
+		assert.Equalf(t, test.expectedStock, product.AmountAvailable, test.description)
+		assert.Equalf(t, test.expectedRefunded, refunded.RefundedUnits, test.description)
+	}
+
+	refunded, _ := f.store.Orders().Find(order.ID)
+	assert.Equalf(t, 80, refunded.RefundAmount, "Test: the refunded amounts add up")
+	assert.Equalf(t, "recalled", refunded.RefundReason, "Test: the order keeps the latest reason")
+
+	entries, _ := f.store.Wallets().List(repository.WalletFilter{UserID: f.buyer.ID, Type: "credit"})
+	credits := 0
+	for _, entry := range entries {
+		if entry.Source == wallet.Refund {
+			credits++
+		}
+	}
+	assert.Equalf(t, 3, credits, "Test: every refund is a credit in the wallet")
+
+	logs, _ := f.store.AuditLogs().List(repository.AuditFilter{Action: audit.RefundOrder})
+	assert.Equalf(t, 1, len(logs), "Test: admin refunds are audited, seller refunds are not")
+}
+
+func TestRefundSlots(t *testing.T) {
+	t.Run("memory", func(t *testing.T) { refundSlots(t, newFixture(t)) })
+	t.Run("sqlite", func(t *testing.T) { refundSlots(t, newSQLiteFixture(t)) })
+}
+
+// refundSlots refunds units of a slotted product and checks a refund the slots
+// have no room for is rejected instead of losing the units that do not fit.
+func refundSlots(t *testing.T, f fixture) {
+
+	slot := models.Slot{MachineID: f.machine.ID, Code: "A1", Capacity: 5, Fill: 5, ProductID: f.product.ID}
+	if err := f.store.Slots().Create(&slot); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.store.Products().SetStock(f.product.ID, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	// Define Fiber app.
+	app := fiber.New()
+	routes.Routes(app, f.store, tokens.NewMemory())
+
+	logins := map[string]string{
+		"seller": mintToken(t, f.seller.ID, config.Role.Seller),
+		"buyer":  mintToken(t, f.buyer.ID, config.Role.Buyer),
+	}
+
+	send := func(route string, as string, payload interface{}) int {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			panic(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, route, bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+logins[as])
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	//buy two units out of the slot, then have the seller fill it back up
+	f.fund(t, f.buyer.ID, 40)
+	if code := send("/v1/buy", "buyer", fiber.Map{"slot": "A1", "amount": 2}); code != 200 {
+		t.Fatalf("buy returned %d", code)
+	}
+	orders, _ := f.store.Orders().List(repository.OrderFilter{UserID: f.buyer.ID})
+	if len(orders) != 1 {
+		t.Fatal("the buyer has no order")
+	}
+	if err := f.store.Slots().SetFill(slot.ID, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.store.Products().SetStock(f.product.ID, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	route := fmt.Sprintf("/v1/orders/%d/refund", orders[0].ID)
+
+	tests := []struct {
+		description      string // description of the test case
+		units            int    // units to refund
+		freeUnits        int    // units taken out of the slot before the refund
+		expectedCode     int    // expected HTTP status code
+		expectedFill     int    // expected fill of the slot after the step
+		expectedDeposit  int    // expected deposit of the buyer after the step
+		expectedRefunded int    // expected refunded units of the order after the step
+	}{
+		{
+			description:  "Test: refund into a full slot, get HTTP status 400",
+			units:        1,
+			expectedCode: 400,
+			expectedFill: 5,
+		},
+		{
+			description:      "Test: refund into the room left in the slot, get HTTP status 200",
+			units:            1,
+			freeUnits:        1,
+			expectedCode:     200,
+			expectedFill:     5,
+			expectedDeposit:  20,
+			expectedRefunded: 1,
+		},
+		{
+			description:      "Test: refund more than the slot has room for, get HTTP status 400",
+			units:            1,
+			expectedCode:     400,
+			expectedFill:     5,
+			expectedDeposit:  20,
+			expectedRefunded: 1,
+		},
+	}
+
+	for _, test := range tests {
+		if test.freeUnits > 0 {
+			current, _ := f.store.Slots().Find(f.machine.ID, "A1")
+			f.store.Slots().SetFill(slot.ID, current.Fill-test.freeUnits)
+			f.store.Products().SetStock(f.product.ID, current.Fill-test.freeUnits)
+		}
+
+		assert.Equalf(t, test.expectedCode, send(route, "seller", fiber.Map{"units": test.units, "reason": "damaged"}), test.description)
+
+		current, _ := f.store.Slots().Find(f.machine.ID, "A1")
+		product, _ := f.store.Products().Find(f.product.ID)
+		buyer, _ := f.store.Users().Find(f.buyer.ID)
+		order, _ := f.store.Orders().Find(orders[0].ID)
+		assert.Equalf(t, test.expectedFill, current.Fill, test.description)
+		assert.Equalf(t, test.expectedFill, product.AmountAvailable, test.description)
+		assert.Equalf(t, test.expectedDeposit, buyer.Deposit, test.description)
+		assert.Equalf(t, test.expectedRefunded, order.RefundedUnits, test.description)
+	}
+}
+
+func TestRefundMachine(t *testing.T) {
+	t.Run("memory", func(t *testing.T) { refundMachine(t, newFixture(t)) })
+	t.Run("sqlite", func(t *testing.T) { refundMachine(t, newSQLiteFixture(t)) })
+}
+
+// refundMachine refunds an order while the buyer holds a deposit in another machine
+// and checks the refund waits until that deposit is gone, then lands in the order's machine.
+func refundMachine(t *testing.T, f fixture) {
+
+	kiosk := models.Machine{Name: "kiosk"}
+	if err := f.store.Machines().Create(&kiosk); err != nil {
+		t.Fatal(err)
+	}
+
+	// Define Fiber app.
+	app := fiber.New()
+	routes.Routes(app, f.store, tokens.NewMemory())
+
+	logins := map[string]string{
+		"seller": mintToken(t, f.seller.ID, config.Role.Seller),
+		"buyer":  mintToken(t, f.buyer.ID, config.Role.Buyer),
+	}
+
+	send := func(route string, as string, payload interface{}) int {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			panic(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, route, bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+logins[as])
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	//buy one unit in the default machine, then deposit in the kiosk
+	f.fund(t, f.buyer.ID, 20)
+	if code := send("/v1/buy", "buyer", fiber.Map{"product_id": f.product.ID, "amount": 1}); code != 200 {
+		t.Fatalf("buy returned %d", code)
+	}
+	orders, _ := f.store.Orders().List(repository.OrderFilter{UserID: f.buyer.ID})
+	if len(orders) != 1 {
+		t.Fatal("the buyer has no order")
+	}
+	if err := f.store.Users().SetDepositMachine(f.buyer.ID, kiosk.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wallet.Post(f.store, models.Wallet{UserID: f.buyer.ID, Credit: 10, Source: wallet.Deposit}); err != nil {
+		t.Fatal(err)
+	}
+
+	route := fmt.Sprintf("/v1/orders/%d/refund", orders[0].ID)
+
+	tests := []struct {
+		description     string // description of the test case
+		spend           int    // deposit spent in the kiosk before the refund
+		expectedCode    int    // expected HTTP status code
+		expectedDeposit int    // expected deposit of the buyer after the step
+		expectedMachine uint   // expected machine holding the deposit after the step
+	}{
+		{
+			description:     "Test: refund while the buyer holds a deposit in another machine, get HTTP status 400",
+			expectedCode:    400,
+			expectedDeposit: 10,
+			expectedMachine: kiosk.ID,
+		},
+		{
+			description:     "Test: refund once the deposit in the other machine is spent, get HTTP status 200",
+			spend:           10,
+			expectedCode:    200,
+			expectedDeposit: 20,
+			expectedMachine: f.machine.ID,
+		},
+	}
+
+	for _, test := range tests {
+		if test.spend > 0 {
+			if _, err := wallet.Post(f.store, models.Wallet{UserID: f.buyer.ID, Debit: test.spend, Source: wallet.Purchase}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		assert.Equalf(t, test.expectedCode, send(route, "seller", fiber.Map{"reason": "damaged"}), test.description)
+
+		buyer, _ := f.store.Users().Find(f.buyer.ID)
+		assert.Equalf(t, test.expectedDeposit, buyer.Deposit, test.description)
+		assert.Equalf(t, test.expectedMachine, buyer.DepositMachineID, test.description)
+	}
+}
+
+func TestRefundDeletedBuyer(t *testing.T) {
+	t.Run("memory", func(t *testing.T) { refundDeletedBuyer(t, newFixture(t)) })
+	t.Run("sqlite", func(t *testing.T) { refundDeletedBuyer(t, newSQLiteFixture(t)) })
+}
+
+// refundDeletedBuyer refunds an order whose buyer deleted their account since
+// and checks it is rejected without putting anything back on sale.
+func refundDeletedBuyer(t *testing.T, f fixture) {
+
+	// Define Fiber app.
+	app := fiber.New()
+	routes.Routes(app, f.store, tokens.NewMemory())
+
+	logins := map[string]string{
+		"seller": mintToken(t, f.seller.ID, config.Role.Seller),
+		"buyer":  mintToken(t, f.buyer.ID, config.Role.Buyer),
+	}
+
+	send := func(route string, as string, payload interface{}) int {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			panic(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, route, bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+logins[as])
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	f.fund(t, f.buyer.ID, 20)
+	if code := send("/v1/buy", "buyer", fiber.Map{"product_id": f.product.ID, "amount": 1}); code != 200 {
+		t.Fatalf("buy returned %d", code)
+	}
+	orders, _ := f.store.Orders().List(repository.OrderFilter{UserID: f.buyer.ID})
+	if len(orders) != 1 {
+		t.Fatal("the buyer has no order")
+	}
+	if err := f.store.Users().Delete(f.buyer.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	route := fmt.Sprintf("/v1/orders/%d/refund", orders[0].ID)
+	assert.Equalf(t, 400, send(route, "seller", fiber.Map{"reason": "damaged"}), "Test: refund the order of a deleted buyer, get HTTP status 400")
+
+	product, _ := f.store.Products().Find(f.product.ID)
+	order, _ := f.store.Orders().Find(orders[0].ID)
+	assert.Equalf(t, 9, product.AmountAvailable, "Test: nothing is put back on sale")
+	assert.Equalf(t, 0, order.RefundedUnits, "Test: the order is not refunded")
+}
@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"mvpmatch/config"
+	"mvpmatch/inventory"
+	"mvpmatch/models"
+	"mvpmatch/repository"
+	"mvpmatch/routes"
+	"mvpmatch/tokens"
+	"mvpmatch/wallet"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResetDeposit(t *testing.T) {
+	t.Run("memory", func(t *testing.T) { resetDeposit(t, newFixture(t)) })
+	t.Run("sqlite", func(t *testing.T) { resetDeposit(t, newSQLiteFixture(t)) })
+}
+
+// resetDeposit deposits coins into an empty machine and resets the deposit, checking
+// the coins paid out, the deposit and the coins left in the machine after every step.
+func resetDeposit(t *testing.T, f fixture) {
+
+	kiosk := models.Machine{Name: "kiosk"}
+	if err := f.store.Machines().Create(&kiosk); err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range inventory.DefaultDenominations {
+		if err := f.store.Coins().AddDenomination(kiosk.ID, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deposit := fmt.Sprintf("/v1/machines/%d/deposit", kiosk.ID)
+	reset := fmt.Sprintf("/v1/machines/%d/deposit/reset", kiosk.ID)
+
+	tests := []struct {
+		description     string      // description of the test case
+		adjust          map[int]int // coins put into or taken out of the machine before the step
+		method          string      // http method of the step
+		route           string      // route path to test
+		payload         interface{} // request body
+		expectedCode    int         // expected HTTP status code
+		expectedChange  []int       // expected coins paid out, nil when not checked
+		expectedDeposit int         // expected deposit of the buyer after the step
+		expectedCoins   map[int]int // expected coins in the machine after the step
+	}{
+		{
+			description:     "Test: reset without a deposit, get HTTP status 200",
+			method:          http.MethodPatch,
+			route:           reset,
+			expectedCode:    200,
+			expectedChange:  []int{},
+			expectedDeposit: 0,
+			expectedCoins:   map[int]int{},
+		},
+		{
+			description:     "Test: deposit a 50 coin, get HTTP status 200",
+			method:          http.MethodPost,
+			route:           deposit,
+			payload:         fiber.Map{"coin": 50},
+			expectedCode:    200,
+			expectedDeposit: 50,
+			expectedCoins:   map[int]int{50: 1},
+		},
+		{
+			description:     "Test: deposit a 20 coin, get HTTP status 200",
+			method:          http.MethodPost,
+			route:           deposit,
+			payload:         fiber.Map{"coin": 20},
+			expectedCode:    200,
+			expectedDeposit: 70,
+			expectedCoins:   map[int]int{20: 1, 50: 1},
+		},
+		{
+			description:     "Test: reset the deposit, get HTTP status 200",
+			method:          http.MethodPatch,
+			route:           reset,
+			expectedCode:    200,
+			expectedChange:  []int{50, 20},
+			expectedDeposit: 0,
+			expectedCoins:   map[int]int{20: 0, 50: 0},
+		},
+		{
+			description:     "Test: deposit a 50 coin again, get HTTP status 200",
+			method:          http.MethodPost,
+			route:           deposit,
+			payload:         fiber.Map{"coin": 50},
+			expectedCode:    200,
+			expectedDeposit: 50,
+			expectedCoins:   map[int]int{20: 0, 50: 1},
+		},
+		{
+			description:     "Test: reset once the coin was taken out, get HTTP status 400",
+			adjust:          map[int]int{50: -1},
+			method:          http.MethodPatch,
+			route:           reset,
+			expectedCode:    400,
+			expectedDeposit: 50,
+			expectedCoins:   map[int]int{20: 0, 50: 0},
+		},
+		{
+			description:     "Test: reset once smaller coins were put in, get HTTP status 200",
+			adjust:          map[int]int{10: 1, 20: 3},
+			method:          http.MethodPatch,
+			route:           reset,
+			expectedCode:    200,
+			expectedChange:  []int{20, 20, 10},
+			expectedDeposit: 0,
+			expectedCoins:   map[int]int{10: 0, 20: 1, 50: 0},
+		},
+	}
+
+	// Define Fiber app.
+	app := fiber.New()
+	routes.Routes(app, f.store, tokens.NewMemory())
+	buyerToken := mintToken(t, f.buyer.ID, config.Role.Buyer)
+
+	// Run every step in order, each one builds on the ones before it
+	for _, test := range tests {
+		if test.adjust != nil {
+			movement := models.CoinMovement{Kind: inventory.Adjustment, MachineID: kiosk.ID}
+			if err := inventory.Apply(f.store, movement, test.adjust); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var body io.Reader
+		if test.payload != nil {
+			payload, err := json.Marshal(test.payload)
+			if err != nil {
+				panic(err)
+			}
+			body = bytes.NewReader(payload)
+		}
+
+		req := httptest.NewRequest(test.method, test.route, body)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+buyerToken)
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Verify, if the status code is as expected
+		assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+
+		if test.expectedChange != nil {
+			var result struct {
+				Data struct {
+					Change []int `json:"change"`
+				} `json:"data"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equalf(t, test.expectedChange, result.Data.Change, test.description)
+		}
+
+		buyer, _ := f.store.Users().Find(f.buyer.ID)
+		assert.Equalf(t, test.expectedDeposit, buyer.Deposit, test.description)
+		assert.Equalf(t, test.expectedCoins, coinCounts(f.store, kiosk.ID), test.description)
+	}
+
+	resets, _ := f.store.Wallets().List(repository.WalletFilter{UserID: f.buyer.ID, Type: "debit"})
+	paid := 0
+	for _, entry := range resets {
+		if entry.Source == wallet.Reset {
+			paid = paid + entry.Debit
+		}
+	}
+	assert.Equalf(t, 120, paid, "Test: every coin paid out is debited from the wallet, failed resets are not")
+}
@@ -0,0 +1,295 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"mvpmatch/config"
+	"mvpmatch/routes"
+	"mvpmatch/tokens"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	t.Run("memory", func(t *testing.T) { sessions(t, newFixture(t)) })
+	t.Run("sqlite", func(t *testing.T) { sessions(t, newSQLiteFixture(t)) })
+}
+
+// sessions logs one buyer in on several devices, refreshes their tokens and ends
+// the sessions one way or another.
+func sessions(t *testing.T, f fixture) {
+
+	buyerRole, _ := f.store.Roles().FindByName(config.Role.Buyer)
+
+	tests := []struct {
+		description   string      // description of the test case
+		method        string      // http method of the step
+		route         string      // route path to test
+		as            string      // name of the session making the request
+		save          string      // name to keep the tokens of a login or refresh under
+		refresh       string      // name of the session whose refresh token is sent
+		target        string      // name of the session whose id ends the route
+		payload       interface{} // request body
+		expectedCode  int         // expected HTTP status code
+		expectedCount int         // expected number of sessions listed
+	}{
+		{
+			description:  "Test: sign up a buyer, get HTTP status 201",
+			method:       http.MethodPost,
+			route:        "/v1/user",
+			payload:      fiber.Map{"username": "dave", "password": "secret", "role_id": buyerRole.ID},
+			expectedCode: 201,
+		},
+		{
+			description:  "Test: log in on a phone, get HTTP status 200",
+			method:       http.MethodPost,
+			route:        "/v1/login",
+			save:         "phone",
+			payload:      fiber.Map{"username": "dave", "password": "secret", "device": "phone"},
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: log in on a laptop, get HTTP status 200",
+			method:       http.MethodPost,
+			route:        "/v1/login",
+			save:         "laptop",
+			payload:      fiber.Map{"username": "dave", "password": "secret", "device": "laptop"},
+			expectedCode: 200,
+		},
+		{
+			description:   "Test: list the sessions, get HTTP status 200",
+			method:        http.MethodGet,
+			route:         "/v1/sessions",
+			as:            "laptop",
+			expectedCode:  200,
+			expectedCount: 2,
+		},
+		{
+			description:  "Test: refresh without a token, get HTTP status 400",
+			method:       http.MethodPost,
+			route:        "/v1/token/refresh",
+			payload:      fiber.Map{},
+			expectedCode: 400,
+		},
+		{
+			description:  "Test: refresh with an unknown token, get HTTP status 401",
+			method:       http.MethodPost,
+			route:        "/v1/token/refresh",
+			payload:      fiber.Map{"refresh_token": "unknown"},
+			expectedCode: 401,
+		},
+		{
+			description:  "Test: refresh the phone, get HTTP status 200",
+			method:       http.MethodPost,
+			route:        "/v1/token/refresh",
+			refresh:      "phone",
+			save:         "phone-refreshed",
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: use the refreshed token, get HTTP status 200",
+			method:       http.MethodGet,
+			route:        "/v1/wallet",
+			as:           "phone-refreshed",
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: reuse the phone's first refresh token, get HTTP status 401",
+			method:       http.MethodPost,
+			route:        "/v1/token/refresh",
+			refresh:      "phone",
+			expectedCode: 401,
+		},
+		{
+			description:  "Test: refresh with the rotated token of the ended session, get HTTP status 401",
+			method:       http.MethodPost,
+			route:        "/v1/token/refresh",
+			refresh:      "phone-refreshed",
+			expectedCode: 401,
+		},
+		{
+			description:  "Test: use an access token of the ended session, get HTTP status 401",
+			method:       http.MethodGet,
+			route:        "/v1/wallet",
+			as:           "phone-refreshed",
+			expectedCode: 401,
+		},
+		{
+			description:   "Test: only the laptop is listed, get HTTP status 200",
+			method:        http.MethodGet,
+			route:         "/v1/sessions",
+			as:            "laptop",
+			expectedCode:  200,
+			expectedCount: 1,
+		},
+		{
+			description:  "Test: log in on a tablet, get HTTP status 200",
+			method:       http.MethodPost,
+			route:        "/v1/login",
+			save:         "tablet",
+			payload:      fiber.Map{"username": "dave", "password": "secret", "device": "tablet"},
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: end the tablet from the laptop, get HTTP status 200",
+			method:       http.MethodDelete,
+			route:        "/v1/sessions/",
+			as:           "laptop",
+			target:       "tablet",
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: end the tablet again, get HTTP status 404",
+			method:       http.MethodDelete,
+			route:        "/v1/sessions/",
+			as:           "laptop",
+			target:       "tablet",
+			expectedCode: 404,
+		},
+		{
+			description:  "Test: refresh the ended tablet, get HTTP status 401",
+			method:       http.MethodPost,
+			route:        "/v1/token/refresh",
+			refresh:      "tablet",
+			expectedCode: 401,
+		},
+		{
+			description:  "Test: use the ended tablet, get HTTP status 401",
+			method:       http.MethodGet,
+			route:        "/v1/wallet",
+			as:           "tablet",
+			expectedCode: 401,
+		},
+		{
+			description:  "Test: end another user's session, get HTTP status 404",
+			method:       http.MethodDelete,
+			route:        "/v1/sessions/",
+			as:           "other",
+			target:       "laptop",
+			expectedCode: 404,
+		},
+		{
+			description:  "Test: refresh the laptop, get HTTP status 200",
+			method:       http.MethodPost,
+			route:        "/v1/token/refresh",
+			refresh:      "laptop",
+			save:         "laptop-refreshed",
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: log the laptop out with its first access token, get HTTP status 200",
+			method:       http.MethodPost,
+			route:        "/v1/logout",
+			as:           "laptop",
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: use the access token refreshed before the logout, get HTTP status 401",
+			method:       http.MethodGet,
+			route:        "/v1/wallet",
+			as:           "laptop-refreshed",
+			expectedCode: 401,
+		},
+		{
+			description:  "Test: refresh the logged out laptop, get HTTP status 401",
+			method:       http.MethodPost,
+			route:        "/v1/token/refresh",
+			refresh:      "laptop-refreshed",
+			expectedCode: 401,
+		},
+	}
+
+	// Define Fiber app.
+	app := fiber.New()
+	routes.Routes(app, f.store, tokens.NewMemory())
+
+	type login struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+		SessionID    uint   `json:"session_id"`
+	}
+	logins := map[string]login{
+		"other": {Token: mintToken(t, f.seller.ID, config.Role.Seller)},
+	}
+
+	// Run every step in order, each one builds on the ones before it
+	for _, test := range tests {
+		payload := test.payload
+		if test.refresh != "" {
+			payload = fiber.Map{"refresh_token": logins[test.refresh].RefreshToken}
+		}
+
+		var body io.Reader
+		if payload != nil {
+			data, err := json.Marshal(payload)
+			if err != nil {
+				panic(err)
+			}
+			body = bytes.NewReader(data)
+		}
+
+		route := test.route
+		if test.target != "" {
+			route += fmt.Sprint(logins[test.target].SessionID)
+		}
+
+		req := httptest.NewRequest(test.method, route, body)
+		req.Header.Set("Content-Type", "application/json")
+		if test.as != "" {
+			req.Header.Set("Authorization", "Bearer "+logins[test.as].Token)
+		}
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Verify, if the status code is as expected
+		assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+		if resp.StatusCode != 200 {
+			continue
+		}
+
+		if test.save != "" {
+			var response struct {
+				Data login `json:"data"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			logins[test.save] = response.Data
+		}
+
+		if test.expectedCount > 0 {
+			var response struct {
+				Data []struct {
+					ID      uint `json:"id"`
+					Current bool `json:"current"`
+				} `json:"data"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equalf(t, test.expectedCount, len(response.Data), test.description)
+			for _, session := range response.Data {
+				assert.Equalf(t, session.ID == logins[test.as].SessionID, session.Current, "Test: the calling session is marked current")
+			}
+		}
+	}
+
+	buyer, err := f.store.Users().FindByUsername("dave")
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, err := f.store.Sessions().List(buyer.ID, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equalf(t, 0, len(active), "Test: every session has ended")
+}
@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"mvpmatch/cli"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestGracefulShutdown(t *testing.T) {
+
+	tests := []struct {
+		description   string        // description of the test case
+		handlerTime   time.Duration // how long the request in flight takes
+		timeout       time.Duration // how long shutdown waits for it
+		expectedCode  int           // expected HTTP status code of the request in flight
+		expectedError bool          // expected shutdown to report requests cut short
+	}{
+		{
+			description:  "Test: request in flight finishes before shutdown, get HTTP status 200",
+			handlerTime:  300 * time.Millisecond,
+			timeout:      5 * time.Second,
+			expectedCode: 200,
+		},
+		{
+			description:   "Test: request outlives the shutdown timeout, get an error",
+			handlerTime:   2 * time.Second,
+			timeout:       100 * time.Millisecond,
+			expectedCode:  200,
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		started := make(chan struct{})
+
+		app := fiber.New(fiber.Config{DisableStartupMessage: true})
+		app.Get("slow", func(c *fiber.Ctx) error {
+			close(started)
+			time.Sleep(test.handlerTime)
+			return c.SendStatus(200)
+		})
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		url := "http://" + ln.Addr().String() + "/slow"
+
+		quit := make(chan os.Signal, 1)
+		stopped := make(chan error, 1)
+		go func() {
+			stopped <- cli.ServeUntil(app, ln, quit, test.timeout)
+		}()
+
+		client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		responses := make(chan int, 1)
+		go func() {
+			resp, err := client.Get(url)
+			if err != nil {
+				responses <- 0
+				return
+			}
+			resp.Body.Close()
+			responses <- resp.StatusCode
+		}()
+
+		//stop the server while the request is running
+		<-started
+		quit <- syscall.SIGTERM
+
+		err = <-stopped
+		assert.Equalf(t, test.expectedError, err != nil, test.description)
+
+		_, err = client.Get(url)
+		assert.Equalf(t, true, err != nil, "Test: no new connections after shutdown")
+
+		assert.Equalf(t, test.expectedCode, <-responses, test.description)
+	}
+}
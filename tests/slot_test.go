@@ -0,0 +1,300 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"mvpmatch/config"
+	"mvpmatch/models"
+	"mvpmatch/routes"
+	"mvpmatch/tokens"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSlots(t *testing.T) {
+	t.Run("memory", func(t *testing.T) { slots(t, newFixture(t)) })
+	t.Run("sqlite", func(t *testing.T) { slots(t, newSQLiteFixture(t)) })
+}
+
+// slots has an admin add two slots, the seller assign and restock them and a buyer buy
+// out of them, checking the fill of every slot and the stock derived from it after every step.
+// The stock the product had before it was in a slot moves into its first one.
+func slots(t *testing.T, f fixture) {
+
+	adminRole, _ := f.store.Roles().FindByName(config.Role.Admin)
+	sellerRole, _ := f.store.Roles().FindByName(config.Role.Seller)
+
+	moderator := models.User{Username: "moderator", RoleID: adminRole.ID}
+	if err := f.store.Users().Create(&moderator); err != nil {
+		t.Fatal(err)
+	}
+	rival := models.User{Username: "rival", RoleID: sellerRole.ID}
+	if err := f.store.Users().Create(&rival); err != nil {
+		t.Fatal(err)
+	}
+	gum := models.Product{MachineID: f.machine.ID, Cost: 10, ProductName: "gum", SellerID: f.seller.ID}
+	if err := f.store.Products().Create(&gum); err != nil {
+		t.Fatal(err)
+	}
+
+	route := func(path string) string {
+		return fmt.Sprintf("/v1/machines/%d/%s", f.machine.ID, path)
+	}
+
+	tests := []struct {
+		description   string      // description of the test case
+		method        string      // http method of the step
+		route         string      // route path to test
+		as            string      // name of the token making the request
+		fund          int         // deposit credited to the buyer before the step
+		payload       interface{} // request body
+		expectedCode  int         // expected HTTP status code
+		expectedA1    int         // expected fill of slot A1 after the step
+		expectedB1    int         // expected fill of slot B1 after the step
+		expectedStock int         // expected stock of the product after the step
+	}{
+		{
+			description:   "Test: add slot A1, get HTTP status 201",
+			method:        http.MethodPost,
+			route:         route("slots"),
+			as:            "admin",
+			payload:       fiber.Map{"code": "A1", "capacity": 4},
+			expectedCode:  201,
+			expectedStock: 10,
+		},
+		{
+			description:   "Test: add slot a1 again, get HTTP status 400",
+			method:        http.MethodPost,
+			route:         route("slots"),
+			as:            "admin",
+			payload:       fiber.Map{"code": "a1", "capacity": 4},
+			expectedCode:  400,
+			expectedStock: 10,
+		},
+		{
+			description:   "Test: add slot B1, get HTTP status 201",
+			method:        http.MethodPost,
+			route:         route("slots"),
+			as:            "admin",
+			payload:       fiber.Map{"code": "B1", "capacity": 3},
+			expectedCode:  201,
+			expectedStock: 10,
+		},
+		{
+			description:   "Test: restock a slot without a product, get HTTP status 400",
+			method:        http.MethodPut,
+			route:         route("slots/A1/restock"),
+			as:            "seller",
+			payload:       fiber.Map{"amount": 1},
+			expectedCode:  400,
+			expectedStock: 10,
+		},
+		{
+			description:   "Test: assign another seller's product, get HTTP status 400",
+			method:        http.MethodPut,
+			route:         route("slots/A1/assign"),
+			as:            "rival",
+			payload:       fiber.Map{"product_id": f.product.ID},
+			expectedCode:  400,
+			expectedStock: 10,
+		},
+		{
+			description:   "Test: assign the product to A1 holding less than its stock, get HTTP status 400",
+			method:        http.MethodPut,
+			route:         route("slots/A1/assign"),
+			as:            "seller",
+			payload:       fiber.Map{"product_id": f.product.ID},
+			expectedCode:  400,
+			expectedStock: 10,
+		},
+		{
+			description:   "Test: set the stock of the product while it is in no slot, get HTTP status 200",
+			method:        http.MethodPut,
+			route:         "/v1/product",
+			as:            "seller",
+			payload:       fiber.Map{"product_id": f.product.ID, "product_name": "product", "cost": 20, "amount_available": 3},
+			expectedCode:  200,
+			expectedStock: 3,
+		},
+		{
+			description:   "Test: assign the product to A1, its stock moves into the slot, get HTTP status 200",
+			method:        http.MethodPut,
+			route:         route("slots/A1/assign"),
+			as:            "seller",
+			payload:       fiber.Map{"product_id": f.product.ID},
+			expectedCode:  200,
+			expectedA1:    3,
+			expectedStock: 3,
+		},
+		{
+			description:   "Test: restock A1 past its capacity, get HTTP status 400",
+			method:        http.MethodPut,
+			route:         route("slots/A1/restock"),
+			as:            "seller",
+			payload:       fiber.Map{"amount": 2},
+			expectedCode:  400,
+			expectedA1:    3,
+			expectedStock: 3,
+		},
+		{
+			description:   "Test: restock A1 to its capacity, get HTTP status 200",
+			method:        http.MethodPut,
+			route:         route("slots/A1/restock"),
+			as:            "seller",
+			payload:       fiber.Map{"amount": 1},
+			expectedCode:  200,
+			expectedA1:    4,
+			expectedStock: 4,
+		},
+		{
+			description:   "Test: restock A1 as another seller, get HTTP status 401",
+			method:        http.MethodPut,
+			route:         route("slots/A1/restock"),
+			as:            "rival",
+			payload:       fiber.Map{"amount": 1},
+			expectedCode:  401,
+			expectedA1:    4,
+			expectedStock: 4,
+		},
+		{
+			description:   "Test: set the stock of a slotted product directly, get HTTP status 400",
+			method:        http.MethodPut,
+			route:         "/v1/product",
+			as:            "seller",
+			payload:       fiber.Map{"product_id": f.product.ID, "product_name": "product", "cost": 20, "amount_available": 9},
+			expectedCode:  400,
+			expectedA1:    4,
+			expectedStock: 4,
+		},
+		{
+			description:   "Test: assign the product to B1 as well, get HTTP status 200",
+			method:        http.MethodPut,
+			route:         route("slots/B1/assign"),
+			as:            "seller",
+			payload:       fiber.Map{"product_id": f.product.ID},
+			expectedCode:  200,
+			expectedA1:    4,
+			expectedStock: 4,
+		},
+		{
+			description:   "Test: restock B1, get HTTP status 200",
+			method:        http.MethodPut,
+			route:         route("slots/B1/restock"),
+			as:            "seller",
+			payload:       fiber.Map{"amount": 2},
+			expectedCode:  200,
+			expectedA1:    4,
+			expectedB1:    2,
+			expectedStock: 6,
+		},
+		{
+			description:   "Test: buy more out of B1 than it holds, get HTTP status 400",
+			method:        http.MethodPost,
+			route:         route("buy"),
+			as:            "buyer",
+			fund:          40,
+			payload:       fiber.Map{"slot": "b1", "amount": 3},
+			expectedCode:  400,
+			expectedA1:    4,
+			expectedB1:    2,
+			expectedStock: 6,
+		},
+		{
+			description:   "Test: buy out of B1, get HTTP status 200",
+			method:        http.MethodPost,
+			route:         route("buy"),
+			as:            "buyer",
+			payload:       fiber.Map{"slot": "b1", "amount": 2},
+			expectedCode:  200,
+			expectedA1:    4,
+			expectedStock: 4,
+		},
+		{
+			description:   "Test: buy by product, the fullest slot is emptied first, get HTTP status 200",
+			method:        http.MethodPost,
+			route:         route("buy"),
+			as:            "buyer",
+			fund:          60,
+			payload:       fiber.Map{"product_id": f.product.ID, "amount": 3},
+			expectedCode:  200,
+			expectedA1:    1,
+			expectedStock: 1,
+		},
+		{
+			description:   "Test: put gum in the empty B1, get HTTP status 200",
+			method:        http.MethodPut,
+			route:         route("slots/B1/assign"),
+			as:            "seller",
+			payload:       fiber.Map{"product_id": gum.ID},
+			expectedCode:  200,
+			expectedA1:    1,
+			expectedStock: 1,
+		},
+		{
+			description:   "Test: put gum in A1 while it still holds the product, get HTTP status 400",
+			method:        http.MethodPut,
+			route:         route("slots/A1/assign"),
+			as:            "seller",
+			payload:       fiber.Map{"product_id": gum.ID},
+			expectedCode:  400,
+			expectedA1:    1,
+			expectedStock: 1,
+		},
+	}
+
+	// Define Fiber app.
+	app := fiber.New()
+	routes.Routes(app, f.store, tokens.NewMemory())
+
+	logins := map[string]string{
+		"admin":  mintToken(t, moderator.ID, config.Role.Admin),
+		"seller": mintToken(t, f.seller.ID, config.Role.Seller),
+		"rival":  mintToken(t, rival.ID, config.Role.Seller),
+		"buyer":  mintToken(t, f.buyer.ID, config.Role.Buyer),
+	}
+
+	// Run every step in order, each one builds on the ones before it
+	for _, test := range tests {
+		if test.fund > 0 {
+			f.fund(t, f.buyer.ID, test.fund)
+		}
+
+		var body io.Reader
+		if test.payload != nil {
+			payload, err := json.Marshal(test.payload)
+			if err != nil {
+				panic(err)
+			}
+			body = bytes.NewReader(payload)
+		}
+
+		req := httptest.NewRequest(test.method, test.route, body)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+logins[test.as])
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Verify, if the status code is as expected
+		assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+
+		a1, _ := f.store.Slots().Find(f.machine.ID, "A1")
+		b1, _ := f.store.Slots().Find(f.machine.ID, "B1")
+		product, _ := f.store.Products().Find(f.product.ID)
+		assert.Equalf(t, test.expectedA1, a1.Fill, test.description)
+		assert.Equalf(t, test.expectedB1, b1.Fill, test.description)
+		assert.Equalf(t, test.expectedStock, product.AmountAvailable, test.description)
+	}
+
+	b1, _ := f.store.Slots().Find(f.machine.ID, "B1")
+	assert.Equalf(t, gum.ID, b1.ProductID, "Test: B1 carries the gum")
+	buyer, _ := f.store.Users().Find(f.buyer.ID)
+	assert.Equalf(t, 0, buyer.Deposit, "Test: every buy spent the exact deposit")
+}
@@ -0,0 +1,158 @@
+package tests
+
+import (
+	"fmt"
+	"mvpmatch/config"
+	"mvpmatch/database"
+	"mvpmatch/inventory"
+	"mvpmatch/models"
+	"mvpmatch/permissions"
+	"mvpmatch/repository"
+	"mvpmatch/wallet"
+	"os"
+	"testing"
+	"time"
+)
+
+type fixture struct {
+	store   repository.Store
+	machine models.Machine
+	seller  models.User
+	buyer   models.User
+	product models.Product
+}
+
+// newFixture fills an in-memory store with the roles and their permissions, a default machine,
+// a seller with one product and a buyer, so tests need no database.
+func newFixture(t *testing.T) fixture {
+	store := repository.NewMemory()
+
+	for _, name := range []string{config.Role.Buyer, config.Role.Seller, config.Role.Admin} {
+		role := models.Role{Name: name, SelfAssignable: name == config.Role.Buyer}
+		if err := store.Roles().Create(&role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := permissions.Seed(store); err != nil {
+		t.Fatal(err)
+	}
+
+	machine := models.Machine{Name: "default"}
+	if err := store.Machines().Create(&machine); err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range inventory.DefaultDenominations {
+		if err := store.Coins().AddDenomination(machine.ID, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return seedFixture(t, store)
+}
+
+// newSQLiteFixture migrates and seeds a fresh SQLite database in the test's temporary
+// directory, then adds the same seller, product and buyer as newFixture.
+func newSQLiteFixture(t *testing.T) fixture {
+	db := openSQLite(t)
+
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	database.Seed(db)
+
+	return seedFixture(t, repository.NewGorm(db))
+}
+
+// newMySQLFixture migrates and seeds a throwaway database on the server in TestMySQLDSN,
+// a DSN without a database name such as root:secret@tcp(127.0.0.1:3306)/. Unlike the other
+// fixtures it runs transactions side by side, tests using it are skipped without a server.
+func newMySQLFixture(t *testing.T) fixture {
+	server := os.Getenv("TestMySQLDSN")
+	if server == "" {
+		t.Skip("TestMySQLDSN is not set")
+	}
+
+	root, err := database.Open(database.MySQL, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := fmt.Sprintf("mvpmatch_test_%d", time.Now().UnixNano())
+	if err := root.Exec("CREATE DATABASE " + name).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		root.Exec("DROP DATABASE " + name)
+		if sqlDB, err := root.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	db, err := database.Open(database.MySQL, server+name+"?charset=utf8&parseTime=True&loc=Local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	database.Seed(db)
+
+	return seedFixture(t, repository.NewGorm(db))
+}
+
+func seedFixture(t *testing.T, store repository.Store) fixture {
+	f := fixture{store: store}
+
+	var err error
+	f.machine, err = store.Machines().Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seller, err := store.Roles().FindByName(config.Role.Seller)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buyer, err := store.Roles().FindByName(config.Role.Buyer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.seller = models.User{Username: "seller", RoleID: seller.ID}
+	if err := store.Users().Create(&f.seller); err != nil {
+		t.Fatal(err)
+	}
+	f.buyer = models.User{Username: "buyer", RoleID: buyer.ID}
+	if err := store.Users().Create(&f.buyer); err != nil {
+		t.Fatal(err)
+	}
+
+	f.product = models.Product{
+		MachineID:       f.machine.ID,
+		AmountAvailable: 10,
+		Cost:            20,
+		ProductName:     "product",
+		SellerID:        f.seller.ID,
+	}
+	if err := store.Products().Create(&f.product); err != nil {
+		t.Fatal(err)
+	}
+
+	return f
+}
+
+// fund credits a buyer's deposit in the fixture machine the way a deposit would.
+func (f fixture) fund(t *testing.T, userID uint, amount int) {
+	if err := f.store.Users().SetDepositMachine(userID, f.machine.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, err := wallet.Post(f.store, models.Wallet{UserID: userID, Credit: amount, Source: wallet.Deposit})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
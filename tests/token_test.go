@@ -0,0 +1,238 @@
+package tests
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"mvpmatch/config"
+	"mvpmatch/models"
+	"mvpmatch/routes"
+	"mvpmatch/tokens"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// mintToken signs an access token for a user without a login, it only exists in test builds.
+func mintToken(t *testing.T, userID uint, role string) string {
+	token, err := tokens.Sign(models.User{ID: userID, Role: models.Role{Name: role}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestTokenLifetimes(t *testing.T) {
+
+	saved := config.Token
+	t.Cleanup(func() { config.Token = saved })
+
+	config.Token.Lifetime = time.Hour
+	config.Token.SellerLifetime = 0
+	config.Token.BuyerLifetime = time.Hour * 8
+	config.Token.AdminLifetime = time.Minute * 15
+
+	tests := []struct {
+		description      string        // description of the test case
+		role             string        // role the token is minted for
+		expectedLifetime time.Duration // expected lifetime of the token
+	}{
+		{
+			description:      "Test: a role without a setting gets the default",
+			role:             config.Role.Seller,
+			expectedLifetime: time.Hour,
+		},
+		{
+			description:      "Test: a role with a longer setting",
+			role:             config.Role.Buyer,
+			expectedLifetime: time.Hour * 8,
+		},
+		{
+			description:      "Test: a role with a shorter setting",
+			role:             config.Role.Admin,
+			expectedLifetime: time.Minute * 15,
+		},
+		{
+			description:      "Test: an unknown role gets the default",
+			role:             "guest",
+			expectedLifetime: time.Hour,
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equalf(t, test.expectedLifetime, tokens.Lifetime(test.role), test.description)
+
+		signed := mintToken(t, 1, test.role)
+		claims, err := tokens.Keys().Parse(signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		issuedAt, _ := tokens.IssuedAt(claims)
+		expiresAt, _ := tokens.ExpiresAt(claims)
+		assert.InDeltaf(t, test.expectedLifetime.Seconds(), expiresAt.Sub(issuedAt).Seconds(), 1, test.description)
+	}
+
+	assert.Equalf(t, time.Hour*8, tokens.MaxLifetime(), "Test: the longest lifetime bounds revocations")
+}
+
+func TestKeyRotation(t *testing.T) {
+
+	dir := t.TempDir()
+	newKeySet := func(name string, key interface{}, verify ...string) (*tokens.KeySet, string) {
+		file := filepath.Join(dir, name)
+		if err := writeKey(file, key); err != nil {
+			t.Fatal(err)
+		}
+		set, err := tokens.ReadKeys(file, verify)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return set, file
+	}
+
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old, oldFile := newKeySet("old.pem", oldKey)
+	rotated, _ := newKeySet("new.pem", newKey, oldFile)
+	_, edFile := newKeySet("ed.pem", edKey)
+
+	claims := jwt.MapClaims{"uid": 1}
+	tokens.Issue(claims, time.Minute)
+	signedOld, err := old.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedNew, err := rotated.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = rotated.Parse(signedOld)
+	assert.Equalf(t, nil, err, "Test: tokens of the replaced key still verify")
+	_, err = rotated.Parse(signedNew)
+	assert.Equalf(t, nil, err, "Test: tokens of the new key verify")
+	_, err = old.Parse(signedNew)
+	assert.Equalf(t, true, err != nil, "Test: the old set does not know the new key")
+
+	parsed, _, err := new(jwt.Parser).ParseUnverified(signedNew, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equalf(t, rotated.ID(), parsed.Header["kid"], "Test: tokens name their key")
+	assert.Equalf(t, "RS256", parsed.Header["alg"], "Test: rsa keys sign RS256")
+
+	jwks := rotated.JWKS()
+	assert.Equalf(t, 2, len(jwks.Keys), "Test: the new and the replaced key are published")
+	assert.Equalf(t, rotated.ID(), jwks.Keys[0].KeyID, "Test: the signing key comes first")
+	assert.Equalf(t, old.ID(), jwks.Keys[1].KeyID, "Test: the replaced key keeps its id")
+	assert.Equalf(t, "RSA", jwks.Keys[1].KeyType, "Test: rsa keys are published as RSA")
+
+	_, err = tokens.ReadKeys(filepath.Join(dir, "new.pem"), []string{edFile})
+	assert.Equalf(t, true, err != nil, "Test: a set cannot mix algorithms")
+	_, err = tokens.ReadKeys(filepath.Join(dir, "missing.pem"), nil)
+	assert.Equalf(t, true, err != nil, "Test: a missing key file is an error")
+}
+
+func TestJWKSRoute(t *testing.T) {
+
+	f := newFixture(t)
+
+	// Define Fiber app.
+	app := fiber.New()
+	routes.Routes(app, f.store, tokens.NewMemory())
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equalf(t, 200, resp.StatusCode, "Test: the key set is public")
+
+	var jwks tokens.JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		t.Fatal(err)
+	}
+	if assert.Equalf(t, 1, len(jwks.Keys), "Test: one key is published") {
+		key := jwks.Keys[0]
+		assert.Equalf(t, tokens.Keys().ID(), key.KeyID, "Test: the signing key is published")
+		assert.Equalf(t, "OKP", key.KeyType, "Test: ed25519 keys are published as OKP")
+		assert.Equalf(t, "EdDSA", key.Algorithm, "Test: ed25519 keys sign EdDSA")
+		assert.Equalf(t, "sig", key.Use, "Test: the key is for signatures")
+		assert.Equalf(t, "", key.N, "Test: no rsa members on an ed25519 key")
+	}
+
+	//a shared secret no longer signs tokens the service accepts
+	secret := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"uid": f.buyer.ID, "role": config.Role.Buyer})
+	hs256, err := secret.SignedString([]byte("shared-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//nor does a key the service does not know
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := tokens.NewKeySet(otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := jwt.MapClaims{"uid": f.buyer.ID, "role": config.Role.Buyer}
+	tokens.Issue(claims, time.Minute)
+	unknown, err := other.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		description  string // description of the test case
+		token        string // token sent with the request
+		expectedCode int    // expected HTTP status code
+	}{
+		{
+			description:  "Test: token signed by the service, get HTTP status 200",
+			token:        mintToken(t, f.buyer.ID, config.Role.Buyer),
+			expectedCode: 200,
+		},
+		{
+			description:  "Test: token signed with a shared secret, get HTTP status 401",
+			token:        hs256,
+			expectedCode: 401,
+		},
+		{
+			description:  "Test: token signed by an unknown key, get HTTP status 401",
+			token:        unknown,
+			expectedCode: 401,
+		},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/v1/wallet", nil)
+		req.Header.Set("Authorization", "Bearer "+test.token)
+
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Verify, if the status code is as expected
+		assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+	}
+}
@@ -0,0 +1,267 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"log"
+	"mvpmatch/config"
+	"mvpmatch/handlers"
+	"mvpmatch/middleware"
+	"mvpmatch/models"
+	"mvpmatch/routes"
+	"mvpmatch/tokens"
+	"mvpmatch/wallet"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWalletRoute(t *testing.T) {
+
+	f := newFixture(t)
+	buyerToken := mintToken(t, f.buyer.ID, config.Role.Buyer)
+
+	tests := []struct {
+		description  string // description of the test case
+		route        string // route path to test
+		expectedCode int    // expected HTTP status code
+		token        string
+	}{
+		{
+			description:  "Test: is endpoint secured, get http status 400 ",
+			route:        "/wallet",
+			expectedCode: 400,
+			token:        "",
+		},
+		{
+			description:  "Test: list wallet entries, get HTTP status 200",
+			route:        "/wallet?type=credit&from=2021-01-01",
+			expectedCode: 200,
+			token:        buyerToken,
+		},
+		{
+			description:  "Test: filter by an unknown type, get HTTP status 400",
+			route:        "/wallet?type=refund",
+			expectedCode: 400,
+			token:        buyerToken,
+		},
+		{
+			description:  "Test: filter by an invalid date, get HTTP status 400",
+			route:        "/wallet?from=yesterday",
+			expectedCode: 400,
+			token:        buyerToken,
+		},
+		{
+			description:  "Test: get an entry that does not exist, get HTTP status 404",
+			route:        "/wallet/999999999",
+			expectedCode: 404,
+			token:        buyerToken,
+		},
+	}
+
+	// Define Fiber app.
+	app := fiber.New()
+	h := handlers.New(f.store, nil)
+	jwtToken := middleware.JWT(tokens.Keys(), nil)
+	app.Get("wallet", jwtToken, h.GetWallet)
+	app.Get("wallet/:id", jwtToken, h.GetWalletEntry)
+
+	// Iterate through test single test cases
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, test.route, nil)
+		req.Header.Set("Authorization", "Bearer "+test.token)
+
+		// Perform the request plain with the app,
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			log.Println(err)
+		}
+
+		// Verify, if the status code is as expected
+		assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	t.Run("memory", func(t *testing.T) { reconcile(t, newFixture(t)) })
+	t.Run("sqlite", func(t *testing.T) { reconcile(t, newSQLiteFixture(t)) })
+}
+
+// reconcile moves one deposit through the ledger and another around it,
+// and checks only the second one is reported.
+func reconcile(t *testing.T, f fixture) {
+
+	f.fund(t, f.buyer.ID, 50)
+	f.fund(t, f.seller.ID, 20)
+	if err := f.store.Users().SetDeposit(f.seller.ID, 35); err != nil {
+		t.Fatal(err)
+	}
+
+	mismatches, err := wallet.Reconcile(f.store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []wallet.Mismatch{{UserID: f.seller.ID, Deposit: 35, Ledger: 20}}
+	assert.Equalf(t, expected, mismatches, "Test: only the deposit moved outside the ledger is reported")
+}
+
+func TestWalletHistory(t *testing.T) {
+	t.Run("memory", func(t *testing.T) { walletHistory(t, newFixture(t)) })
+	t.Run("sqlite", func(t *testing.T) { walletHistory(t, newSQLiteFixture(t)) })
+}
+
+// walletHistory posts a known ledger for the buyer and pages through it,
+// checking the entries, their running balances and the cursor of every page.
+func walletHistory(t *testing.T, f fixture) {
+
+	var ledger []models.Wallet
+	for _, entry := range []models.Wallet{
+		{UserID: f.buyer.ID, Credit: 50, Source: wallet.Deposit},
+		{UserID: f.buyer.ID, Credit: 20, Source: wallet.Deposit},
+		{UserID: f.buyer.ID, Debit: 40, Source: wallet.Purchase, ReferenceID: 7},
+		{UserID: f.buyer.ID, Debit: 30, Source: wallet.Change, ReferenceID: 7},
+		{UserID: f.buyer.ID, Credit: 100, Source: wallet.Deposit},
+		{UserID: f.buyer.ID, Credit: 20, Source: wallet.Refund, ReferenceID: 7},
+	} {
+		posted, err := wallet.Post(f.store, entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ledger = append(ledger, posted)
+	}
+	other, err := wallet.Post(f.store, models.Wallet{UserID: f.seller.ID, Credit: 10, Source: wallet.Deposit})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	today := time.Now().Format("2006-01-02")
+	yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+
+	tests := []struct {
+		description      string // description of the test case
+		route            string // route path to test
+		expectedIDs      []uint // expected entries, newest first
+		expectedTypes    []string
+		expectedAmounts  []int
+		expectedBalances []int // expected balance after each entry
+		expectedCursor   uint  // expected cursor of the next page, 0 on the last one
+	}{
+		{
+			description:      "Test: the first page holds the newest entries",
+			route:            "/v1/wallet?limit=4",
+			expectedIDs:      []uint{ledger[5].ID, ledger[4].ID, ledger[3].ID, ledger[2].ID},
+			expectedTypes:    []string{"credit", "credit", "debit", "debit"},
+			expectedAmounts:  []int{20, 100, 30, 40},
+			expectedBalances: []int{120, 100, 0, 30},
+			expectedCursor:   ledger[2].ID,
+		},
+		{
+			description:      "Test: the cursor continues where the first page ended",
+			route:            fmt.Sprintf("/v1/wallet?limit=4&cursor=%d", ledger[2].ID),
+			expectedIDs:      []uint{ledger[1].ID, ledger[0].ID},
+			expectedTypes:    []string{"credit", "credit"},
+			expectedAmounts:  []int{20, 50},
+			expectedBalances: []int{70, 50},
+		},
+		{
+			description:      "Test: debits keep the balance they left",
+			route:            "/v1/wallet?type=debit",
+			expectedIDs:      []uint{ledger[3].ID, ledger[2].ID},
+			expectedTypes:    []string{"debit", "debit"},
+			expectedAmounts:  []int{30, 40},
+			expectedBalances: []int{0, 30},
+		},
+		{
+			description:      "Test: a full page of credits has a cursor",
+			route:            "/v1/wallet?type=credit&limit=2",
+			expectedIDs:      []uint{ledger[5].ID, ledger[4].ID},
+			expectedTypes:    []string{"credit", "credit"},
+			expectedAmounts:  []int{20, 100},
+			expectedBalances: []int{120, 100},
+			expectedCursor:   ledger[4].ID,
+		},
+		{
+			description:      "Test: entries of today are inside a range ending today",
+			route:            "/v1/wallet?limit=1&from=" + today + "&to=" + today,
+			expectedIDs:      []uint{ledger[5].ID},
+			expectedTypes:    []string{"credit"},
+			expectedAmounts:  []int{20},
+			expectedBalances: []int{120},
+			expectedCursor:   ledger[5].ID,
+		},
+		{
+			description: "Test: entries of today are outside a range ending yesterday",
+			route:       "/v1/wallet?to=" + yesterday,
+		},
+	}
+
+	// Define Fiber app.
+	app := fiber.New()
+	routes.Routes(app, f.store, tokens.NewMemory())
+	buyerToken := mintToken(t, f.buyer.ID, config.Role.Buyer)
+
+	get := func(route string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, route, nil)
+		req.Header.Set("Authorization", "Bearer "+buyerToken)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	type entry struct {
+		ID          uint   `json:"id"`
+		Type        string `json:"type"`
+		Amount      int    `json:"amount"`
+		Balance     int    `json:"balance"`
+		Source      string `json:"source"`
+		ReferenceID uint   `json:"reference_id"`
+	}
+
+	for _, test := range tests {
+		resp := get(test.route)
+		assert.Equalf(t, 200, resp.StatusCode, test.description)
+
+		var result struct {
+			Data struct {
+				Entries    []entry `json:"entries"`
+				NextCursor uint    `json:"next_cursor"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+
+		var ids []uint
+		var types []string
+		var amounts, balances []int
+		for _, item := range result.Data.Entries {
+			ids = append(ids, item.ID)
+			types = append(types, item.Type)
+			amounts = append(amounts, item.Amount)
+			balances = append(balances, item.Balance)
+		}
+		assert.Equalf(t, test.expectedIDs, ids, test.description)
+		assert.Equalf(t, test.expectedTypes, types, test.description)
+		assert.Equalf(t, test.expectedAmounts, amounts, test.description)
+		assert.Equalf(t, test.expectedBalances, balances, test.description)
+		assert.Equalf(t, test.expectedCursor, result.Data.NextCursor, test.description)
+	}
+
+	resp := get(fmt.Sprintf("/v1/wallet/%d", ledger[3].ID))
+	var single struct {
+		Data entry `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&single); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equalf(t, entry{ID: ledger[3].ID, Type: "debit", Amount: 30, Balance: 0, Source: wallet.Change, ReferenceID: 7}, single.Data, "Test: read one entry")
+
+	resp = get(fmt.Sprintf("/v1/wallet/%d", other.ID))
+	assert.Equalf(t, 404, resp.StatusCode, "Test: the entries of other users are not found")
+}
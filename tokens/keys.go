@@ -0,0 +1,319 @@
+package tokens
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+	"math/big"
+	"mvpmatch/config"
+	"os"
+	"sync"
+)
+
+// minRSABits is the smallest RSA key tokens are signed or verified with.
+const minRSABits = 2048
+
+// KeySet signs tokens with one private key and verifies them with its public key
+// and the public keys of the keys it replaced, so tokens signed before a rotation
+// stay valid until they expire. Every key of a set uses the same algorithm.
+type KeySet struct {
+	method jwt.SigningMethod
+	signer crypto.Signer
+	id     string
+	keys   []Key
+	byID   map[string]interface{}
+}
+
+// Key is a public key tokens are verified with, ID is its RFC 7638 thumbprint.
+type Key struct {
+	ID        string
+	Algorithm string
+	Public    crypto.PublicKey
+}
+
+// JWK is a public key as published in a JWKS document.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+var (
+	keysMu sync.RWMutex
+	keys   *KeySet
+)
+
+// LoadKeys reads the key files named by the configuration and makes them the ones
+// tokens are signed and verified with.
+func LoadKeys() error {
+	set, err := ReadKeys(config.Token.KeyFile, config.Token.VerifyKeyFiles)
+	if err != nil {
+		return err
+	}
+	keysMu.Lock()
+	keys = set
+	keysMu.Unlock()
+	return nil
+}
+
+// Keys returns the key set loaded last, nil before LoadKeys succeeded.
+func Keys() *KeySet {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+	return keys
+}
+
+// ReadKeys builds a key set from a PEM private key to sign with and the PEM files of
+// retired keys, public or private, that tokens are still verified with.
+func ReadKeys(keyFile string, verifyFiles []string) (*KeySet, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "tokens: unable to read signing key")
+	}
+	signer, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "tokens: "+keyFile)
+	}
+
+	set, err := NewKeySet(signer)
+	if err != nil {
+		return nil, errors.Wrap(err, "tokens: "+keyFile)
+	}
+
+	for _, file := range verifyFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "tokens: unable to read verification key")
+		}
+		public, err := parsePublicKey(data)
+		if err != nil {
+			return nil, errors.Wrap(err, "tokens: "+file)
+		}
+		if err := set.AddVerifyKey(public); err != nil {
+			return nil, errors.Wrap(err, "tokens: "+file)
+		}
+	}
+
+	return set, nil
+}
+
+// NewKeySet returns a key set signing with an RSA or Ed25519 private key.
+func NewKeySet(signer crypto.Signer) (*KeySet, error) {
+	set := &KeySet{signer: signer, byID: map[string]interface{}{}}
+
+	method, err := methodOf(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	set.method = method
+
+	if err := set.AddVerifyKey(signer.Public()); err != nil {
+		return nil, err
+	}
+	set.id = set.keys[0].ID
+
+	return set, nil
+}
+
+// AddVerifyKey accepts tokens signed by the private key of public, it has to use the
+// algorithm the set signs with.
+func (k *KeySet) AddVerifyKey(public crypto.PublicKey) error {
+	method, err := methodOf(public)
+	if err != nil {
+		return err
+	}
+	if method.Alg() != k.method.Alg() {
+		return errors.New("verification key uses " + method.Alg() + " but tokens are signed with " + k.method.Alg())
+	}
+
+	id, err := thumbprint(public)
+	if err != nil {
+		return err
+	}
+	if _, ok := k.byID[id]; ok {
+		return nil
+	}
+
+	k.keys = append(k.keys, Key{ID: id, Algorithm: method.Alg(), Public: public})
+	k.byID[id] = public
+	return nil
+}
+
+// Algorithm is the alg header of the tokens the set signs.
+func (k *KeySet) Algorithm() string {
+	return k.method.Alg()
+}
+
+// ID is the kid header of the tokens the set signs.
+func (k *KeySet) ID() string {
+	return k.id
+}
+
+// VerifyKeys maps every key ID to the public key the tokens carrying it are verified with.
+func (k *KeySet) VerifyKeys() map[string]interface{} {
+	verify := make(map[string]interface{}, len(k.byID))
+	for id, key := range k.byID {
+		verify[id] = key
+	}
+	return verify
+}
+
+// Sign signs claims with the private key and names it in the kid header.
+func (k *KeySet) Sign(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(k.method, claims)
+	token.Header["kid"] = k.id
+	return token.SignedString(k.signer)
+}
+
+// Parse verifies a token against the keys of the set and returns its claims.
+func (k *KeySet) Parse(token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method.Alg() != k.method.Alg() {
+			return nil, errors.New("unexpected signing method " + t.Method.Alg())
+		}
+		kid, _ := t.Header["kid"].(string)
+		key, ok := k.byID[kid]
+		if !ok {
+			return nil, errors.New("unknown key id " + kid)
+		}
+		return key, nil
+	})
+	return claims, err
+}
+
+// JWKS publishes the public keys of the set, the signing key first.
+func (k *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: make([]JWK, 0, len(k.keys))}
+	for _, key := range k.keys {
+		jwk, _ := toJWK(key.Public)
+		jwk.KeyID = key.ID
+		jwk.Use = "sig"
+		jwk.Algorithm = key.Algorithm
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+func methodOf(public crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < minRSABits {
+			return nil, errors.New("rsa keys need at least 2048 bits")
+		}
+		return jwt.SigningMethodRS256, nil
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, errors.New("keys must be RSA or Ed25519")
+}
+
+// toJWK holds only the members of a key that its thumbprint is taken over.
+func toJWK(public crypto.PublicKey) (JWK, error) {
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			KeyType: "RSA",
+			N:       base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{
+			KeyType: "OKP",
+			Curve:   "Ed25519",
+			X:       base64.RawURLEncoding.EncodeToString(key),
+		}, nil
+	}
+	return JWK{}, errors.New("keys must be RSA or Ed25519")
+}
+
+// thumbprint is the RFC 7638 thumbprint of a key, the hash of its required members
+// in lexical order.
+func thumbprint(public crypto.PublicKey) (string, error) {
+	jwk, err := toJWK(public)
+	if err != nil {
+		return "", err
+	}
+
+	var members interface{}
+	if jwk.KeyType == "RSA" {
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	} else {
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X}
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("not a PEM key")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("keys must be RSA or Ed25519")
+		}
+		return signer, nil
+	}
+	return nil, errors.New("unsupported PEM block " + block.Type)
+}
+
+// parsePublicKey accepts a public key, or a private key whose public half is taken.
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("not a PEM key")
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+
+	signer, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	return signer.Public(), nil
+}
@@ -0,0 +1,69 @@
+package tokens
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory is a Revocations kept in maps, for tests that run without Redis.
+type Memory struct {
+	mu       sync.Mutex
+	revoked  map[string]time.Time
+	sessions map[uint]bool
+	cutoffs  map[uint]time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		revoked:  map[string]time.Time{},
+		sessions: map[uint]bool{},
+		cutoffs:  map[uint]time.Time{},
+	}
+}
+
+func (m *Memory) Revoke(ctx context.Context, id string, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if time.Until(expires) > 0 {
+		m.revoked[id] = expires
+	}
+	return nil
+}
+
+func (m *Memory) RevokeSession(ctx context.Context, sessionID uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[sessionID] = true
+	return nil
+}
+
+func (m *Memory) RevokeBefore(ctx context.Context, userID uint, cutoff time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cutoffs[userID] = cutoff
+	return nil
+}
+
+func (m *Memory) IsRevoked(ctx context.Context, id string, userID uint, sessionID uint, issuedAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.sessions[sessionID] {
+		return true, nil
+	}
+
+	if expires, ok := m.revoked[id]; ok {
+		if time.Until(expires) > 0 {
+			return true, nil
+		}
+		delete(m.revoked, id)
+	}
+
+	cutoff, ok := m.cutoffs[userID]
+	return ok && issuedAt.UnixMilli() <= cutoff.UnixMilli(), nil
+}
+
+func (m *Memory) Ping(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,80 @@
+package tokens
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"time"
+)
+
+// Redis keeps revocations as keys that expire once the tokens they revoke would have.
+type Redis struct {
+	client *redis.Client
+}
+
+func NewRedis(client *redis.Client) *Redis {
+	return &Redis{client: client}
+}
+
+func revokedKey(id string) string {
+	return "revoked:jti:" + id
+}
+
+func sessionKey(sessionID uint) string {
+	return "revoked:sid:" + strconv.FormatUint(uint64(sessionID), 10)
+}
+
+func cutoffKey(userID uint) string {
+	return "revoked:before:" + strconv.FormatUint(uint64(userID), 10)
+}
+
+func (r *Redis) Revoke(ctx context.Context, id string, expires time.Time) error {
+	ttl := time.Until(expires)
+	if ttl <= 0 {
+		//already expired, nothing left to revoke
+		return nil
+	}
+	return r.client.Set(ctx, revokedKey(id), 1, ttl).Err()
+}
+
+func (r *Redis) RevokeSession(ctx context.Context, sessionID uint) error {
+	return r.client.Set(ctx, sessionKey(sessionID), 1, MaxLifetime()).Err()
+}
+
+func (r *Redis) RevokeBefore(ctx context.Context, userID uint, cutoff time.Time) error {
+	return r.client.Set(ctx, cutoffKey(userID), cutoff.UnixMilli(), MaxLifetime()).Err()
+}
+
+func (r *Redis) IsRevoked(ctx context.Context, id string, userID uint, sessionID uint, issuedAt time.Time) (bool, error) {
+	var revoked *redis.IntCmd
+	var cutoff *redis.StringCmd
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		keys := []string{revokedKey(id)}
+		if sessionID > 0 {
+			keys = append(keys, sessionKey(sessionID))
+		}
+		revoked = pipe.Exists(ctx, keys...)
+		cutoff = pipe.Get(ctx, cutoffKey(userID))
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return false, err
+	}
+
+	if revoked.Val() > 0 {
+		return true, nil
+	}
+
+	if cutoff.Err() == redis.Nil {
+		return false, nil
+	}
+	millis, err := cutoff.Int64()
+	if err != nil {
+		return false, err
+	}
+	return issuedAt.UnixMilli() <= millis, nil
+}
+
+func (r *Redis) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
@@ -0,0 +1,32 @@
+package tokens
+
+import (
+	"context"
+	"github.com/golang-jwt/jwt/v4"
+	"time"
+)
+
+// Revocations lists the tokens that were logged out before they expired.
+// A token is revoked by its ID until it expires, along with every token of its session,
+// or along with every token of its user issued up to a cutoff, which logs the user out everywhere.
+type Revocations interface {
+	Revoke(ctx context.Context, id string, expires time.Time) error
+	RevokeSession(ctx context.Context, sessionID uint) error
+	RevokeBefore(ctx context.Context, userID uint, cutoff time.Time) error
+	IsRevoked(ctx context.Context, id string, userID uint, sessionID uint, issuedAt time.Time) (bool, error)
+	//Ping reports whether the list can be reached
+	Ping(ctx context.Context) error
+}
+
+// Revoked reports whether the token with these claims has been logged out. Tokens without
+// an ID or time of issue predate revocation by ID and cannot be logged out, so they are refused.
+func Revoked(ctx context.Context, list Revocations, claims jwt.MapClaims) (bool, error) {
+	id := ID(claims)
+	userID, hasUser := UserID(claims)
+	issuedAt, hasIssuedAt := IssuedAt(claims)
+	if id == "" || !hasUser || !hasIssuedAt {
+		return true, nil
+	}
+
+	return list.IsRevoked(ctx, id, userID, SessionID(claims), issuedAt)
+}
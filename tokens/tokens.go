@@ -0,0 +1,145 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+	"mvpmatch/config"
+	"mvpmatch/models"
+	"strconv"
+	"time"
+)
+
+// Lifetime returns how long an access token for the role stays valid, the role's own
+// setting when it has one and the default otherwise.
+func Lifetime(role string) time.Duration {
+	var lifetime time.Duration
+	switch role {
+	case config.Role.Seller:
+		lifetime = config.Token.SellerLifetime
+	case config.Role.Buyer:
+		lifetime = config.Token.BuyerLifetime
+	case config.Role.Admin:
+		lifetime = config.Token.AdminLifetime
+	}
+	if lifetime <= 0 {
+		return config.Token.Lifetime
+	}
+	return lifetime
+}
+
+// MaxLifetime is the longest any access token stays valid, a cutoff older than it revokes nothing.
+func MaxLifetime() time.Duration {
+	longest := config.Token.Lifetime
+	for _, role := range []string{config.Role.Seller, config.Role.Buyer, config.Role.Admin} {
+		if lifetime := Lifetime(role); lifetime > longest {
+			longest = lifetime
+		}
+	}
+	return longest
+}
+
+// RefreshLifetime is how long a session lasts without being refreshed.
+func RefreshLifetime() time.Duration {
+	return config.Token.RefreshLifetime
+}
+
+// Sign issues the access token of a user for as long as their role allows,
+// tied to a session when sessionID is set.
+func Sign(user models.User, sessionID uint) (string, error) {
+	keys := Keys()
+	if keys == nil {
+		return "", errors.New("tokens: signing keys are not loaded")
+	}
+
+	claims := jwt.MapClaims{}
+	claims["uid"] = user.ID
+	claims["rid"] = user.RoleID
+	claims["name"] = user.Username
+	claims["role"] = user.Role.Name
+	if sessionID > 0 {
+		claims["sid"] = sessionID
+	}
+	Issue(claims, Lifetime(user.Role.Name))
+
+	return keys.Sign(claims)
+}
+
+// Issue stamps claims with a new token ID, the time of issue and an expiry lifetime from now.
+// The time of issue keeps milliseconds so a logout everywhere can tell apart tokens of the same second.
+func Issue(claims jwt.MapClaims, lifetime time.Duration) {
+	now := time.Now()
+	claims["jti"] = NewID()
+	claims["iat"] = float64(now.UnixMilli()) / 1000
+	claims["exp"] = now.Add(lifetime).Unix()
+}
+
+// NewID returns a random token ID.
+func NewID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// NewRefreshToken returns an opaque refresh token and the hash it is stored under.
+func NewRefreshToken() (string, string) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		panic(err)
+	}
+	value := base64.RawURLEncoding.EncodeToString(token)
+	return value, HashRefreshToken(value)
+}
+
+// HashRefreshToken returns the hash a refresh token is stored and looked up under.
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ID returns the jti claim, empty for tokens issued before they carried one.
+func ID(claims jwt.MapClaims) string {
+	id, _ := claims["jti"].(string)
+	return id
+}
+
+// UserID returns the uid claim.
+func UserID(claims jwt.MapClaims) (uint, bool) {
+	switch uid := claims["uid"].(type) {
+	case float64:
+		return uint(uid), uid > 0
+	case string:
+		value, err := strconv.ParseUint(uid, 10, 64)
+		return uint(value), err == nil && value > 0
+	}
+	return 0, false
+}
+
+// SessionID returns the sid claim, zero for tokens that belong to no session.
+func SessionID(claims jwt.MapClaims) uint {
+	sid, _ := claims["sid"].(float64)
+	return uint(sid)
+}
+
+// IssuedAt returns the iat claim with its milliseconds.
+func IssuedAt(claims jwt.MapClaims) (time.Time, bool) {
+	iat, ok := claims["iat"].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(int64(iat * 1000)), true
+}
+
+// ExpiresAt returns the exp claim.
+func ExpiresAt(claims jwt.MapClaims) (time.Time, bool) {
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(exp), 0), true
+}
@@ -0,0 +1,81 @@
+package wallet
+
+import (
+	"github.com/pkg/errors"
+	"mvpmatch/models"
+	"mvpmatch/repository"
+)
+
+// Sources of a ledger entry, ReferenceID points at the matching record. Opening entries
+// carry the deposits users held before the ledger existed and reference nothing, adjustment
+// entries clear what an admin paid out of a deposit without the machine's coins.
+const (
+	Deposit    = "deposit"
+	Purchase   = "purchase"
+	Change     = "change"
+	Reset      = "reset"
+	Refund     = "refund"
+	Opening    = "opening"
+	Adjustment = "adjustment"
+)
+
+// ErrInsufficientBalance is returned when a debit is larger than the deposit.
+var ErrInsufficientBalance = errors.New("insufficient deposit balance")
+
+// Post writes a single credit or debit to the user's ledger and moves the
+// cached users.deposit with it. It locks the user row, so it must run inside
+// the caller's transaction.
+func Post(tx repository.Store, entry models.Wallet) (models.Wallet, error) {
+
+	if entry.Source == "" {
+		return entry, errors.New("wallet entry source is required")
+	}
+	if entry.Credit < 0 || entry.Debit < 0 {
+		return entry, errors.New("wallet entry cannot be negative")
+	}
+
+	user, err := tx.Users().Lock(entry.UserID)
+	if err != nil {
+		return entry, errors.New("user not found")
+	}
+
+	balance := user.Deposit + entry.Credit - entry.Debit
+	if balance < 0 {
+		return entry, ErrInsufficientBalance
+	}
+
+	if err := tx.Users().SetDeposit(entry.UserID, balance); err != nil {
+		return entry, err
+	}
+
+	entry.ID = 0
+	entry.Balance = balance
+	if err := tx.Wallets().Create(&entry); err != nil {
+		return entry, err
+	}
+
+	return entry, nil
+}
+
+// Mismatch is a user whose cached deposit differs from the sum of their ledger.
+type Mismatch struct {
+	UserID  uint `json:"user_id"`
+	Deposit int  `json:"deposit"`
+	Ledger  int  `json:"ledger"`
+}
+
+// Reconcile lists every user whose cached deposit has drifted from the ledger.
+func Reconcile(store repository.Store) ([]Mismatch, error) {
+
+	balances, err := store.Wallets().Drifted()
+	if err != nil {
+		return nil, err
+	}
+
+	var mismatches []Mismatch
+	for _, item := range balances {
+		mismatches = append(mismatches, Mismatch{UserID: item.UserID, Deposit: item.Deposit, Ledger: item.Ledger})
+	}
+
+	return mismatches, nil
+}